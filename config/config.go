@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/spf13/viper"
 )
@@ -15,6 +17,9 @@ type Config struct {
 	TvBoxMultiRepoOpt  TvBoxMultiRepoOpt  `mapstructure:"tvbox_multi_repo_opt"`  // TvBox多仓源配置
 	EPGOpt             EPGOpt             `mapstructure:"epg"`                   // EPG源配置
 	M3UOpt             M3UOpt             `mapstructure:"m3u"`                   // M3U源配置
+	DOHOpt             DOHOpt             `mapstructure:"doh"`                   // 内置 DoH 服务配置
+	ChannelNameOpt     ChannelNameOpt     `mapstructure:"channel_name"`          // 频道名称规范化配置
+	LogoOpt            LogoOpt            `mapstructure:"logo"`                  // 频道台标配置
 }
 
 func (c *Config) Fixture() {
@@ -42,6 +47,16 @@ func (c *Config) Fixture() {
 	for i := range c.TvBoxSingleRepoOpt.Ads {
 		c.TvBoxSingleRepoOpt.Ads[i].Field = "ads"
 	}
+	for i := range c.TvBoxSingleRepoOpt.ExtraFields {
+		extraField := &c.TvBoxSingleRepoOpt.ExtraFields[i]
+		extraField.Field = extraField.Path
+		if extraField.Field == "" {
+			extraField.Field = extraField.Key
+		}
+		if extraField.Mode == "" {
+			extraField.Mode = ExtraFieldModeReplace
+		}
+	}
 	for i := range c.TvBoxMultiRepoOpt.Repos {
 		c.TvBoxMultiRepoOpt.Repos[i].Field = "urls"
 		c.TvBoxMultiRepoOpt.Repos[i].FilterBy = "name"
@@ -58,8 +73,30 @@ func (c *Config) Fixture() {
 		c.fillFallbackSourceNameForArray(c.TvBoxSingleRepoOpt.Flags)
 		c.fillFallbackSourceNameForArray(c.TvBoxSingleRepoOpt.Rules)
 		c.fillFallbackSourceNameForArray(c.TvBoxSingleRepoOpt.Ads)
+		for i := range c.TvBoxSingleRepoOpt.ExtraFields {
+			c.fillFallbackSourceName(&c.TvBoxSingleRepoOpt.ExtraFields[i].MixOpt)
+		}
 	}
 
+	if c.TvBoxSingleRepoOpt.JarCache.Dir == "" {
+		c.TvBoxSingleRepoOpt.JarCache.Dir = filepath.Join(os.TempDir(), "tv-mixproxy", "jar")
+	}
+	if c.TvBoxSingleRepoOpt.JarCache.Interval == 0 {
+		c.TvBoxSingleRepoOpt.JarCache.Interval = 3600
+	}
+
+	c.TvBoxSingleRepoOpt.HealthCheck.fixture()
+	c.TvBoxSingleRepoOpt.SearchSite.fixture()
+	c.DOHOpt.fixture()
+	c.LogoOpt.fixture()
+	if c.M3UOpt.Dedup.M3UFallback == "" {
+		c.M3UOpt.Dedup.M3UFallback = M3UFallbackTracks
+	}
+	c.M3UOpt.HealthCheck.fixture()
+	c.M3UOpt.Relay.fixture()
+	c.M3UOpt.Numbering.fixture()
+	c.TvBoxMultiRepoOpt.HealthCheck.fixture()
+
 	// Set default interval for sources
 	for i := range c.Sources {
 		if c.Sources[i].Interval == 0 {
@@ -69,14 +106,14 @@ func (c *Config) Fixture() {
 }
 
 func (c *Config) fillFallbackSourceName(opt *MixOpt) {
-	if opt.SourceName == "" {
+	if opt.SourceName == "" && opt.SourcePattern == "" {
 		opt.SourceName = c.TvBoxSingleRepoOpt.Fallback.SourceName
 	}
 }
 
 func (c *Config) fillFallbackSourceNameForArray(opts []ArrayMixOpt) {
 	for i := range opts {
-		if opts[i].SourceName == "" {
+		if opts[i].SourceName == "" && opts[i].SourcePattern == "" {
 			opts[i].SourceName = c.TvBoxSingleRepoOpt.Fallback.SourceName
 		}
 	}
@@ -88,24 +125,125 @@ type LogOpt struct {
 }
 
 type TvBoxSingleRepoOpt struct {
-	Disable   bool          `mapstructure:"disable"` // 是否禁用单仓源
-	Spider    MixOpt        `mapstructure:"spider"`
-	Wallpaper MixOpt        `mapstructure:"wallpaper"`
-	Logo      MixOpt        `mapstructure:"logo"`
-	Sites     []ArrayMixOpt `mapstructure:"sites"`
-	DOH       []ArrayMixOpt `mapstructure:"doh"`
-	Lives     []ArrayMixOpt `mapstructure:"lives"`
-	Parses    []ArrayMixOpt `mapstructure:"parses"`
-	Flags     []ArrayMixOpt `mapstructure:"flags"`
-	Rules     []ArrayMixOpt `mapstructure:"rules"`
-	Ads       []ArrayMixOpt `mapstructure:"ads"`
-	Fallback  MixOpt        `mapstructure:"fallback"` // 降级配置
+	Disable     bool               `mapstructure:"disable"` // 是否禁用单仓源
+	Spider      MixOpt             `mapstructure:"spider"`
+	Wallpaper   MixOpt             `mapstructure:"wallpaper"`
+	Logo        MixOpt             `mapstructure:"logo"`
+	Sites       []ArrayMixOpt      `mapstructure:"sites"`
+	DOH         []ArrayMixOpt      `mapstructure:"doh"`
+	Lives       []ArrayMixOpt      `mapstructure:"lives"`
+	Parses      []ArrayMixOpt      `mapstructure:"parses"`
+	Flags       []ArrayMixOpt      `mapstructure:"flags"`
+	Rules       []ArrayMixOpt      `mapstructure:"rules"`
+	Ads         []ArrayMixOpt      `mapstructure:"ads"`
+	Fallback    MixOpt             `mapstructure:"fallback"`     // 降级配置
+	JarCache    JarCacheOpt        `mapstructure:"jar_cache"`    // spider 及站点 jar 缓存配置
+	M3ULive     M3ULiveOpt         `mapstructure:"m3u_live"`     // 生成指向本服务 M3U 播放列表的 lives 条目
+	HealthCheck SiteHealthCheckOpt `mapstructure:"health_check"` // 站点与解析健康检查配置
+	SearchSite  SearchSiteOpt      `mapstructure:"search_site"`  // 聚合搜索站点配置
+	// 通用字段混合配置，可混合任意顶层字段，例如 ijk/hosts/headers/drives 等
+	ExtraFields []ExtraFieldMixOpt `mapstructure:"extra_fields"`
+}
+
+// SiteHealthCheckOpt 站点与解析健康检查配置
+// 检查结果配合 sites/parses 的 min_health 排除不可用的条目
+type SiteHealthCheckOpt struct {
+	Enable   bool `mapstructure:"enable"`   // 是否启用健康检查
+	Interval int  `mapstructure:"interval"` // 检查间隔，单位为秒, 默认 600 秒
+}
+
+func (o *SiteHealthCheckOpt) fixture() {
+	if o.Interval == 0 {
+		o.Interval = 600
+	}
+}
+
+// SearchSiteOpt 聚合搜索站点配置
+// 启用后会在 sites 开头加入一个由本服务实现的 CMS 站点，搜索时并发请求混合后的 type 1 站点并合并结果
+type SearchSiteOpt struct {
+	Enable   bool   `mapstructure:"enable"`    // 是否启用聚合搜索站点
+	Key      string `mapstructure:"key"`       // 站点 key, 默认 tv_mixproxy_search
+	Name     string `mapstructure:"name"`      // 站点名称, 默认 聚合搜索
+	FilterBy string `mapstructure:"filter_by"` // 参与搜索站点的过滤依据, key/name, 默认 key
+	Include  string `mapstructure:"include"`   // 包含, 正则
+	Exclude  string `mapstructure:"exclude"`   // 排除, 正则
+	Timeout  int    `mapstructure:"timeout"`   // 单个上游站点的超时时间，单位为秒, 默认 8 秒
+}
+
+func (o *SearchSiteOpt) fixture() {
+	if o.Key == "" {
+		o.Key = "tv_mixproxy_search"
+	}
+	if o.Name == "" {
+		o.Name = "聚合搜索"
+	}
+	if o.FilterBy == "" {
+		o.FilterBy = "key"
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 8
+	}
+}
+
+type M3ULiveOpt struct {
+	Enable bool   `mapstructure:"enable"` // 是否在 lives 中加入本服务的 M3U 媒体播放列表
+	Name   string `mapstructure:"name"`   // lives 条目名称, 默认 Tv MixProxy
+}
+
+type ExtraFieldMode string
+
+const (
+	ExtraFieldModeReplace ExtraFieldMode = "replace" // 替换, 适用于标量
+	ExtraFieldModeAppend  ExtraFieldMode = "append"  // 追加, 适用于数组
+	ExtraFieldModeFilter  ExtraFieldMode = "filter"  // 过滤后追加, 适用于数组
+	ExtraFieldModeMerge   ExtraFieldMode = "merge"   // 深度合并, 适用于对象
+)
+
+type ExtraFieldMixOpt struct {
+	ArrayMixOpt `mapstructure:",squash"`
+	Key         string         `mapstructure:"key"`  // 输出字段名
+	Path        string         `mapstructure:"path"` // 源中的 gjson 路径, 默认与 key 相同
+	Mode        ExtraFieldMode `mapstructure:"mode"` // 混合方式, 默认 replace
+}
+
+type JarCacheOpt struct {
+	Enable   bool   `mapstructure:"enable"`   // 是否启用 jar 缓存代理
+	Dir      string `mapstructure:"dir"`      // 缓存目录, 默认为系统临时目录下的 tv-mixproxy/jar
+	Interval int    `mapstructure:"interval"` // jar 更新频率，单位为秒, 默认 3600 秒
 }
 
 type TvBoxMultiRepoOpt struct {
-	Disable           bool          `mapstructure:"disable"`             // 是否禁用多仓源
-	IncludeSingleRepo bool          `mapstructure:"include_single_repo"` // 是否包含代理的单仓源
-	Repos             []ArrayMixOpt `mapstructure:"repos"`               // 仓库配置
+	Disable           bool           `mapstructure:"disable"`             // 是否禁用多仓源
+	IncludeSingleRepo bool           `mapstructure:"include_single_repo"` // 是否包含代理的单仓源
+	Repos             []ArrayMixOpt  `mapstructure:"repos"`               // 仓库配置
+	HealthCheck       HealthCheckOpt `mapstructure:"health_check"`        // 仓库健康检查配置
+}
+
+type HealthCheckAction string
+
+const (
+	HealthCheckActionNone HealthCheckAction = "none" // 仅记录检查结果
+	HealthCheckActionDrop HealthCheckAction = "drop" // 移除不可用的条目
+	HealthCheckActionMark HealthCheckAction = "mark" // 在不可用的条目名称后追加标记
+)
+
+type HealthCheckOpt struct {
+	Enable   bool              `mapstructure:"enable"`   // 是否启用健康检查
+	Interval int               `mapstructure:"interval"` // 检查间隔，单位为秒, 默认 600 秒
+	Action   HealthCheckAction `mapstructure:"action"`   // 对不可用条目的处理方式, none/drop/mark, 默认 none
+	Mark     string            `mapstructure:"mark"`     // mark 时追加的标记, 默认 " ❌"
+}
+
+func (o *HealthCheckOpt) fixture() {
+	if o.Interval == 0 {
+		o.Interval = 600
+	}
+	if o.Action == "" {
+		o.Action = HealthCheckActionNone
+	}
+	if o.Mark == "" {
+		o.Mark = " ❌"
+	}
 }
 
 type EPGFilterType string
@@ -121,6 +259,10 @@ type EPGOpt struct {
 	// 可根据 channel_id 或者 program_title 过滤
 	// 支持多个源
 	Filters []ArrayMixOpt `mapstructure:"filters"`
+
+	M3UTvgURL bool `mapstructure:"m3u_tvg_url"` // 在 M3U 输出中加入指向本服务 EPG 的 x-tvg-url
+	LiveEPG   bool `mapstructure:"live_epg"`    // 为混合后未声明 epg 的 lives 设置指向本服务的 epg 接口
+	LiveLogo  bool `mapstructure:"live_logo"`   // 为混合后未声明 logo 的 lives 设置指向本服务的 logo 接口
 }
 
 type M3UOpt struct {
@@ -128,12 +270,202 @@ type M3UOpt struct {
 	MediaPlaylistFallback MixOpt        `mapstructure:"media_playlist_fallback"` // 媒体播放列表降级配置
 	MediaPlaylistFilters  []ArrayMixOpt `mapstructure:"media_playlist_filters"`  // 媒体播放列表过滤配置
 	// MasterPlaylistFilters []ArrayMixOpt `mapstructure:"master_playlist_filters"` // 主播放列表过滤配置
+	Dedup       M3UDedupOpt          `mapstructure:"dedup"`        // 频道去重配置
+	HealthCheck StreamHealthCheckOpt `mapstructure:"health_check"` // 轨道健康检查配置
+	Groups      M3UGroupOpt          `mapstructure:"groups"`       // 分组规则配置
+	Numbering   ChannelNumberOpt     `mapstructure:"numbering"`    // 频道编号 (tvg-chno) 配置
+	Relay       StreamRelayOpt       `mapstructure:"relay"`        // 轨道中转配置
+}
+
+type ChannelNumberMode string
+
+const (
+	ChannelNumberKeep  ChannelNumberMode = "keep"  // 保留上游的 tvg-chno, 仅为没有编号或编号冲突的频道分配编号
+	ChannelNumberGroup ChannelNumberMode = "group" // 忽略上游编号, 按分组从 group_bases 配置的起始编号分配
+)
+
+// ChannelNumberOpt 频道编号配置
+// 分配过的编号会保存到 state_file, 刷新或重启后同一频道保持相同的编号
+type ChannelNumberOpt struct {
+	Enable      bool              `mapstructure:"enable"`       // 是否为频道设置 tvg-chno
+	Mode        ChannelNumberMode `mapstructure:"mode"`         // 编号方式, keep/group, 默认 keep
+	Start       int               `mapstructure:"start"`        // 未配置起始编号的分组使用的起始编号, 默认 1
+	GroupBases  []GroupNumberBase `mapstructure:"group_bases"`  // 分组起始编号, 仅 group 方式生效
+	MappingFile string            `mapstructure:"mapping_file"` // 固定编号文件, 每行为 "编号,频道名称", 优先于其他规则
+	StateFile   string            `mapstructure:"state_file"`   // 已分配编号的保存文件, 启用时必须配置, 应位于持久化的目录
+}
+
+type GroupNumberBase struct {
+	Group string `mapstructure:"group"` // 分组名称
+	Base  int    `mapstructure:"base"`  // 起始编号
+}
+
+func (o *ChannelNumberOpt) fixture() {
+	if o.Mode == "" {
+		o.Mode = ChannelNumberKeep
+	}
+	if o.Start == 0 {
+		o.Start = 1
+	}
+}
+
+// M3UGroupOpt 分组规则配置, 在混合所有源并去重之后应用
+type M3UGroupOpt struct {
+	Assign       []GroupAssignRule   `mapstructure:"assign"`        // 按频道名称将频道分配到指定分组, 优先于 rename
+	Rename       []GroupRenameRule   `mapstructure:"rename"`        // 按正则重命名分组, 多个分组重命名为同一名称即合并
+	Order        []string            `mapstructure:"order"`         // 分组顺序, 未列出的分组按首次出现的顺序排在之后
+	ChannelOrder []GroupChannelOrder `mapstructure:"channel_order"` // 分组内的频道顺序
+	ChannelSort  string              `mapstructure:"channel_sort"`  // 未在 channel_order 中匹配的频道的排序方式, 为空时保持原顺序, name: 按名称自然排序
+}
+
+type GroupAssignRule struct {
+	Match string `mapstructure:"match"` // 频道名称正则
+	Group string `mapstructure:"group"` // 分配到的分组名称
+}
+
+type GroupRenameRule struct {
+	Match string `mapstructure:"match"` // 分组名称正则, 使用第一个匹配的规则
+	Name  string `mapstructure:"name"`  // 新的分组名称, 支持 $1 等引用捕获组
+}
+
+type GroupChannelOrder struct {
+	Group    string   `mapstructure:"group"`    // 分组名称, 为重命名之后的名称
+	Channels []string `mapstructure:"channels"` // 频道名称正则, 按列出的顺序排列, 未匹配的频道排在之后
+}
+
+type M3UFallbackMode string
+
+const (
+	M3UFallbackTracks M3UFallbackMode = "tracks" // 每个备用地址输出为一个同名轨道
+	M3UFallbackVLCOpt M3UFallbackMode = "vlcopt" // 只输出一个轨道, 备用地址以 #EXTVLCOPT:fallback-url 输出
+)
+
+// M3UDedupOpt 频道去重配置
+// 按 tvg-id (为空时按规范化后的频道名) 将多个源中的同一频道合并, 其余地址按源优先级作为备用地址
+type M3UDedupOpt struct {
+	Enable         bool            `mapstructure:"enable"`          // 是否启用频道去重
+	SourcePriority []string        `mapstructure:"source_priority"` // 源优先级, 越靠前优先级越高, 未列出的源按混合顺序排在之后
+	MaxAlternates  int             `mapstructure:"max_alternates"`  // 每个频道最多保留的备用地址数, 0 表示不限制
+	M3UFallback    M3UFallbackMode `mapstructure:"m3u_fallback"`    // M3U 输出备用地址的方式, tracks/vlcopt, 默认 tracks
+}
+
+// StreamHealthCheckOpt M3U 轨道健康检查配置
+// 定期请求混合后的每个轨道地址, HLS 地址会请求媒体播放列表及一个分片, 其余地址只读取开头的部分数据
+type StreamHealthCheckOpt struct {
+	Enable         bool  `mapstructure:"enable"`          // 是否启用轨道健康检查
+	Interval       int   `mapstructure:"interval"`        // 检查间隔，单位为秒, 默认 1800 秒
+	Timeout        int   `mapstructure:"timeout"`         // 单个轨道的超时时间，单位为秒, 默认 10 秒
+	Concurrency    int   `mapstructure:"concurrency"`     // 同时检查的轨道数, 默认 8
+	MaxBytes       int64 `mapstructure:"max_bytes"`       // 每个分片或非 HLS 地址最多读取的字节数, 默认 65536
+	RateLimit      int   `mapstructure:"rate_limit"`      // 所有检查合计的下载速率上限，单位为 KiB/s, 0 表示不限制
+	DropDead       bool  `mapstructure:"drop_dead"`       // 移除最近一次检查不可用的轨道
+	SortAlternates bool  `mapstructure:"sort_alternates"` // 启用频道去重时按首字节时间排列同一频道的地址, 源优先级作为次要依据
+}
+
+func (o *StreamHealthCheckOpt) fixture() {
+	if o.Interval == 0 {
+		o.Interval = 1800
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 10
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = 8
+	}
+	if o.MaxBytes == 0 {
+		o.MaxBytes = 64 << 10
+	}
+}
+
+// StreamRelayOpt 轨道中转配置
+// 选中的轨道改写为 /v1/m3u/relay 地址, 由本服务携带请求头请求上游, HLS 播放列表中的地址同样改写为中转地址
+type StreamRelayOpt struct {
+	Enable     bool              `mapstructure:"enable"`      // 是否启用中转
+	Conditions []FilterCondition `mapstructure:"conditions"`  // 需要中转的轨道, 与过滤条件写法相同, 为空时中转所有轨道
+	Match      FilterMatch       `mapstructure:"match"`       // 多个条件的组合方式, all/any, 默认 all
+	Headers    map[string]string `mapstructure:"headers"`     // 请求上游时附加的请求头, 轨道 #EXTVLCOPT 中的 http-user-agent 与 http-referrer 优先
+	Secret     string            `mapstructure:"secret"`      // 中转地址的签名密钥, 启用中转时必填, 更换后旧地址失效
+	Timeout    int               `mapstructure:"timeout"`     // 等待上游响应及每次读取上游数据的超时时间，单位为秒, 默认 10 秒
+	BufferSize int               `mapstructure:"buffer_size"` // 转发分片时的缓冲区大小，单位为 KiB, 默认 64
+}
+
+func (o *StreamRelayOpt) fixture() {
+	if o.Timeout == 0 {
+		o.Timeout = 10
+	}
+	if o.BufferSize == 0 {
+		o.BufferSize = 64
+	}
+}
+
+// DOHOpt 内置 DNS-over-HTTPS 服务配置, 服务地址为 /dns-query
+type DOHOpt struct {
+	Enable    bool     `mapstructure:"enable"`     // 是否启用内置 DoH 服务
+	Upstreams []string `mapstructure:"upstreams"`  // 上游解析器, 按顺序尝试, 支持 https:// 与 udp://host:port
+	Timeout   int      `mapstructure:"timeout"`    // 单个上游的超时时间，单位为秒, 默认 5 秒
+	CacheSize int      `mapstructure:"cache_size"` // 最大缓存条目数, 默认 4096, -1 表示不缓存
+	Inject    bool     `mapstructure:"inject"`     // 是否将本服务作为第一个 doh 条目加入单仓
+	Name      string   `mapstructure:"name"`       // 注入的 doh 条目名称, 默认 Tv MixProxy
+}
+
+func (o *DOHOpt) fixture() {
+	if len(o.Upstreams) == 0 {
+		o.Upstreams = []string{"https://doh.pub/dns-query", "https://dns.alidns.com/dns-query"}
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 5
+	}
+	if o.CacheSize == 0 {
+		o.CacheSize = 4096
+	}
+	if o.Name == "" {
+		o.Name = "Tv MixProxy"
+	}
+}
+
+// ChannelNameOpt 频道名称规范化配置
+// 启用后 M3U、EPG 与 TvBox lives 使用规范化后的频道名称与 ID, 使不同来源中同一频道的不同写法可以互相匹配
+type ChannelNameOpt struct {
+	Enable    bool   `mapstructure:"enable"`     // 是否启用频道名称规范化
+	AliasFile string `mapstructure:"alias_file"` // 别名字典文件, 每行为 "规范名称,别名1,别名2", # 开头为注释
+}
+
+// LogoOpt 频道台标配置
+// 为缺少 tvg-logo 的 M3U 轨道补全台标, TvBox lives 通过 /v1/epg/logo 使用相同的台标
+type LogoOpt struct {
+	FromEPG     bool   `mapstructure:"from_epg"`    // 使用 EPG 中频道的 icon 补全台标
+	Dir         string `mapstructure:"dir"`         // 本地台标目录, 文件名为频道名称, 按规范化后的名称匹配, 优先于 EPG
+	Proxy       bool   `mapstructure:"proxy"`       // 将所有台标改写为 /v1/logos/{id}, 由本服务拉取、缓存并缩放
+	Placeholder bool   `mapstructure:"placeholder"` // 仍找不到台标时使用 /v1/logos/badge 生成的占位台标
+	CacheDir    string `mapstructure:"cache_dir"`   // 缓存目录, 默认为系统临时目录下的 tv-mixproxy/logo
+	Size        int    `mapstructure:"size"`        // 缩放后的最大边长, 默认 256, -1 表示不缩放
+	Interval    int    `mapstructure:"interval"`    // 缓存更新频率，单位为秒, 默认 86400 秒
+}
+
+// Enabled 返回是否需要处理台标
+func (o *LogoOpt) Enabled() bool {
+	return o.FromEPG || o.Dir != "" || o.Proxy || o.Placeholder
+}
+
+func (o *LogoOpt) fixture() {
+	if o.CacheDir == "" {
+		o.CacheDir = filepath.Join(os.TempDir(), "tv-mixproxy", "logo")
+	}
+	if o.Size == 0 {
+		o.Size = 256
+	}
+	if o.Interval == 0 {
+		o.Interval = 86400
+	}
 }
 
 type MixOpt struct {
 	SourceName string `mapstructure:"source_name"`
-	Field      string `mapstructure:"field"`    // 内部使用，无需配置
-	Disabled   bool   `mapstructure:"disabled"` // 是否禁用该字段
+	// 源名称正则, 用于引用多仓展开后的动态源, 例如 ^multi_source/
+	// 数组字段会混合所有匹配的源, 单个字段使用按名称排序后的第一个匹配源
+	SourcePattern string `mapstructure:"source_pattern"`
+	Field         string `mapstructure:"field"`    // 内部使用，无需配置
+	Disabled      bool   `mapstructure:"disabled"` // 是否禁用该字段
 }
 
 type ArrayMixOpt struct {
@@ -141,6 +473,30 @@ type ArrayMixOpt struct {
 	FilterBy string `mapstructure:"filter_by"` // 过滤依据 key
 	Include  string `mapstructure:"include"`   // 包含, 正则
 	Exclude  string `mapstructure:"exclude"`   // 排除, 正则
+	// 最低健康度, 0 到 1 之间, 仅对启用健康检查的 sites/parses 生效, 0 表示不过滤
+	MinHealth float64 `mapstructure:"min_health"`
+	// 多条件过滤, 仅对 M3U 轨道与变体流生效, filter_by 不为空时作为第一个条件参与组合
+	Conditions []FilterCondition `mapstructure:"conditions"`
+	Match      FilterMatch       `mapstructure:"match"` // 多个条件的组合方式, all/any, 默认 all
+}
+
+type FilterMatch string
+
+const (
+	FilterMatchAll FilterMatch = "all" // 全部条件满足
+	FilterMatchAny FilterMatch = "any" // 任一条件满足
+)
+
+// FilterCondition 过滤条件
+// M3U 轨道的 filter_by 可以为 name、uri、uri_host 或任意标签名 (如 group-title、tvg-id、tvg-country), 下划线视为连字符
+// 变体流的 filter_by 可以为 name、uri、uri_host、bandwidth、resolution、codecs 等属性名
+// 标签条件只作用于轨道, 变体流属性条件只作用于变体流, 无法识别的 filter_by 按 name 处理
+type FilterCondition struct {
+	FilterBy string  `mapstructure:"filter_by"` // 过滤依据
+	Include  string  `mapstructure:"include"`   // 包含, 正则
+	Exclude  string  `mapstructure:"exclude"`   // 排除, 正则
+	Min      float64 `mapstructure:"min"`       // 数值下限, 0 表示不限制, resolution 按高度比较
+	Max      float64 `mapstructure:"max"`       // 数值上限, 0 表示不限制
 }
 
 type Source struct {
@@ -148,6 +504,8 @@ type Source struct {
 	URL      string     `mapstructure:"url"`      // 源地址
 	Type     SourceType `mapstructure:"type"`     // 源类型
 	Interval int        `mapstructure:"interval"` // 源更新频率，单位为秒, 默认 60 秒
+	// 仅对 tvbox_multi 有效, 将多仓中的每个仓库展开为名为 {name}/{仓库名} 的动态单仓源
+	Expand bool `mapstructure:"expand"`
 }
 
 type SourceType string
@@ -157,6 +515,8 @@ const (
 	SourceTypeTvBoxMulti  SourceType = "tvbox_multi"  // tvbox多仓源
 	SourceTypeEPG         SourceType = "epg"          // epg源
 	SourceTypeM3U         SourceType = "m3u"          // m3u源
+	SourceTypeLiveTXT     SourceType = "live_txt"     // TXT 直播源列表, "分组,#genre#" 与 "频道名,地址#备用地址"
+	SourceTypeAdblock     SourceType = "adblock"      // 广告拦截列表源, 支持 hosts/Adblock/dnsmasq/纯域名列表, 用于 ads
 )
 
 func LoadServerConfig(cfgFile string) (*Config, error) {
@@ -197,9 +557,24 @@ func UnmarshalConfig(v *viper.Viper) (*Config, error) {
 		return nil, fmt.Errorf("unable to decode into struct: %v", err)
 	}
 	cfg.Fixture()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
+// validate 检查 Fixture 无法修正的配置错误
+func (c *Config) validate() error {
+	for _, extraField := range c.TvBoxSingleRepoOpt.ExtraFields {
+		switch extraField.Mode {
+		case ExtraFieldModeReplace, ExtraFieldModeAppend, ExtraFieldModeFilter, ExtraFieldModeMerge:
+		default:
+			return fmt.Errorf("extra_fields %s: unknown mode %q", extraField.Key, extraField.Mode)
+		}
+	}
+	return nil
+}
+
 func DefaultConfig() *Config {
 	return &Config{
 		ServerPort: 8080,
@@ -1 +1,18 @@
 package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateExtraFields(t *testing.T) {
+	cfg := &Config{TvBoxSingleRepoOpt: TvBoxSingleRepoOpt{
+		ExtraFields: []ExtraFieldMixOpt{{Key: "ijk"}, {Key: "drives", Mode: ExtraFieldModeFilter}},
+	}}
+	cfg.Fixture()
+	assert.NoError(t, cfg.validate())
+
+	cfg.TvBoxSingleRepoOpt.ExtraFields[1].Mode = "appned"
+	assert.ErrorContains(t, cfg.validate(), `extra_fields drives: unknown mode "appned"`)
+}
@@ -25,12 +25,23 @@ func GetDefaultHttpClient() *http.Client {
 }
 
 func FetchData(uri string) ([]byte, error) {
+	return FetchDataLimit(uri, 0)
+}
+
+// FetchDataLimit 与 FetchData 相同, 但内容超过 limit 字节时返回错误, limit 为 0 时不限制
+func FetchDataLimit(uri string, limit int64) ([]byte, error) {
 	var data []byte
 	var err error
 
 	if strings.HasPrefix(uri, "file://") {
 		// Load from local file
-		data, err = os.ReadFile(strings.TrimPrefix(uri, "file://"))
+		var f *os.File
+		f, err = os.Open(strings.TrimPrefix(uri, "file://"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read data: %v", err)
+		}
+		defer f.Close()
+		data, err = readAllLimit(f, limit)
 	} else if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
 		// Load from network URL
 		client := GetDefaultHttpClient()
@@ -44,7 +55,7 @@ func FetchData(uri string) ([]byte, error) {
 			return nil, fmt.Errorf("failed to fetch data from URL: %s", resp.Status)
 		}
 
-		data, err = io.ReadAll(resp.Body)
+		data, err = readAllLimit(resp.Body, limit)
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to read data: %v", err)
@@ -59,3 +70,17 @@ func FetchData(uri string) ([]byte, error) {
 
 	return data, nil
 }
+
+func readAllLimit(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("data exceeds %d bytes", limit)
+	}
+	return data, nil
+}
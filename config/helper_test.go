@@ -0,0 +1,34 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchDataLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1024))
+	}))
+	defer upstream.Close()
+
+	data, err := FetchDataLimit(upstream.URL, 1024)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1024)
+
+	_, err = FetchDataLimit(upstream.URL, 1023)
+	assert.ErrorContains(t, err, "exceeds 1023 bytes")
+
+	data, err = FetchData(upstream.URL)
+	assert.NoError(t, err)
+	assert.Len(t, data, 1024)
+
+	file := filepath.Join(t.TempDir(), "data")
+	assert.NoError(t, os.WriteFile(file, make([]byte, 16), 0o644))
+	_, err = FetchDataLimit("file://"+file, 8)
+	assert.ErrorContains(t, err, "exceeds 8 bytes")
+}
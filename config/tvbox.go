@@ -41,34 +41,53 @@ type TvBoxMultiRepoConfig struct {
 type TvBoxRepoURLConfig struct {
 	URL  string `json:"url"`
 	Name string `json:"name"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
 }
 
 type TvBoxRepoConfig struct {
-	Spider    string       `json:"spider,omitempty"`
-	Lives     []TvBoxLive  `json:"lives,omitempty"`
-	Wallpaper string       `json:"wallpaper,omitempty"`
-	Sites     []TvBoxSite  `json:"sites,omitempty"`
-	Parses    []TvBoxParse `json:"parses,omitempty"`
-	Flags     []string     `json:"flags,omitempty"`
-	DOH       []TvBoxDOH   `json:"doh,omitempty"`
-	Rules     []TvBoxRule  `json:"rules,omitempty"`
-	Ads       []string     `json:"ads,omitempty"`
-	Logo      string       `json:"logo,omitempty"` // 保留原有字段
+	Spider      string        `json:"spider,omitempty"`
+	Lives       []TvBoxLive   `json:"lives,omitempty"`
+	Wallpaper   string        `json:"wallpaper,omitempty"`
+	Sites       []TvBoxSite   `json:"sites,omitempty"`
+	Parses      []TvBoxParse  `json:"parses,omitempty"`
+	Flags       []string      `json:"flags,omitempty"`
+	DOH         []TvBoxDOH    `json:"doh,omitempty"`
+	Rules       []TvBoxRule   `json:"rules,omitempty"`
+	Ads         []string      `json:"ads,omitempty"`
+	Logo        string        `json:"logo,omitempty"` // 保留原有字段
+	Hosts       []string      `json:"hosts,omitempty"`
+	Headers     []TvBoxHeader `json:"headers,omitempty"`
+	Proxy       []TvBoxProxy  `json:"proxy,omitempty"`
+	IJK         []TvBoxIJK    `json:"ijk,omitempty"`
+	Drives      []TvBoxDrive  `json:"drives,omitempty"`
+	WarningText string        `json:"warningText,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
+
+	// JarInjectedSites 记录混合时被自动注入 jar 的站点 key，不输出到 JSON
+	JarInjectedSites []string `json:"-"`
 }
 
 type TvBoxSite struct {
-	Key         string  `json:"key"`
-	Name        string  `json:"name"`
-	Type        FlexInt `json:"type"`
-	API         string  `json:"api,omitempty"`
-	Searchable  FlexInt `json:"searchable,omitempty"`
-	QuickSearch FlexInt `json:"quickSearch,omitempty"`
-	Filterable  FlexInt `json:"filterable,omitempty"`
-	Ext         any     `json:"ext,omitempty"`
-	Jar         string  `json:"jar,omitempty"`
-	PlayerType  FlexInt `json:"playerType,omitempty"`
-	Changeable  FlexInt `json:"changeable,omitempty"`
-	Timeout     FlexInt `json:"timeout,omitempty"`
+	Key         string            `json:"key"`
+	Name        string            `json:"name"`
+	Type        FlexInt           `json:"type"`
+	API         string            `json:"api,omitempty"`
+	Searchable  FlexInt           `json:"searchable,omitempty"`
+	QuickSearch FlexInt           `json:"quickSearch,omitempty"`
+	Filterable  FlexInt           `json:"filterable,omitempty"`
+	Ext         any               `json:"ext,omitempty"`
+	Jar         string            `json:"jar,omitempty"`
+	PlayerType  FlexInt           `json:"playerType,omitempty"`
+	Changeable  FlexInt           `json:"changeable,omitempty"`
+	Timeout     FlexInt           `json:"timeout,omitempty"`
+	Style       *TvBoxStyle       `json:"style,omitempty"`
+	Categories  []string          `json:"categories,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	Click       string            `json:"click,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
 }
 
 type TvBoxStyle struct {
@@ -80,17 +99,30 @@ type TvBoxDOH struct {
 	Name string   `json:"name"`
 	URL  string   `json:"url"`
 	IPs  []string `json:"ips"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
 }
 
 type TvBoxLive struct {
-	Name       string  `json:"name"`
-	Type       FlexInt `json:"type"`
-	URL        string  `json:"url"`
-	PlayerType FlexInt `json:"playerType"`
-	UA         string  `json:"ua,omitempty"`
-	EPG        string  `json:"epg,omitempty"`
-	Logo       string  `json:"logo,omitempty"`
-	Timeout    FlexInt `json:"timeout,omitempty"`
+	Name       string             `json:"name,omitempty"`
+	Type       *FlexInt           `json:"type,omitempty"` // 未声明时为 nil，序列化时不输出
+	URL        string             `json:"url,omitempty"`
+	PlayerType *FlexInt           `json:"playerType,omitempty"`
+	UA         string             `json:"ua,omitempty"`
+	EPG        string             `json:"epg,omitempty"`
+	Logo       string             `json:"logo,omitempty"`
+	Timeout    FlexInt            `json:"timeout,omitempty"`
+	Group      string             `json:"group,omitempty"`    // 旧版直接内嵌频道的分组名
+	Channels   []TvBoxLiveChannel `json:"channels,omitempty"` // 旧版直接内嵌的频道
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
+}
+
+type TvBoxLiveChannel struct {
+	Name string   `json:"name"`
+	URLs []string `json:"urls"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
 }
 
 type TvBoxParse struct {
@@ -98,6 +130,8 @@ type TvBoxParse struct {
 	Type FlexInt `json:"type"`
 	URL  string  `json:"url"`
 	Ext  any     `json:"ext,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
 }
 
 type TvBoxRule struct {
@@ -105,6 +139,48 @@ type TvBoxRule struct {
 	Hosts  []string `json:"hosts"`
 	Regex  []string `json:"regex,omitempty"`
 	Script []string `json:"script,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
+}
+
+type TvBoxHeader struct {
+	Host   string            `json:"host"`
+	Header map[string]string `json:"header"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
+}
+
+type TvBoxProxy struct {
+	Name  string   `json:"name,omitempty"`
+	Hosts []string `json:"hosts,omitempty"`
+	URLs  []string `json:"urls,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
+}
+
+type TvBoxIJK struct {
+	Group   string           `json:"group"`
+	Options []TvBoxIJKOption `json:"options"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
+}
+
+type TvBoxIJKOption struct {
+	Category FlexInt `json:"category"`
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
+}
+
+type TvBoxDrive struct {
+	Name   string `json:"name"`
+	Type   any    `json:"type,omitempty"`
+	API    string `json:"api,omitempty"`
+	Server string `json:"server,omitempty"`
+	Ext    any    `json:"ext,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"` // 未识别的字段，原样保留
 }
 
 func LoadTvBoxData(uri string) ([]byte, error) {
@@ -0,0 +1,364 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+// TvBox 配置的各个结构体只对常用字段建模，其余字段保存在 Extra 中，
+// 序列化时原样输出，保证混合前后不会丢失上游的私有字段。
+
+var knownFieldsCache sync.Map // map[reflect.Type][]string
+
+// knownFields 返回结构体通过 json tag 声明的字段名
+func knownFields(t reflect.Type) []string {
+	if fields, ok := knownFieldsCache.Load(t); ok {
+		return fields.([]string)
+	}
+
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+
+	knownFieldsCache.Store(t, fields)
+	return fields
+}
+
+// fieldIndex 返回 json 字段名为 key 的结构体字段下标, 不存在时返回 -1
+func fieldIndex(t reflect.Type, key string) int {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.IsExported() && name != "-" && strings.EqualFold(name, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isKnownField(fields []string, key string) bool {
+	for _, field := range fields {
+		// encoding/json 匹配字段名时不区分大小写
+		if strings.EqualFold(field, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshalWithExtra 解析 JSON 到 v，并将未识别的字段保存到 extra
+// 已建模字段的类型与上游不一致时 (例如 categories 为对象), 该字段同样原样保存到 extra,
+// 不会导致整个仓库解析失败
+func unmarshalWithExtra(data []byte, v any, extra *map[string]json.RawMessage) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	fields := knownFields(reflect.TypeOf(v).Elem())
+	strict := json.Unmarshal(data, v) == nil
+	if !strict {
+		// 丢弃严格解析时部分写入的值, 再逐个字段解析
+		elem := reflect.ValueOf(v).Elem()
+		elem.Set(reflect.Zero(elem.Type()))
+	}
+
+	*extra = nil
+	for key, value := range raw {
+		if isKnownField(fields, key) && (strict || unmarshalField(key, value, v) == nil) {
+			continue
+		}
+		if *extra == nil {
+			*extra = make(map[string]json.RawMessage)
+		}
+		(*extra)[key] = value
+	}
+
+	return nil
+}
+
+// unmarshalField 只解析 v 的单个字段
+func unmarshalField(key string, value json.RawMessage, v any) error {
+	name, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 0, len(name)+len(value)+3)
+	data = append(data, '{')
+	data = append(data, name...)
+	data = append(data, ':')
+	data = append(data, value...)
+	data = append(data, '}')
+	return json.Unmarshal(data, v)
+}
+
+// marshalWithExtra 序列化 v 并附加 extra 中的字段
+// extra 中与已建模字段同名的值会覆盖原值，其余字段按 key 排序追加在末尾
+func marshalWithExtra(v any, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+	buf.WriteByte('{')
+
+	written := make(map[string]bool, len(extra))
+	gjson.ParseBytes(data).ForEach(func(key, value gjson.Result) bool {
+		raw := value.Raw
+		if override, ok := extra[key.Str]; ok {
+			raw = string(override)
+			written[key.Str] = true
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(key.Raw)
+		buf.WriteByte(':')
+		buf.WriteString(raw)
+		return true
+	})
+
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		if !written[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(extra[key])
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// RawField 返回顶层字段 key 当前的 JSON 值, 依次查找 Extra 与已建模字段, 空的已建模字段视为不存在
+func (v *TvBoxRepoConfig) RawField(key string) (json.RawMessage, bool) {
+	if value, ok := v.Extra[key]; ok {
+		return value, true
+	}
+	if !isKnownField(knownFields(reflect.TypeOf(*v)), key) {
+		return nil, false
+	}
+
+	type alias TvBoxRepoConfig
+	data, err := json.Marshal((*alias)(v))
+	if err != nil {
+		return nil, false
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, false
+	}
+	for name, value := range fields {
+		if strings.EqualFold(name, key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// SetRawField 设置顶层字段 key 的 JSON 值, 已建模字段解析到对应的结构体字段, 解析失败时与其余字段一样保存到 Extra
+func (v *TvBoxRepoConfig) SetRawField(key string, value json.RawMessage) {
+	type alias TvBoxRepoConfig
+	if index := fieldIndex(reflect.TypeOf(*v), key); index >= 0 {
+		// 先解析到新的结构体, 避免复用原有切片元素时残留旧值
+		var decoded alias
+		if unmarshalField(key, value, &decoded) == nil {
+			reflect.ValueOf(v).Elem().Field(index).Set(reflect.ValueOf(decoded).Field(index))
+			delete(v.Extra, key)
+			return
+		}
+	}
+
+	if v.Extra == nil {
+		v.Extra = make(map[string]json.RawMessage)
+	}
+	v.Extra[key] = value
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxRepoConfig) UnmarshalJSON(data []byte) error {
+	type alias TvBoxRepoConfig
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxRepoConfig) MarshalJSON() ([]byte, error) {
+	type alias TvBoxRepoConfig
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxRepoURLConfig) UnmarshalJSON(data []byte) error {
+	type alias TvBoxRepoURLConfig
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxRepoURLConfig) MarshalJSON() ([]byte, error) {
+	type alias TvBoxRepoURLConfig
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxSite) UnmarshalJSON(data []byte) error {
+	type alias TvBoxSite
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxSite) MarshalJSON() ([]byte, error) {
+	type alias TvBoxSite
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxDOH) UnmarshalJSON(data []byte) error {
+	type alias TvBoxDOH
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxDOH) MarshalJSON() ([]byte, error) {
+	type alias TvBoxDOH
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxLive) UnmarshalJSON(data []byte) error {
+	type alias TvBoxLive
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxLive) MarshalJSON() ([]byte, error) {
+	type alias TvBoxLive
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxLiveChannel) UnmarshalJSON(data []byte) error {
+	type alias TvBoxLiveChannel
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxLiveChannel) MarshalJSON() ([]byte, error) {
+	type alias TvBoxLiveChannel
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxParse) UnmarshalJSON(data []byte) error {
+	type alias TvBoxParse
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxParse) MarshalJSON() ([]byte, error) {
+	type alias TvBoxParse
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxRule) UnmarshalJSON(data []byte) error {
+	type alias TvBoxRule
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxRule) MarshalJSON() ([]byte, error) {
+	type alias TvBoxRule
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxHeader) UnmarshalJSON(data []byte) error {
+	type alias TvBoxHeader
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxHeader) MarshalJSON() ([]byte, error) {
+	type alias TvBoxHeader
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxProxy) UnmarshalJSON(data []byte) error {
+	type alias TvBoxProxy
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxProxy) MarshalJSON() ([]byte, error) {
+	type alias TvBoxProxy
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxIJK) UnmarshalJSON(data []byte) error {
+	type alias TvBoxIJK
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxIJK) MarshalJSON() ([]byte, error) {
+	type alias TvBoxIJK
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxIJKOption) UnmarshalJSON(data []byte) error {
+	type alias TvBoxIJKOption
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxIJKOption) MarshalJSON() ([]byte, error) {
+	type alias TvBoxIJKOption
+	return marshalWithExtra(alias(v), v.Extra)
+}
+
+// UnmarshalJSON 实现了 json.Unmarshaler 接口
+func (v *TvBoxDrive) UnmarshalJSON(data []byte) error {
+	type alias TvBoxDrive
+	return unmarshalWithExtra(data, (*alias)(v), &v.Extra)
+}
+
+// MarshalJSON 实现了 json.Marshaler 接口
+func (v TvBoxDrive) MarshalJSON() ([]byte, error) {
+	type alias TvBoxDrive
+	return marshalWithExtra(alias(v), v.Extra)
+}
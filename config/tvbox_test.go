@@ -1,11 +1,13 @@
 package config
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -169,6 +171,7 @@ func TestLoadTvBoxConfig(t *testing.T) {
 		assert.Equal(t, "Google", config.DOH[0].Name)
 		assert.Len(t, config.Lives, 1)
 		assert.Equal(t, "Live Channel", config.Lives[0].Name)
+		assert.Equal(t, FlexInt(1), *config.Lives[0].PlayerType)
 		assert.Equal(t, "https://example.com/epg", config.Lives[0].EPG)
 		assert.Equal(t, "https://example.com/logo.png", config.Lives[0].Logo)
 		assert.Len(t, config.Parses, 1)
@@ -220,3 +223,106 @@ func TestLoadTvBoxConfig(t *testing.T) {
 		assert.Contains(t, err.Error(), "failed to parse JSON")
 	})
 }
+
+func TestTvBoxConfigSchema(t *testing.T) {
+	data := `{
+		"spider": "https://example.com/spider.jar",
+		"warningText": "仅供测试",
+		"hosts": ["cache.ott.*.itv.cmvideo.cn=base-v4-free-mghy.e.cdn.chinamobile.com"],
+		"headers": [{"host": "example.com", "header": {"User-Agent": "okhttp/3.12"}}],
+		"proxy": [{"name": "all", "hosts": ["raw.githubusercontent.com"], "urls": ["http://127.0.0.1:7890"]}],
+		"ijk": [{"group": "软解码", "options": [{"category": 4, "name": "opensles", "value": "0"}]}],
+		"drives": [{"name": "Alist", "type": "alist", "server": "https://alist.example.com"}],
+		"sites": [{
+			"key": "site1", "name": "Site 1", "type": 3, "api": "csp_Site1",
+			"style": {"type": "rect", "ratio": 1.5},
+			"categories": ["电影", "电视剧"],
+			"header": {"Referer": "https://example.com"},
+			"click": "document.querySelector('.play').click()",
+			"hide": 1,
+			"custom": {"nested": [1, 2, 3]}
+		}],
+		"lives": [{
+			"group": "央视",
+			"channels": [{"name": "CCTV-1", "urls": ["http://example.com/cctv1.m3u8"], "number": 1}]
+		}],
+		"unknownTop": "keep me"
+	}`
+
+	cfg, err := ParseTvBoxConfig(strings.NewReader(data))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "仅供测试", cfg.WarningText)
+	assert.Len(t, cfg.Hosts, 1)
+	assert.Equal(t, "okhttp/3.12", cfg.Headers[0].Header["User-Agent"])
+	assert.Equal(t, []string{"http://127.0.0.1:7890"}, cfg.Proxy[0].URLs)
+	assert.Equal(t, FlexInt(4), cfg.IJK[0].Options[0].Category)
+	assert.Equal(t, "https://alist.example.com", cfg.Drives[0].Server)
+
+	site := cfg.Sites[0]
+	assert.Equal(t, &TvBoxStyle{Type: "rect", Ratio: 1.5}, site.Style)
+	assert.Equal(t, []string{"电影", "电视剧"}, site.Categories)
+	assert.Equal(t, "https://example.com", site.Header["Referer"])
+	assert.NotEmpty(t, site.Click)
+	assert.JSONEq(t, `1`, string(site.Extra["hide"]))
+	assert.JSONEq(t, `{"nested": [1, 2, 3]}`, string(site.Extra["custom"]))
+
+	assert.Equal(t, "央视", cfg.Lives[0].Group)
+	assert.Nil(t, cfg.Lives[0].Type)
+	assert.Equal(t, "CCTV-1", cfg.Lives[0].Channels[0].Name)
+	assert.JSONEq(t, `1`, string(cfg.Lives[0].Channels[0].Extra["number"]))
+	assert.JSONEq(t, `"keep me"`, string(cfg.Extra["unknownTop"]))
+
+	t.Run("Round trip", func(t *testing.T) {
+		out, err := json.Marshal(cfg)
+		assert.NoError(t, err)
+
+		var expected, actual map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(data), &expected))
+		assert.NoError(t, json.Unmarshal(out, &actual))
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("Mismatched field types", func(t *testing.T) {
+		// 单个站点的字段类型与建模不一致时不影响整个仓库, 该字段原样保留
+		data := `{
+			"sites": [
+				{"key": "site1", "name": "Site 1", "type": 3, "categories": {"1": "电影"}, "header": {"X-Count": 1}},
+				{"key": "site2", "name": "Site 2", "type": 1, "categories": ["电影"]}
+			],
+			"headers": [{"host": "example.com", "header": ["User-Agent: okhttp"]}],
+			"ijk": [{"group": "软解码", "options": [{"category": 4, "name": "opensles", "value": 0}]}]
+		}`
+
+		cfg, err := ParseTvBoxConfig(strings.NewReader(data))
+		assert.NoError(t, err)
+		assert.Len(t, cfg.Sites, 2)
+
+		site := cfg.Sites[0]
+		assert.Equal(t, "site1", site.Key)
+		assert.Equal(t, FlexInt(3), site.Type)
+		assert.Nil(t, site.Categories)
+		assert.JSONEq(t, `{"1": "电影"}`, string(site.Extra["categories"]))
+		assert.JSONEq(t, `{"X-Count": 1}`, string(site.Extra["header"]))
+		assert.Equal(t, []string{"电影"}, cfg.Sites[1].Categories)
+		assert.Equal(t, "example.com", cfg.Headers[0].Host)
+		assert.Equal(t, "opensles", cfg.IJK[0].Options[0].Name)
+
+		out, err := json.Marshal(cfg)
+		assert.NoError(t, err)
+		var expected, actual map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(data), &expected))
+		assert.NoError(t, json.Unmarshal(out, &actual))
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("Extra overrides modelled field", func(t *testing.T) {
+		site := TvBoxSite{Key: "site1", Name: "Site 1", Extra: map[string]json.RawMessage{
+			"name": json.RawMessage(`"Override"`),
+			"zzz":  json.RawMessage(`true`),
+		}}
+		out, err := json.Marshal(site)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"key":"site1","name":"Override","type":0,"zzz":true}`, string(out))
+	})
+}
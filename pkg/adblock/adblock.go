@@ -0,0 +1,172 @@
+package adblock
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+)
+
+// ignoredHosts 为 hosts 文件中常见的本地条目，不属于广告域名
+var ignoredHosts = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// sinkAddresses 为 hosts 与 dnsmasq address 规则中表示屏蔽的地址, dnsmasq 规则的空地址同样表示屏蔽
+var sinkAddresses = map[string]bool{
+	"":          true,
+	"0.0.0.0":   true,
+	"::":        true,
+	"127.0.0.1": true,
+	"::1":       true,
+}
+
+// allowedOptions 为不限制生效范围的 Adblock 规则选项，带有其他选项的规则无法转换为域名拦截
+var allowedOptions = map[string]bool{
+	"third-party": true,
+	"3p":          true,
+	"important":   true,
+	"all":         true,
+	"document":    true,
+	"doc":         true,
+	"popup":       true,
+}
+
+// Parse 从 hosts 文件、Adblock 风格规则列表、dnsmasq 配置或纯域名列表中提取需要拦截的域名
+// 返回的域名为小写并去重，保持首次出现的顺序
+// 例外规则 (@@)、元素隐藏规则 (##) 以及带路径或通配符的规则会被忽略
+func Parse(data []byte) []string {
+	var domains []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		for _, domain := range parseLine(scanner.Text()) {
+			if !seen[domain] {
+				seen[domain] = true
+				domains = append(domains, domain)
+			}
+		}
+	}
+
+	return domains
+}
+
+func parseLine(line string) []string {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '!' || line[0] == '[' || strings.HasPrefix(line, "@@") {
+		return nil
+	}
+
+	// hosts 与纯域名列表使用 # 作为注释, # 前没有空白时为元素隐藏规则, 例如 example.com##.ad
+	if idx := strings.Index(line, "#"); idx > 0 && !strings.ContainsAny(line[:idx], " \t") {
+		return nil
+	} else if idx >= 0 {
+		line = strings.TrimSpace(line[:idx])
+	}
+
+	switch {
+	case line == "":
+		return nil
+	case strings.HasPrefix(line, "||"):
+		return single(parseAdblockRule(line[2:]))
+	case strings.HasPrefix(line, "address=/"):
+		return parseDnsmasq(line)
+	case strings.HasPrefix(line, "server="):
+		// server=/example.com/1.2.3.4 为 DNS 转发规则, 例如 dnsmasq-china-list, 并非屏蔽规则
+		return nil
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) > 1 {
+		// 指向其他地址的 hosts 条目用于加速或重定向, 例如 GitHub520, 并非屏蔽规则
+		if !sinkAddresses[fields[0]] {
+			return nil
+		}
+		var domains []string
+		for _, field := range fields[1:] {
+			if domain, ok := normalizeDomain(field); ok {
+				domains = append(domains, domain)
+			}
+		}
+		return domains
+	}
+
+	return single(normalizeDomain(strings.TrimSuffix(fields[0], "^")))
+}
+
+// parseAdblockRule 解析 ||example.com^ 形式的规则
+func parseAdblockRule(rule string) (string, bool) {
+	rule, options, hasOptions := strings.Cut(rule, "$")
+	if hasOptions {
+		for _, option := range strings.Split(options, ",") {
+			if !allowedOptions[strings.ToLower(strings.TrimSpace(option))] {
+				return "", false
+			}
+		}
+	}
+
+	// 仅接受以分隔符结尾的整域名规则
+	rule = strings.TrimSuffix(rule, "|")
+	if strings.HasSuffix(rule, "^") {
+		rule = strings.TrimSuffix(rule, "^")
+	} else if strings.ContainsAny(rule, "/^") {
+		return "", false
+	}
+	return normalizeDomain(rule)
+}
+
+// parseDnsmasq 解析 address=/example.com/0.0.0.0 形式的规则, 一条规则可包含多个域名
+// 仅接受解析到空地址或黑洞地址的规则, 指向其他地址的为 DNS 劫持或重定向, 并非屏蔽规则
+func parseDnsmasq(line string) []string {
+	parts := strings.Split(line, "/")
+	if len(parts) < 3 || !sinkAddresses[strings.TrimSpace(parts[len(parts)-1])] {
+		return nil
+	}
+
+	var domains []string
+	for _, part := range parts[1 : len(parts)-1] {
+		if domain, ok := normalizeDomain(part); ok {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+func normalizeDomain(domain string) (string, bool) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" || ignoredHosts[domain] || !strings.Contains(domain, ".") || net.ParseIP(domain) != nil {
+		return "", false
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return "", false
+			}
+		}
+	}
+	return domain, true
+}
+
+func single(domain string, ok bool) []string {
+	if !ok {
+		return nil
+	}
+	return []string{domain}
+}
@@ -0,0 +1,80 @@
+package adblock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`[Adblock Plus 2.0]
+! Title: Example list
+# hosts comment
+127.0.0.1 localhost
+::1 ip6-localhost ip6-loopback
+0.0.0.0 0.0.0.0
+0.0.0.0 ads.example.com tracker.example.com # inline comment
+127.0.0.1	Ads.Example.com
+||doubleclick.net^
+||third.example.org^$third-party
+||scoped.example.org^$domain=foo.com
+||path.example.org/banner
+@@||allowed.example.com^
+example.com##.ad-banner
+address=/dnsmasq.example.net/0.0.0.0
+plain.example.io
+plain.example.io.
+*.wildcard.example.com
+not_a_domain
+1.2.3.4
+`)
+
+	assert.Equal(t, []string{
+		"ads.example.com",
+		"tracker.example.com",
+		"doubleclick.net",
+		"third.example.org",
+		"dnsmasq.example.net",
+		"plain.example.io",
+	}, Parse(data))
+}
+
+func TestParseEmpty(t *testing.T) {
+	assert.Empty(t, Parse(nil))
+	assert.Empty(t, Parse([]byte("! only comments\n# here\n")))
+}
+
+func TestParseDnsmasq(t *testing.T) {
+	data := []byte(`address=/empty.example.net/
+address=/v6.example.net/a.example.net/::
+address=/loopback.example.net/127.0.0.1
+address=/hijack.example.net/1.2.3.4
+server=/forward.example.cn/114.114.114.114
+server=/forward.example.cn/
+`)
+
+	// 仅指向黑洞地址的 address 规则为屏蔽规则, server 为 DNS 转发规则
+	assert.Equal(t, []string{
+		"empty.example.net",
+		"v6.example.net",
+		"a.example.net",
+		"loopback.example.net",
+	}, Parse(data))
+}
+
+func TestParseHosts(t *testing.T) {
+	data := []byte(`# GitHub520
+140.82.112.3 github.com
+185.199.108.133 raw.githubusercontent.com
+0.0.0.0 ads.example.com
+:: v6.example.com
+::1 loopback.example.com
+`)
+
+	// 仅指向黑洞地址的 hosts 条目为屏蔽规则, 指向真实地址的为加速或重定向
+	assert.Equal(t, []string{
+		"ads.example.com",
+		"v6.example.com",
+		"loopback.example.com",
+	}, Parse(data))
+}
@@ -0,0 +1,220 @@
+// Package chname 规范化频道名称，使不同来源中同一频道的不同写法得到相同的标识
+//
+// 例如 "CCTV1"、"CCTV-1 综合"、"CCTV1高清" 与 "cctv-1 HD" 均规范化为 ID "cctv1"、名称 "CCTV1"
+package chname
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Channel 为规范化后的频道标识
+type Channel struct {
+	ID   string // 用于匹配的标识, 小写且不含空白与标点
+	Name string // 用于展示的规范名称
+}
+
+// qualitySuffixes 为画质等后缀，规范化时从名称末尾移除, 较长的在前
+var qualitySuffixes = []string{
+	"超高清", "高清", "超清", "标清", "蓝光",
+	"1080p", "2160p", "720p", "hevc", "h265", "h264", "uhd", "fhd", "hd", "sd", "4k", "8k",
+}
+
+// cctvDescriptors 为央视频道号之后常见的频道描述, 规范化时移除, 较长的在前
+// 其余后缀 (如 欧洲、美洲) 区分不同的频道, 予以保留
+var cctvDescriptors = []string{
+	"中文国际", "体育赛事", "国防军事", "社会与法", "农业农村", "奥林匹克",
+	"综合", "财经", "综艺", "体育", "电影", "电视剧", "纪录", "科教", "戏曲", "新闻", "少儿", "音乐", "频道",
+	"超高清", "高清", "超清", "标清", "蓝光",
+}
+
+var (
+	// cctvPattern 匹配央视频道的频道号以及 + 或 K 后缀
+	cctvPattern = regexp.MustCompile(`^cctv[\s\-_]*(\d+)(\s*\+|k\b)?`)
+	// resolution 匹配名称中的分辨率, 例如 1920x1080
+	resolution = regexp.MustCompile(`\d+\s*[x*×]\s*\d+`)
+	// punctuation 为规范化时移除的空白与标点
+	punctuation = regexp.MustCompile(`[\s\-_.·•|/\\()\[\]{}【】「」:,'"]+`)
+)
+
+// foldWidth 将全角 ASCII 字符与全角空格转换为半角
+func foldWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '　':
+			return ' '
+		case r >= '！' && r <= '～':
+			return r - 0xfee0
+		}
+		return r
+	}, s)
+}
+
+// trimQuality 移除名称末尾的画质后缀，可重复移除，例如 "湖南卫视 4K 高清"
+func trimQuality(s string) string {
+	for {
+		lower := strings.ToLower(s)
+		trimmed := false
+		for _, suffix := range qualitySuffixes {
+			if !strings.HasSuffix(lower, suffix) || len(lower) == len(suffix) {
+				continue
+			}
+			rest := s[:len(s)-len(suffix)]
+			// 拉丁字母后缀需要与前面的单词分隔，避免误删 "TVBSD" 之类名称的一部分
+			if isASCII(suffix) && !endsWithBoundary(rest) {
+				continue
+			}
+			s = strings.TrimRightFunc(rest, isSeparator)
+			trimmed = true
+			break
+		}
+		if !trimmed {
+			return s
+		}
+	}
+}
+
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+// endsWithBoundary 判断名称是否以分隔符、数字或非拉丁字符结尾
+func endsWithBoundary(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r != utf8.RuneError && (isSeparator(r) || unicode.IsDigit(r) || r > unicode.MaxASCII)
+}
+
+func isSeparator(r rune) bool {
+	return unicode.IsSpace(r) || r == '-' || r == '_' || r == '.' || r == '·' || r == '|'
+}
+
+// Normalize 使用内置规则规范化频道名称
+// 规则依次为: 全角转半角、央视频道移除频道描述仅保留频道号与区域等后缀、移除画质后缀、转小写并移除空白与标点
+func Normalize(name string) Channel {
+	name = strings.TrimSpace(foldWidth(name))
+	lower := strings.ToLower(name)
+
+	if m := cctvPattern.FindStringSubmatch(lower); m != nil {
+		id := "cctv" + m[1] + strings.TrimSpace(m[2])
+		rest := lower[len(m[0]):]
+		if len(lower) == len(name) {
+			rest = name[len(m[0]):] // 保留后缀原有的大小写
+		}
+		channel := Channel{ID: id, Name: strings.ToUpper(id)}
+		if suffix := cctvSuffix(rest); suffix != "" {
+			channel.ID += punctuation.ReplaceAllString(strings.ToLower(suffix), "")
+			channel.Name += " " + suffix
+		}
+		return channel
+	}
+
+	display := strings.Join(strings.Fields(trimQuality(name)), " ")
+	id := punctuation.ReplaceAllString(strings.ToLower(display), "")
+	if id == "" {
+		return Channel{ID: lower, Name: name}
+	}
+	return Channel{ID: id, Name: display}
+}
+
+// cctvSuffix 返回央视频道号之后区分频道的后缀, 移除频道描述、画质与分辨率
+// 例如 "中文国际 欧洲 高清" 返回 "欧洲", "综合" 返回空字符串
+func cctvSuffix(rest string) string {
+	rest = resolution.ReplaceAllString(rest, " ")
+	for _, descriptor := range cctvDescriptors {
+		rest = strings.ReplaceAll(rest, descriptor, " ")
+	}
+	rest = punctuation.ReplaceAllString(rest, " ")
+
+	var fields []string
+	for _, field := range strings.Fields(rest) {
+		if !isQualitySuffix(field) {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func isQualitySuffix(s string) bool {
+	for _, suffix := range qualitySuffixes {
+		if strings.EqualFold(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalizer 在内置规则的基础上支持别名字典
+type Normalizer struct {
+	aliases map[string]Channel // 别名的匹配键 -> 规范频道
+}
+
+// aliasKey 返回别名的匹配键, 仅转换大小写、全角并移除标点, 不应用央视频道与画质后缀规则
+func aliasKey(name string) string {
+	return punctuation.ReplaceAllString(strings.ToLower(foldWidth(name)), "")
+}
+
+// NewNormalizer 创建使用别名字典的规范化器
+// 字典中每行为 "规范名称,别名1,别名2,..."，以 # 开头的行为注释
+func NewNormalizer(aliases []byte) *Normalizer {
+	n := &Normalizer{aliases: make(map[string]Channel)}
+
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(aliases, []byte("\xef\xbb\xbf"))))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		names := strings.Split(line, ",")
+		canonical := strings.TrimSpace(names[0])
+		if canonical == "" {
+			continue
+		}
+		channel := Channel{ID: Normalize(canonical).ID, Name: canonical}
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				n.aliases[aliasKey(name)] = channel
+			}
+		}
+	}
+
+	return n
+}
+
+// LoadNormalizer 从文件加载别名字典，path 为空时仅使用内置规则
+func LoadNormalizer(path string) (*Normalizer, error) {
+	if path == "" {
+		return NewNormalizer(nil), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewNormalizer(data), nil
+}
+
+// Normalize 返回频道名称的规范标识，别名字典优先于内置规则
+// 名称本身或移除画质后缀后的名称与别名一致时使用别名对应的规范频道
+func (n *Normalizer) Normalize(name string) Channel {
+	channel := Normalize(name)
+	if n == nil {
+		return channel
+	}
+	if alias, ok := n.aliases[aliasKey(name)]; ok {
+		return alias
+	}
+	if alias, ok := n.aliases[aliasKey(trimQuality(strings.TrimSpace(foldWidth(name))))]; ok {
+		return alias
+	}
+	return channel
+}
@@ -0,0 +1,77 @@
+package chname
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		want Channel
+	}{
+		{"CCTV1", Channel{ID: "cctv1", Name: "CCTV1"}},
+		{"CCTV-1 综合", Channel{ID: "cctv1", Name: "CCTV1"}},
+		{"CCTV1高清", Channel{ID: "cctv1", Name: "CCTV1"}},
+		{"cctv-1 HD", Channel{ID: "cctv1", Name: "CCTV1"}},
+		{"ＣＣＴＶ－１", Channel{ID: "cctv1", Name: "CCTV1"}},
+		{"CCTV10 科教", Channel{ID: "cctv10", Name: "CCTV10"}},
+		{"CCTV5+ 体育赛事", Channel{ID: "cctv5+", Name: "CCTV5+"}},
+		{"CCTV-5 +", Channel{ID: "cctv5+", Name: "CCTV5+"}},
+		{"CCTV4K", Channel{ID: "cctv4k", Name: "CCTV4K"}},
+		{"CCTV-4K 超高清", Channel{ID: "cctv4k", Name: "CCTV4K"}},
+		{"CCTV4 中文国际", Channel{ID: "cctv4", Name: "CCTV4"}},
+		{"CCTV4 欧洲", Channel{ID: "cctv4欧洲", Name: "CCTV4 欧洲"}},
+		{"CCTV-4 中文国际 美洲 HD", Channel{ID: "cctv4美洲", Name: "CCTV4 美洲"}},
+		{"CCTV4(亚洲)高清", Channel{ID: "cctv4亚洲", Name: "CCTV4 亚洲"}},
+		{"CCTV-13 新闻频道 1920x1080", Channel{ID: "cctv13", Name: "CCTV13"}},
+		{"CCTV1综合", Channel{ID: "cctv1", Name: "CCTV1"}},
+		{"湖南卫视", Channel{ID: "湖南卫视", Name: "湖南卫视"}},
+		{"湖南卫视 4K 高清", Channel{ID: "湖南卫视", Name: "湖南卫视"}},
+		{"湖南卫视HD", Channel{ID: "湖南卫视", Name: "湖南卫视"}},
+		{" 湖南　卫视-1080P ", Channel{ID: "湖南卫视", Name: "湖南 卫视"}},
+		{"TVBSD", Channel{ID: "tvbsd", Name: "TVBSD"}},
+		{"HD", Channel{ID: "hd", Name: "HD"}},
+		{"---", Channel{ID: "---", Name: "---"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Normalize(tt.name), tt.name)
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	n := NewNormalizer([]byte("\xef\xbb\xbf# 频道别名\n" +
+		"\n" +
+		"凤凰中文,凤凰卫视中文台, 凤凰卫视 \n" +
+		"CCTV5+,CCTV5plus\n" +
+		",无效\n"))
+
+	assert.Equal(t, Channel{ID: "凤凰中文", Name: "凤凰中文"}, n.Normalize("凤凰卫视中文台 HD"))
+	assert.Equal(t, Channel{ID: "凤凰中文", Name: "凤凰中文"}, n.Normalize("凤凰卫视"))
+	assert.Equal(t, Channel{ID: "凤凰中文", Name: "凤凰中文"}, n.Normalize("凤凰中文"))
+	assert.Equal(t, Channel{ID: "cctv5+", Name: "CCTV5+"}, n.Normalize("cctv5plus"))
+	assert.Equal(t, Channel{ID: "cctv5", Name: "CCTV5"}, n.Normalize("CCTV5 体育"))
+	assert.Equal(t, Channel{ID: "湖南卫视", Name: "湖南卫视"}, n.Normalize("湖南卫视高清"))
+	assert.Equal(t, Channel{ID: "无效", Name: "无效"}, n.Normalize("无效"))
+
+	var nilNormalizer *Normalizer
+	assert.Equal(t, Channel{ID: "cctv1", Name: "CCTV1"}, nilNormalizer.Normalize("CCTV-1"))
+}
+
+func TestLoadNormalizer(t *testing.T) {
+	n, err := LoadNormalizer("")
+	assert.NoError(t, err)
+	assert.Equal(t, "CCTV1", n.Normalize("CCTV-1").Name)
+
+	path := filepath.Join(t.TempDir(), "alias.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("东方卫视,上海东方卫视\n"), 0o644))
+	n, err = LoadNormalizer(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "东方卫视", n.Normalize("上海东方卫视").Name)
+
+	_, err = LoadNormalizer(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
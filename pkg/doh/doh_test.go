@@ -0,0 +1,200 @@
+package doh
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newQuery(id uint16, name string) []byte {
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	msg[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	for _, label := range bytes.Split([]byte(name), []byte(".")) {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, 1, 0, 1) // A, IN
+	return msg
+}
+
+// newResponse 生成带有一条 A 记录的响应, 记录名称使用压缩指针指向问题
+func newResponse(query []byte, ttl uint32) []byte {
+	msg := bytes.Clone(query)
+	msg[2] |= 0x80 // QR
+	binary.BigEndian.PutUint16(msg[6:8], 1)
+	msg = append(msg, 0xc0, headerLen, 0, 1, 0, 1)
+	msg = binary.BigEndian.AppendUint32(msg, ttl)
+	msg = append(msg, 0, 4, 1, 2, 3, 4)
+	return msg
+}
+
+func answerTTL(msg []byte) uint32 {
+	ttl, _ := minTTL(msg)
+	return ttl
+}
+
+func newDOHUpstream(t *testing.T, hits *atomic.Int32, ttl uint32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		assert.Equal(t, ContentType, r.Header.Get("Content-Type"))
+		query, _ := io.ReadAll(r.Body)
+		assert.Equal(t, uint16(0), messageID(query))
+		w.Header().Set("Content-Type", ContentType)
+		w.Write(newResponse(query, ttl))
+	}))
+}
+
+func TestMessage(t *testing.T) {
+	query := newQuery(1, "Example.COM")
+	key, err := questionKey(query)
+	assert.NoError(t, err)
+	key2, _ := questionKey(newQuery(2, "example.com"))
+	assert.Equal(t, key, key2)
+
+	_, err = questionKey(query[:5])
+	assert.Error(t, err)
+
+	resp := newResponse(query, 300)
+	assert.Equal(t, uint32(300), answerTTL(resp))
+	decreaseTTLs(resp, 100)
+	assert.Equal(t, uint32(200), answerTTL(resp))
+	decreaseTTLs(resp, 1000)
+	assert.Equal(t, uint32(0), answerTTL(resp))
+
+	_, ok := minTTL(query)
+	assert.False(t, ok)
+}
+
+func TestResolver(t *testing.T) {
+	var hits atomic.Int32
+	upstream := newDOHUpstream(t, &hits, 300)
+	defer upstream.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	resolver, err := NewResolver([]string{failing.URL, upstream.URL}, time.Second, 16, nil)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(resolver)
+	defer server.Close()
+
+	t.Run("POST", func(t *testing.T) {
+		resp, err := http.Post(server.URL, ContentType, bytes.NewReader(newQuery(0x1234, "example.com")))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+		assert.Equal(t, "max-age=300", resp.Header.Get("Cache-Control"))
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, uint16(0x1234), messageID(body))
+		assert.Equal(t, int32(1), hits.Load())
+	})
+
+	t.Run("GETCached", func(t *testing.T) {
+		param := base64.RawURLEncoding.EncodeToString(newQuery(0x4321, "EXAMPLE.com"))
+		resp, err := http.Get(server.URL + "?dns=" + param)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, uint16(0x4321), messageID(body))
+		assert.Equal(t, int32(1), hits.Load())
+	})
+
+	t.Run("BadRequest", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "?dns=AAAA")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, err = http.Post(server.URL, "text/plain", bytes.NewReader(newQuery(1, "example.com")))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	})
+}
+
+func TestResolverStale(t *testing.T) {
+	var hits atomic.Int32
+	upstream := newDOHUpstream(t, &hits, 1)
+
+	resolver, err := NewResolver([]string{upstream.URL}, time.Second, 16, nil)
+	assert.NoError(t, err)
+
+	query := newQuery(1, "example.com")
+	_, err = resolver.Resolve(context.Background(), query)
+	assert.NoError(t, err)
+
+	// 缓存过期且上游不可用时使用过期缓存
+	upstream.Close()
+	resolver.mu.Lock()
+	for _, entry := range resolver.cache {
+		entry.expiresAt = time.Now().Add(-time.Second)
+	}
+	resolver.mu.Unlock()
+
+	resp, err := resolver.Resolve(context.Background(), newQuery(2, "example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(2), messageID(resp))
+
+	_, err = resolver.Resolve(context.Background(), newQuery(3, "other.com"))
+	assert.Error(t, err)
+}
+
+func TestResolverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(newResponse(buf[:n], 60), addr)
+		}
+	}()
+
+	resolver, err := NewResolver([]string{"udp://" + conn.LocalAddr().String()}, time.Second, 0, nil)
+	assert.NoError(t, err)
+
+	resp, err := resolver.Resolve(context.Background(), newQuery(7, "example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(7), messageID(resp))
+	assert.Equal(t, uint32(60), answerTTL(resp))
+}
+
+func TestParseUpstream(t *testing.T) {
+	u, err := parseUpstream("1.1.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "udp", u.network)
+	assert.Equal(t, "1.1.1.1:53", u.addr)
+
+	u, err = parseUpstream("2001:db8::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:53", u.addr)
+
+	u, err = parseUpstream("https://dns.example/dns-query")
+	assert.NoError(t, err)
+	assert.Equal(t, "https", u.network)
+
+	_, err = parseUpstream("tls://1.1.1.1")
+	assert.Error(t, err)
+}
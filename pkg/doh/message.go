@@ -0,0 +1,139 @@
+package doh
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	headerLen = 12
+
+	rcodeServFail = 2
+	typeOPT       = 41
+)
+
+var errMalformed = errors.New("malformed dns message")
+
+// questionKey 返回问题部分的缓存 key, 域名不区分大小写
+func questionKey(msg []byte) (string, error) {
+	if len(msg) < headerLen {
+		return "", errMalformed
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return "", errors.New("dns message must contain exactly one question")
+	}
+
+	end, err := skipName(msg, headerLen)
+	if err != nil {
+		return "", err
+	}
+	if end+4 > len(msg) {
+		return "", errMalformed
+	}
+	return strings.ToLower(string(msg[headerLen:end])) + string(msg[end:end+4]), nil
+}
+
+func messageID(msg []byte) uint16 {
+	return binary.BigEndian.Uint16(msg[0:2])
+}
+
+func setMessageID(msg []byte, id uint16) {
+	binary.BigEndian.PutUint16(msg[0:2], id)
+}
+
+func rcode(msg []byte) int {
+	return int(msg[3] & 0x0f)
+}
+
+// skipName 跳过从 offset 开始的域名, 返回域名之后的偏移, 支持压缩指针
+func skipName(msg []byte, offset int) (int, error) {
+	for {
+		if offset >= len(msg) {
+			return 0, errMalformed
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			if offset+2 > len(msg) {
+				return 0, errMalformed
+			}
+			return offset + 2, nil
+		case length&0xc0 != 0:
+			return 0, errMalformed
+		}
+		offset += length + 1
+	}
+}
+
+// walkTTLs 遍历应答、授权及附加部分的资源记录, 对每条记录 TTL 所在的偏移调用 fn
+// OPT 伪记录的 TTL 字段含义不同，会被跳过
+func walkTTLs(msg []byte, fn func(offset int)) error {
+	if len(msg) < headerLen {
+		return errMalformed
+	}
+
+	offset := headerLen
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:6])); i++ {
+		end, err := skipName(msg, offset)
+		if err != nil {
+			return err
+		}
+		offset = end + 4
+	}
+
+	records := int(binary.BigEndian.Uint16(msg[6:8])) +
+		int(binary.BigEndian.Uint16(msg[8:10])) +
+		int(binary.BigEndian.Uint16(msg[10:12]))
+	for i := 0; i < records; i++ {
+		end, err := skipName(msg, offset)
+		if err != nil {
+			return err
+		}
+		if end+10 > len(msg) {
+			return errMalformed
+		}
+		rrType := binary.BigEndian.Uint16(msg[end : end+2])
+		rdLength := int(binary.BigEndian.Uint16(msg[end+8 : end+10]))
+		if rrType != typeOPT {
+			fn(end + 4)
+		}
+		offset = end + 10 + rdLength
+		if offset > len(msg) {
+			return errMalformed
+		}
+	}
+	return nil
+}
+
+// minTTL 返回响应中所有资源记录的最小 TTL, 没有记录时返回 false
+func minTTL(msg []byte) (uint32, bool) {
+	var ttl uint32
+	found := false
+	err := walkTTLs(msg, func(offset int) {
+		value := binary.BigEndian.Uint32(msg[offset : offset+4])
+		if !found || value < ttl {
+			ttl = value
+		}
+		found = true
+	})
+	if err != nil {
+		return 0, false
+	}
+	return ttl, found
+}
+
+// decreaseTTLs 将响应中所有资源记录的 TTL 减去 elapsed 秒
+func decreaseTTLs(msg []byte, elapsed uint32) {
+	_ = walkTTLs(msg, func(offset int) {
+		value := binary.BigEndian.Uint32(msg[offset : offset+4])
+		if value > elapsed {
+			value -= elapsed
+		} else {
+			value = 0
+		}
+		binary.BigEndian.PutUint32(msg[offset:offset+4], value)
+	})
+}
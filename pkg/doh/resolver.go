@@ -0,0 +1,324 @@
+package doh
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ContentType = "application/dns-message"
+
+	maxMessageSize = 65535
+	// negativeTTL 为没有资源记录的响应 (如 NXDOMAIN) 的缓存时间，单位为秒
+	negativeTTL = 60
+	// staleTTL 为过期缓存的保留时间，所有上游均不可用时使用过期缓存应答
+	staleTTL = 24 * time.Hour
+)
+
+var errBadRequest = errors.New("bad dns request")
+
+type upstream struct {
+	network string // https (DoH, 包括 http://) 或 udp
+	addr    string
+}
+
+type cacheEntry struct {
+	msg       []byte
+	storedAt  time.Time
+	expiresAt time.Time
+}
+
+// Resolver 将 DNS 查询依次转发到上游解析器，并按响应的 TTL 缓存结果
+// 上游支持 DoH (https:// 或 http://) 与普通 UDP DNS (udp://host:port 或 host[:port])
+type Resolver struct {
+	upstreams []upstream
+	client    *http.Client
+	timeout   time.Duration
+	cacheSize int
+	mu        sync.Mutex
+	cache     map[string]*cacheEntry
+	logger    *slog.Logger
+}
+
+func NewResolver(upstreams []string, timeout time.Duration, cacheSize int, logger *slog.Logger) (*Resolver, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("no upstream resolver configured")
+	}
+
+	r := &Resolver{
+		client:    &http.Client{},
+		timeout:   timeout,
+		cacheSize: cacheSize,
+		cache:     make(map[string]*cacheEntry),
+	}
+	for _, s := range upstreams {
+		u, err := parseUpstream(s)
+		if err != nil {
+			return nil, err
+		}
+		r.upstreams = append(r.upstreams, u)
+	}
+
+	if logger != nil {
+		r.logger = logger.With("manager", "doh")
+	}
+
+	return r, nil
+}
+
+func parseUpstream(s string) (upstream, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "https://"), strings.HasPrefix(s, "http://"):
+		return upstream{network: "https", addr: s}, nil
+	case strings.HasPrefix(s, "udp://"):
+		s = strings.TrimPrefix(s, "udp://")
+	case strings.Contains(s, "://"):
+		return upstream{}, fmt.Errorf("unsupported upstream resolver: %s", s)
+	}
+
+	if s == "" {
+		return upstream{}, errors.New("empty upstream resolver")
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		s = net.JoinHostPort(strings.Trim(s, "[]"), "53")
+	}
+	return upstream{network: "udp", addr: s}, nil
+}
+
+func (r *Resolver) log(format string, args ...any) {
+	if r.logger != nil {
+		r.logger.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+// Resolve 解析 DNS 查询报文并返回响应报文
+// 缓存未命中时依次尝试各个上游，全部失败时使用过期缓存
+func (r *Resolver) Resolve(ctx context.Context, query []byte) ([]byte, error) {
+	key, err := questionKey(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	id := messageID(query)
+
+	cached, fresh := r.lookup(key)
+	if fresh {
+		setMessageID(cached, id)
+		return cached, nil
+	}
+
+	var lastErr error
+	for _, u := range r.upstreams {
+		resp, err := r.exchange(ctx, u, query)
+		if err == nil && rcode(resp) == rcodeServFail {
+			err = errors.New("upstream returned SERVFAIL")
+		}
+		if err != nil {
+			r.log("query %s: %v", u.addr, err)
+			lastErr = err
+			continue
+		}
+
+		r.store(key, resp)
+		setMessageID(resp, id)
+		return resp, nil
+	}
+
+	if cached != nil {
+		setMessageID(cached, id)
+		return cached, nil
+	}
+	return nil, fmt.Errorf("all upstream resolvers failed: %w", lastErr)
+}
+
+func (r *Resolver) exchange(ctx context.Context, u upstream, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var resp []byte
+	var err error
+	if u.network == "https" {
+		resp, err = r.exchangeHTTPS(ctx, u.addr, query)
+	} else {
+		resp, err = exchangeUDP(ctx, u.addr, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < headerLen {
+		return nil, errMalformed
+	}
+	return resp, nil
+}
+
+func (r *Resolver) exchangeHTTPS(ctx context.Context, addr string, query []byte) ([]byte, error) {
+	// RFC 8484 建议使用 ID 0 以便 HTTP 缓存
+	msg := bytes.Clone(query)
+	setMessageID(msg, 0)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, addr, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Accept", ContentType)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+}
+
+func exchangeUDP(ctx context.Context, addr string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// 忽略 ID 不匹配的响应
+		if n >= headerLen && messageID(buf) == messageID(query) {
+			return bytes.Clone(buf[:n]), nil
+		}
+	}
+}
+
+// lookup 返回缓存的响应副本及其是否仍在有效期内, TTL 已按经过的时间扣减
+func (r *Resolver) lookup(key string) ([]byte, bool) {
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	now := time.Now()
+	msg := bytes.Clone(entry.msg)
+	decreaseTTLs(msg, uint32(now.Sub(entry.storedAt)/time.Second))
+	return msg, now.Before(entry.expiresAt)
+}
+
+func (r *Resolver) store(key string, msg []byte) {
+	if r.cacheSize <= 0 {
+		return
+	}
+
+	ttl, ok := minTTL(msg)
+	if !ok {
+		ttl = negativeTTL
+	}
+	if ttl == 0 {
+		return
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.cache[key]; !exists && len(r.cache) >= r.cacheSize {
+		r.evict(now)
+	}
+	r.cache[key] = &cacheEntry{
+		msg:       bytes.Clone(msg),
+		storedAt:  now,
+		expiresAt: now.Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// evict 移除超过保留时间的过期缓存，仍然已满时移除最早过期的条目
+func (r *Resolver) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range r.cache {
+		if now.Sub(entry.expiresAt) > staleTTL {
+			delete(r.cache, key)
+			continue
+		}
+		if oldestKey == "" || entry.expiresAt.Before(oldest) {
+			oldestKey, oldest = key, entry.expiresAt
+		}
+	}
+	if len(r.cache) >= r.cacheSize && oldestKey != "" {
+		delete(r.cache, oldestKey)
+	}
+}
+
+// ServeHTTP 实现 RFC 8484 的 GET 与 POST 请求
+func (r *Resolver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var query []byte
+	var err error
+
+	switch req.Method {
+	case http.MethodGet:
+		param := req.URL.Query().Get("dns")
+		if param == "" {
+			http.Error(w, "missing dns parameter", http.StatusBadRequest)
+			return
+		}
+		query, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+	case http.MethodPost:
+		if mediaType, _, _ := strings.Cut(req.Header.Get("Content-Type"), ";"); strings.TrimSpace(mediaType) != ContentType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		query, err = io.ReadAll(io.LimitReader(req.Body, maxMessageSize))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid dns message", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := r.Resolve(req.Context(), query)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errBadRequest) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	ttl, ok := minTTL(resp)
+	if !ok {
+		ttl = negativeTTL
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+	_, _ = w.Write(resp)
+}
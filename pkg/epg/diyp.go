@@ -0,0 +1,96 @@
+package epg
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// DIYP 是 TvBox 等客户端使用的按频道、按日期查询的 EPG 接口格式
+type DIYP struct {
+	ChannelName string      `json:"channel_name"`
+	Date        string      `json:"date"`
+	URL         string      `json:"url,omitempty"`
+	EPGData     []DIYPEntry `json:"epg_data"`
+}
+
+type DIYPEntry struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Title string `json:"title"`
+	Desc  string `json:"desc"`
+}
+
+const (
+	DIYPDateLayout = "2006-01-02"
+	diypTimeLayout = "15:04"
+)
+
+var timeLayouts = []string{
+	"20060102150405 -0700",
+	"20060102150405",
+}
+
+// ParseTime 解析 XMLTV 中的时间，支持带时区与不带时区两种格式
+func ParseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	var err error
+	for _, layout := range timeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// FindChannel 根据频道 ID 或显示名称查找频道，不区分大小写
+func (e *EPG) FindChannel(name string) (Channel, bool) {
+	for _, channel := range e.Channel {
+		if strings.EqualFold(channel.ID, name) || strings.EqualFold(channel.DisplayName.Text, name) {
+			return channel, true
+		}
+	}
+	return Channel{}, false
+}
+
+// DIYP 返回指定频道在指定日期的节目单，日期以 loc 时区计算
+func (e *EPG) DIYP(channel Channel, date time.Time, loc *time.Location) DIYP {
+	date = date.In(loc)
+	result := DIYP{
+		ChannelName: channel.DisplayName.Text,
+		Date:        date.Format(DIYPDateLayout),
+		URL:         channel.URL,
+		EPGData:     []DIYPEntry{},
+	}
+
+	for _, programme := range e.Programme {
+		if programme.Channel != channel.ID {
+			continue
+		}
+		start, err := ParseTime(programme.Start)
+		if err != nil {
+			continue
+		}
+		start = start.In(loc)
+		if start.Format(DIYPDateLayout) != result.Date {
+			continue
+		}
+
+		entry := DIYPEntry{
+			Start: start.Format(diypTimeLayout),
+			Title: programme.Title.Text,
+			Desc:  programme.Desc.Text,
+		}
+		if stop, err := ParseTime(programme.Stop); err == nil {
+			entry.End = stop.In(loc).Format(diypTimeLayout)
+		}
+		result.EPGData = append(result.EPGData, entry)
+	}
+
+	sort.SliceStable(result.EPGData, func(i, j int) bool {
+		return result.EPGData[i].Start < result.EPGData[j].Start
+	})
+
+	return result
+}
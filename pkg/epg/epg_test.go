@@ -2,6 +2,7 @@ package epg
 
 import (
 	"testing"
+	"time"
 )
 
 const epgData = `
@@ -37,3 +38,50 @@ func TestUnmarshal(t *testing.T) {
 		t.Errorf("Expected programme channel 'example.com', got '%s'", epg.Programme[0].Channel)
 	}
 }
+
+func TestDIYP(t *testing.T) {
+	data := `
+<tv>
+	<channel id="cctv1">
+		<display-name lang="zh">CCTV1</display-name>
+		<icon src="http://example.com/cctv1.png" />
+	</channel>
+	<programme channel="cctv1" start="20240101080000 +0000" stop="20240101090000 +0000">
+		<title lang="zh">新闻</title>
+	</programme>
+	<programme channel="cctv1" start="20240101070000 +0000" stop="20240101080000 +0000">
+		<title lang="zh">早间</title>
+		<desc lang="zh">早间节目</desc>
+	</programme>
+	<programme channel="cctv1" start="20240102070000 +0000" stop="20240102080000 +0000">
+		<title lang="zh">次日</title>
+	</programme>
+</tv>`
+
+	epg, err := Unmarshal([]byte(data))
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	channel, ok := epg.FindChannel("cctv1")
+	if !ok || channel.Icon.Src != "http://example.com/cctv1.png" {
+		t.Fatalf("Expected to find channel cctv1, got %+v", channel)
+	}
+
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	result := epg.DIYP(channel, date, time.UTC)
+
+	if result.ChannelName != "CCTV1" || result.Date != "2024-01-01" {
+		t.Errorf("Unexpected DIYP header: %+v", result)
+	}
+	if len(result.EPGData) != 2 {
+		t.Fatalf("Expected 2 programmes, got %d", len(result.EPGData))
+	}
+	expected := DIYPEntry{Start: "07:00", End: "08:00", Title: "早间", Desc: "早间节目"}
+	if result.EPGData[0] != expected {
+		t.Errorf("Expected %+v, got %+v", expected, result.EPGData[0])
+	}
+	if result.EPGData[1].Title != "新闻" {
+		t.Errorf("Expected second programme '新闻', got %s", result.EPGData[1].Title)
+	}
+}
@@ -0,0 +1,200 @@
+package imageutil
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	// MaxBadgeLabel is the longest label drawn on a badge, long enough for names such as "CCTV5+".
+	MaxBadgeLabel = 6
+	// maxHanLabel is the number of Han characters taken from Chinese names, e.g. "湖南" of "湖南卫视".
+	maxHanLabel = 2
+)
+
+// BadgeLabel derives a short label from a channel name for use with Badge.
+// Names are split into runs of letters, digits and '+', e.g. "CCTV-1 综合" becomes "CCTV1".
+// Longer names fall back to their first word or the initials of each word. Names starting with
+// Han characters use the first two of them when the embedded font can draw them, e.g. "湖南卫视 HD"
+// becomes "湖南", names without any drawable characters are labelled "TV".
+func BadgeLabel(name string) string {
+	var tokens []string
+	var han []rune
+	hanFirst := false
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range name {
+		// Fold full-width ASCII into half-width
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		switch {
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			current.WriteRune(r)
+		case r == '+' && current.Len() > 0:
+			current.WriteRune(r)
+		default:
+			if unicode.Is(unicode.Han, r) {
+				hanFirst = hanFirst || (len(han) == 0 && len(tokens) == 0 && current.Len() == 0)
+				han = append(han, r)
+			}
+			flush()
+		}
+	}
+	flush()
+
+	if label, ok := hanLabel(han); ok && (hanFirst || len(tokens) == 0) {
+		return label
+	}
+	if len(tokens) == 0 {
+		return "TV"
+	}
+	if joined := strings.Join(tokens, ""); len(joined) <= MaxBadgeLabel {
+		return joined
+	}
+	if len(tokens[0]) <= MaxBadgeLabel {
+		return tokens[0]
+	}
+	if len(tokens) > 1 {
+		var initials strings.Builder
+		for _, token := range tokens[:min(len(tokens), 4)] {
+			initials.WriteByte(token[0])
+		}
+		return initials.String()
+	}
+	return tokens[0][:4]
+}
+
+// hanLabel returns the first maxHanLabel Han characters if the embedded font can draw all of them.
+func hanLabel(han []rune) (string, bool) {
+	if len(han) < maxHanLabel {
+		return "", false
+	}
+	for _, r := range han[:maxHanLabel] {
+		if !hasGlyph(r) {
+			return "", false
+		}
+	}
+	return string(han[:maxHanLabel]), true
+}
+
+// BadgeColor derives a stable background colour from seed, so that the same channel always
+// gets the same colour while different channels are spread over the hue circle.
+func BadgeColor(seed string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	hue := float64(h.Sum32() % 360)
+	return hsvToRGB(hue, 0.55, 0.7)
+}
+
+// Badge renders a size x size badge with label drawn in white over the colour derived from seed.
+// The label is drawn with the embedded bitmap fonts, characters they cannot draw are skipped.
+func Badge(label, seed string, size int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	if size <= 0 {
+		return img
+	}
+
+	draw.Draw(img, img.Bounds(), &image.Uniform{BadgeColor(seed)}, image.Point{}, draw.Src)
+	roundCorners(img, size/8)
+
+	var runes []rune
+	for _, r := range strings.ToUpper(label) {
+		if hasGlyph(r) && len(runes) < MaxBadgeLabel {
+			runes = append(runes, r)
+		}
+	}
+	if len(runes) == 0 {
+		return img
+	}
+
+	width, height := textSize(runes)
+	scale := max(min(size*4/5/width, size/2/height), 1)
+	x0 := (size - width*scale) / 2
+	y0 := (size - height*scale) / 2
+	white := &image.Uniform{color.White}
+
+	offset := 0
+	for _, r := range runes {
+		rows, _ := glyph(r)
+		// Glyphs shorter than the line, such as letters next to Han characters, are centred vertically
+		top := (height - len(rows)) / 2
+		for row, line := range rows {
+			for col := 0; col < len(line); col++ {
+				if line[col] != '#' {
+					continue
+				}
+				x := x0 + (offset+col)*scale
+				y := y0 + (top+row)*scale
+				draw.Draw(img, image.Rect(x, y, x+scale, y+scale), white, image.Point{}, draw.Src)
+			}
+		}
+		offset += len(rows[0]) + 1
+	}
+
+	return img
+}
+
+// roundCorners clears the pixels outside the rounded corners of the given radius.
+func roundCorners(img *image.RGBA, radius int) {
+	if radius <= 0 {
+		return
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	for y := 0; y < radius; y++ {
+		for x := 0; x < radius; x++ {
+			dx, dy := float64(radius-x)-0.5, float64(radius-y)-0.5
+			if dx*dx+dy*dy <= float64(radius*radius) {
+				continue
+			}
+			for _, p := range []image.Point{{x, y}, {w - 1 - x, y}, {x, h - 1 - y}, {w - 1 - x, h - 1 - y}} {
+				img.Set(bounds.Min.X+p.X, bounds.Min.Y+p.Y, color.Transparent)
+			}
+		}
+	}
+}
+
+func hsvToRGB(h, s, v float64) color.RGBA {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	//nolint:gosec // channel values are within [0, 255]
+	return color.RGBA{
+		R: uint8(math.Round((r + m) * 255)),
+		G: uint8(math.Round((g + m) * 255)),
+		B: uint8(math.Round((b + m) * 255)),
+		A: 255,
+	}
+}
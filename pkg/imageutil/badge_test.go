@@ -0,0 +1,70 @@
+package imageutil
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBadgeLabel(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"CCTV-1 综合", "CCTV1"},
+		{"CCTV5+ 体育赛事", "CCTV5+"},
+		{"ＣＧＴＮ", "CGTN"},
+		{"Discovery Channel", "DC"},
+		{"Documentary", "DOCU"},
+		{"湖南卫视", "湖南"},
+		{"北京卫视 HD", "北京"},
+		{"凤凰卫视 HD", "HD"},
+		{"深圳·卫视", "深圳"},
+		{"凤凰卫视", "TV"}, // 凤 has no glyph
+		{"上", "TV"},
+		{"", "TV"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, BadgeLabel(tt.name))
+		})
+	}
+}
+
+func TestHanGlyphs(t *testing.T) {
+	for r, rows := range hanGlyphs {
+		for _, row := range rows {
+			assert.Len(t, row, hanGlyphSize, "glyph %c", r)
+		}
+	}
+}
+
+func TestBadge(t *testing.T) {
+	background := BadgeColor("湖南卫视")
+	assert.Equal(t, background, BadgeColor("湖南卫视"))
+	assert.NotEqual(t, background, BadgeColor("东方卫视"))
+
+	countWhite := func(label string) int {
+		img := Badge(label, "湖南卫视", 128)
+		assert.Equal(t, 128, img.Bounds().Dx())
+		assert.Equal(t, color.RGBA{}, img.RGBAAt(0, 0), "corners are rounded off")
+		assert.Equal(t, background, img.RGBAAt(64, 2))
+
+		n := 0
+		for y := 0; y < 128; y++ {
+			for x := 0; x < 128; x++ {
+				if img.RGBAAt(x, y) == (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	assert.Positive(t, countWhite("CCTV1"))
+	assert.Positive(t, countWhite("湖南"))
+	assert.Positive(t, countWhite("湖南HD"))
+	assert.Zero(t, countWhite("凤凰"))
+	assert.Zero(t, countWhite(""))
+}
@@ -0,0 +1,49 @@
+package imageutil
+
+// hanGlyphSize is the width and height of the embedded Han bitmap font.
+const hanGlyphSize = 12
+
+// hanGlyphs is a 12x12 bitmap font covering the Han characters that start common Chinese channel
+// names, mostly the provinces and cities of satellite channels, so that e.g. "湖南卫视" is labelled
+// "湖南". '#' marks a set pixel.
+var hanGlyphs = map[rune][hanGlyphSize]string{
+	'东': {".....#......", "....#.......", "###########.", "...#..#.....", "..#...#.....", ".#########..", "......#.....", "......#.....", "...#..#..#..", "..#...#...#.", ".#....#....#", ".....##....."},
+	'南': {".....#......", "###########.", ".....#......", "###########.", "#..#...#..#.", "#...#.#...#.", "#.#######.#.", "#....#....#.", "#.#######.#.", "#....#....#.", "#....#....#.", "#....#...#.."},
+	'西': {"############", "....#..#....", "....#..#....", ".##########.", ".#..#..#..#.", ".#..#..#..#.", ".#..#..#..#.", ".#..#..#..#.", ".#.....####.", ".#........#.", ".#........#.", ".##########."},
+	'北': {"....#..#....", "....#..#....", "....#..#....", "....#..#..#.", ".####..#.#..", "....#..##...", "....#..#....", "....#..#....", "...##..#....", ".##.#..#...#", "#...#..#####", "....#......."},
+	'上': {".....#......", ".....#......", ".....#......", ".....#......", ".....#......", ".....#####..", ".....#......", ".....#......", ".....#......", ".....#......", ".....#......", "############"},
+	'山': {".....#......", ".....#......", ".....#......", ".....#......", ".#...#....#.", ".#...#....#.", ".#...#....#.", ".#...#....#.", ".#...#....#.", ".#...#....#.", ".##########.", "............"},
+	'川': {".#........#.", ".#...#....#.", ".#...#....#.", ".#...#....#.", ".#...#....#.", ".#...#....#.", ".#...#....#.", ".#...#....#.", ".#...#....#.", "#....#....#.", "#....#....#.", "#.........#."},
+	'江': {"............", "#...#######.", ".#.....#....", ".......#....", "#......#....", ".#.....#....", ".......#....", "..#....#....", "..#....#....", ".#.....#....", "#..#########", "#..........."},
+	'河': {"............", "#..#########", ".#........#.", "....#####.#.", "#...#...#.#.", ".#..#...#.#.", "....#...#.#.", "..#.#####.#.", "..#.......#.", ".#........#.", "#.........#.", "#.......##.."},
+	'湖': {"#...#...####", ".#..#...#..#", "..#####.#..#", "#...#...####", ".#..#...#..#", "........#..#", "..#####.####", "..#...#.#..#", "..#...#.#..#", ".##...#.#..#", "#.#####.#..#", "#......#..##"},
+	'海': {"#....#......", ".#..########", "....#.......", "#...#######.", ".#..#..#..#.", "....#.....#.", "...#########", "....#.....#.", "....#..#..#.", ".#..#######.", "#.........#.", "#........##."},
+	'天': {"............", ".##########.", ".....#......", ".....#......", "############", ".....#......", ".....##.....", "....#..#....", "...#....#...", "..#......#..", ".#........#.", "#..........#"},
+	'京': {".....#......", "############", "............", "..########..", "..#......#..", "..#......#..", "..########..", ".....#......", ".....#......", "..#..#..#...", ".#...#...#..", "....##......"},
+	'津': {".......#....", "#...#######.", ".#.....#..#.", "...#########", "#......#..#.", ".#..#######.", ".......#....", "..#.#######.", "..#....#....", ".#.#########", "#......#....", "#......#...."},
+	'重': {".....#####..", "############", "..########..", "..#..#...#..", "..########..", "..#..#...#..", "..########..", ".....#......", ".##########.", ".....#......", ".....#......", "############"},
+	'庆': {"......#.....", "......#.....", "..##########", "..#....#....", "..#....#....", "..##########", "..#....##...", "..#...#..#..", "..#..#...#..", ".#...#....#.", ".#..#.....#.", "#..#.......#"},
+	'广': {"......#.....", "......#.....", "..##########", "..#.........", "..#.........", "..#.........", "..#.........", "..#.........", "..#.........", ".#..........", ".#..........", "#..........."},
+	'深': {"#......#....", ".#.#########", "...#.......#", "#.....#.#...", ".#...#...#..", ".......#....", "...#########", "......###...", ".....#.#.#..", ".#..#..#..#.", "#..#...#...#", "#......#...."},
+	'圳': {"...........#", "..#...#....#", "..#...#.#..#", "..#...#.#..#", "#####.#.#..#", "..#...#.#..#", "..#...#.#..#", "..#...#.#..#", "..###.#.#..#", "..#...#.#..#", "##...#..#..#", ".....#.....#"},
+	'浙': {"#...#.....##", ".#..#...##..", "....#...#...", "#.####..#...", ".#..#...#...", "....#...####", "....#...#.#.", "....##..#.#.", "...##...#.#.", ".##.#...#.#.", "#...#..#..#.", "#..##..#..#."},
+	'苏': {"...#....#...", "############", "...#....#...", "............", "......#.....", "..########..", ".....#...#..", ".#...#...#.#", "#...#....#.#", "...#.....#..", "..#......#..", ".#......##.."},
+	'福': {".#...#######", "............", "####..#####.", "...#..#...#.", "..#...#####.", ".##.........", "#.##.#######", "..#..#..#..#", "..#..#######", "..#..#..#..#", "..#..#..#..#", "..#..#######"},
+	'建': {"........#...", "###..######.", "..#.....#.#.", ".#..########", "###.....#.#.", "..#..######.", "..#.....#...", ".#..########", "#.#.....#...", "...#....#...", "....#.......", ".....#######"},
+	'安': {".....#......", ".##########.", ".#........#.", ".....#......", ".....#......", "....#...#...", "############", "...#...#....", "....#.#.....", ".....#......", "...#...#....", ".##.....###."},
+	'吉': {".....#......", ".##########.", ".....#......", ".....#......", "...######...", "............", "..########..", "..#......#..", "..#......#..", "..#......#..", "..#......#..", "..########.."},
+	'林': {"..#......#..", "..#......#..", "..#......#..", "#####.######", ".###....###.", "#.#.#..#.#.#", "..#...#..#..", "..#......#..", "..#......#..", "..#......#..", "..#......#..", "..#......#.."},
+	'辽': {"....########", ".#........#.", "..#......#..", "........#...", "###.....#...", "..#.....#...", "..#.....#...", "..#.....#...", "..#.....#...", ".#.....##...", "..#.........", "...#########"},
+	'宁': {".....#......", ".##########.", ".#........#.", "............", "............", "############", "......#.....", "......#.....", "......#.....", "......#.....", "......#.....", ".....##....."},
+	'云': {"............", "..########..", "............", "............", "############", ".....#......", "....#.......", "...#........", "...#....#...", "..#......#..", ".#########..", "............"},
+	'贵': {".....#......", "..########..", "..#..#...#..", "..########..", ".....#......", "############", "..########..", "..#..#...#..", "..#..#...#..", "..########..", "...#....#...", "..#......#.."},
+	'州': {"..#...#...#.", "..#...#...#.", "..#...#...#.", "..#...#...#.", "#.#...#...#.", "..#.#.#.#.#.", "..#...#...#.", "..#...#...#.", "..#...#...#.", ".#....#...#.", "#.....#...#.", "#.....#...#."},
+	'四': {"............", "############", "#...#..#...#", "#...#..#...#", "#...#..#...#", "#..#...#...#", "#..#...#...#", "#..#...#####", "#..........#", "#..........#", "#..........#", "############"},
+	'甘': {"...#....#...", "...#....#...", "...#....#...", "############", "...#....#...", "...#....#...", "...#....#...", "...######...", "...#....#...", "...#....#...", "...#....#...", "...######..."},
+	'肃': {".....#......", "..########..", ".....#...#..", "############", ".....#...#..", "..########..", ".....#......", "..#..#...#..", "..##.#..##..", "..#.##.#.#..", "..#..#...#..", "..#..#...#.."},
+	'青': {".....#......", ".##########.", ".....#......", "..########..", ".....#......", "############", "............", "..########..", "..#......#..", "..########..", "..#......#..", "..#.....##.."},
+	'夏': {"############", ".....#......", "..########..", "..#......#..", "..########..", "..#......#..", "..########..", "....#.......", "...#######..", "..#.#...#...", ".....###....", "..###...###."},
+	'新': {"..#.......##", "######..##..", ".#..#...#...", "######..#...", "..#.....####", "######..#.#.", "..#.....#.#.", ".###....#.#.", "#.#.#..#..#.", "..#....#..#.", "..#...#...#.", "..#.......#."},
+	'陕': {"###....#....", "#.#....#....", "##..########", "#.#..#.#..#.", "#.#...##.#..", "##..########", "#......##...", "#.....#..#..", "#....#....#.", "#....#....#.", "#...#......#", "#...#......#"},
+	'方': {".....#......", "......#.....", "############", "....#.......", "....#.......", "...#######..", "...#.....#..", "..#......#..", "..#......#..", "..#......#..", ".#.......#..", ".#.....##..."},
+}
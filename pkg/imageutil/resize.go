@@ -0,0 +1,66 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+)
+
+// Fit scales the image down so that neither side exceeds maxSize, keeping the aspect ratio.
+// Images that already fit are returned unchanged; images are never scaled up.
+func Fit(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if maxSize <= 0 || (w <= maxSize && h <= maxSize) {
+		return img
+	}
+
+	dw, dh := maxSize, maxSize
+	if w > h {
+		dh = max(h*maxSize/w, 1)
+	} else {
+		dw = max(w*maxSize/h, 1)
+	}
+	return Resize(img, dw, dh)
+}
+
+// Resize scales the image to width x height by averaging the source pixels covered by each
+// destination pixel, which gives smooth results when shrinking logos.
+func Resize(img image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	src := img.Bounds()
+	sw, sh := src.Dx(), src.Dy()
+	if sw == 0 || sh == 0 || width <= 0 || height <= 0 {
+		return dst
+	}
+
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*sh/height
+		y1 := max(src.Min.Y+(y+1)*sh/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*sw/width
+			x1 := max(src.Min.X+(x+1)*sw/width, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+
+			//nolint:gosec // averages of 16-bit channels always fit in uint16
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,42 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFit(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 512, 256))
+
+	assert.Equal(t, image.Rect(0, 0, 128, 64), Fit(img, 128).Bounds())
+	assert.Equal(t, image.Rect(0, 0, 32, 64), Fit(image.NewRGBA(image.Rect(0, 0, 256, 512)), 64).Bounds())
+
+	// Images that already fit and non-positive sizes are returned unchanged
+	assert.Same(t, img, Fit(img, 512))
+	assert.Same(t, img, Fit(img, 0))
+}
+
+func TestResize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if x%2 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	dst := Resize(img, 2, 1)
+	assert.Equal(t, image.Rect(0, 0, 2, 1), dst.Bounds())
+	// Each destination pixel averages one white and one black pixel
+	gray := color.RGBA{R: 127, G: 127, B: 127, A: 255}
+	assert.Equal(t, gray, dst.RGBAAt(0, 0))
+	assert.Equal(t, gray, dst.RGBAAt(1, 0))
+
+	assert.Equal(t, image.Rect(0, 0, 3, 3), Resize(image.NewRGBA(image.Rectangle{}), 3, 3).Bounds())
+}
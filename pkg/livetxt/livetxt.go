@@ -0,0 +1,133 @@
+// Package livetxt 解析与生成国内 IPTV 常用的 TXT 直播源列表
+//
+// 格式示例:
+//
+//	央视频道,#genre#
+//	CCTV1,http://example.com/cctv1.m3u8#http://backup.example.com/cctv1.m3u8
+//	CCTV2,http://example.com/cctv2.m3u8
+//
+// 分组行以 ",#genre#" 结尾，频道行为 "频道名,地址"，多个备用地址以 # 分隔
+package livetxt
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+const genreMarker = "#genre#"
+
+// Playlist 为 TXT 直播源列表，出现在任何分组行之前的频道归入名称为空的分组
+type Playlist struct {
+	Groups []Group
+}
+
+type Group struct {
+	Name     string
+	Channels []Channel
+}
+
+// Channel 为一个频道，URLs 按优先级排列，第一个为主地址
+type Channel struct {
+	Name string
+	URLs []string
+}
+
+// Unmarshal parses the TXT-encoded data and stores the result in p.
+func Unmarshal(data []byte, p *Playlist) error {
+	return p.UnmarshalTXT(data)
+}
+
+func Marshal(p *Playlist) ([]byte, error) {
+	return p.MarshalTXT()
+}
+
+// UnmarshalTXT 解析 TXT 直播源列表，无法识别的行会被忽略
+func (p *Playlist) UnmarshalTXT(data []byte) error {
+	p.Groups = nil
+
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		p.parseLine(strings.TrimSpace(scanner.Text()))
+	}
+
+	return scanner.Err()
+}
+
+func (p *Playlist) parseLine(line string) {
+	name, value, ok := strings.Cut(line, ",")
+	if !ok || strings.HasPrefix(line, "#") {
+		return
+	}
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+
+	if strings.EqualFold(value, genreMarker) {
+		p.Groups = append(p.Groups, Group{Name: name})
+		return
+	}
+
+	urls := SplitURLs(value)
+	if name == "" || len(urls) == 0 {
+		return
+	}
+
+	if len(p.Groups) == 0 {
+		p.Groups = append(p.Groups, Group{})
+	}
+	group := &p.Groups[len(p.Groups)-1]
+	group.Channels = append(group.Channels, Channel{Name: name, URLs: urls})
+}
+
+// SplitURLs 拆分以 # 分隔的备用地址
+// 仅当 # 之后的内容是一个完整的地址时才视为分隔符，以保留地址中的片段标识
+func SplitURLs(value string) []string {
+	var urls []string
+	for _, part := range strings.Split(value, "#") {
+		part = strings.TrimSpace(part)
+		if len(urls) > 0 && !strings.Contains(part, "://") {
+			urls[len(urls)-1] += "#" + part
+			continue
+		}
+		if part != "" {
+			urls = append(urls, part)
+		}
+	}
+	return urls
+}
+
+// MarshalTXT 生成 TXT 直播源列表，分组之间以空行分隔
+// 名称中的半角逗号与换行无法在 TXT 格式中表示，会被替换为全角逗号与空格
+func (p *Playlist) MarshalTXT() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	for i, group := range p.Groups {
+		if i > 0 {
+			buf.WriteRune('\n')
+		}
+		if group.Name != "" || i > 0 {
+			buf.WriteString(escapeName(group.Name))
+			buf.WriteString("," + genreMarker + "\n")
+		}
+		for _, channel := range group.Channels {
+			if len(channel.URLs) == 0 {
+				continue
+			}
+			buf.WriteString(escapeName(channel.Name))
+			buf.WriteRune(',')
+			buf.WriteString(strings.Join(channel.URLs, "#"))
+			buf.WriteRune('\n')
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+var nameReplacer = strings.NewReplacer(",", "，", "\r", " ", "\n", " ")
+
+func escapeName(name string) string {
+	return nameReplacer.Replace(name)
+}
@@ -0,0 +1,63 @@
+package livetxt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshal(t *testing.T) {
+	data := []byte("\xef\xbb\xbfCCTV0,http://example.com/cctv0.m3u8\r\n" +
+		"央视频道,#genre#\r\n" +
+		"CCTV1,http://example.com/cctv1.m3u8#http://backup.example.com/cctv1.m3u8\r\n" +
+		"CCTV2, http://example.com/cctv2.m3u8#token \n" +
+		"\n" +
+		"# comment\n" +
+		"invalid line\n" +
+		"empty,\n" +
+		"卫视频道,#Genre#\n" +
+		"湖南卫视,http://example.com/hunan.m3u8$线路1\n")
+
+	var playlist Playlist
+	assert.NoError(t, Unmarshal(data, &playlist))
+	assert.Equal(t, []Group{
+		{Channels: []Channel{{Name: "CCTV0", URLs: []string{"http://example.com/cctv0.m3u8"}}}},
+		{Name: "央视频道", Channels: []Channel{
+			{Name: "CCTV1", URLs: []string{"http://example.com/cctv1.m3u8", "http://backup.example.com/cctv1.m3u8"}},
+			{Name: "CCTV2", URLs: []string{"http://example.com/cctv2.m3u8#token"}},
+		}},
+		{Name: "卫视频道", Channels: []Channel{
+			{Name: "湖南卫视", URLs: []string{"http://example.com/hunan.m3u8$线路1"}},
+		}},
+	}, playlist.Groups)
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	playlist := Playlist{Groups: []Group{
+		{Channels: []Channel{{Name: "CCTV0", URLs: []string{"http://example.com/cctv0.m3u8"}}}},
+		{Name: "央视频道", Channels: []Channel{
+			{Name: "CCTV1", URLs: []string{"http://example.com/cctv1.m3u8", "http://backup.example.com/cctv1.m3u8"}},
+			{Name: "A,B", URLs: []string{"http://example.com/ab.m3u8"}},
+			{Name: "NoURL"},
+		}},
+		{Name: "", Channels: []Channel{{Name: "Other", URLs: []string{"http://example.com/other.m3u8"}}}},
+	}}
+
+	data, err := Marshal(&playlist)
+	assert.NoError(t, err)
+	assert.Equal(t, "CCTV0,http://example.com/cctv0.m3u8\n"+
+		"\n"+
+		"央视频道,#genre#\n"+
+		"CCTV1,http://example.com/cctv1.m3u8#http://backup.example.com/cctv1.m3u8\n"+
+		"A，B,http://example.com/ab.m3u8\n"+
+		"\n"+
+		",#genre#\n"+
+		"Other,http://example.com/other.m3u8\n", string(data))
+
+	var parsed Playlist
+	assert.NoError(t, Unmarshal(data, &parsed))
+	again, err := Marshal(&parsed)
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), string(again))
+	assert.Len(t, parsed.Groups, 3)
+}
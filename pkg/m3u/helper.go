@@ -7,6 +7,10 @@ import (
 
 var reKeyValue = regexp.MustCompile(`([a-zA-Z0-9_-]+)=("[^"]+"|[^",]+)`)
 
+// reEnumerated matches attribute values that are written without quotes: integers, floats,
+// hexadecimal sequences, resolutions and enumerated strings such as NONE or TYPE-0.
+var reEnumerated = regexp.MustCompile(`^(?:0[xX][0-9A-Fa-f]+|-?[0-9]+(?:\.[0-9]+)?(?:x[0-9]+)?|[A-Z0-9-]+)$`)
+
 // DecodeAttributeList turns an attribute list into a key, value map. You should trim
 // any characters not part of the attribute list, such as the tag and ':'.
 func DecodeAttributeList(line string) map[string]string {
@@ -21,3 +25,109 @@ func decodeParamsLine(line string) map[string]string {
 	}
 	return out
 }
+
+// attribute is a single entry of an HLS attribute list
+type attribute struct {
+	Key    string
+	Value  string
+	Quoted bool
+}
+
+// parseAttributes parses an HLS attribute list such as `BANDWIDTH=800000,CODECS="avc1.4d401f,mp4a.40.2"`,
+// keeping the order of the attributes. Quoted values may contain commas, keys are upper-cased.
+func parseAttributes(line string) []attribute {
+	var attrs []attribute
+	for {
+		line = strings.TrimLeft(line, " ,")
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return attrs
+		}
+		key := line[:eq]
+		if i := strings.LastIndexByte(key, ','); i >= 0 {
+			key = key[i+1:] // Skip malformed attributes without a value
+		}
+		key = strings.ToUpper(strings.TrimSpace(key))
+		line = line[eq+1:]
+
+		attr := attribute{Key: key}
+		if strings.HasPrefix(line, `"`) {
+			attr.Quoted = true
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				attr.Value, line = line[1:], ""
+			} else {
+				attr.Value, line = line[1:end+1], line[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(line, ',')
+			if end < 0 {
+				attr.Value, line = strings.TrimSpace(line), ""
+			} else {
+				attr.Value, line = strings.TrimSpace(line[:end]), line[end+1:]
+			}
+		}
+
+		if key != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+}
+
+// formatAttributes writes an HLS attribute list
+func formatAttributes(attrs []attribute) string {
+	parts := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		if attr.Quoted {
+			parts = append(parts, attr.Key+`="`+attr.Value+`"`)
+		} else {
+			parts = append(parts, attr.Key+"="+attr.Value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// tagAttributes converts unrecognised attributes kept as tags back into attributes.
+// Attributes read from a playlist keep the quoting they were parsed with, for tags added by callers
+// values that look like numbers, resolutions or enumerated strings are written without quotes.
+func tagAttributes(tags []Tag, quoted map[string]bool) []attribute {
+	attrs := make([]attribute, 0, len(tags))
+	for _, tag := range tags {
+		q, ok := quoted[tag.Name]
+		if !ok {
+			q = !reEnumerated.MatchString(tag.Value)
+		}
+		attrs = append(attrs, attribute{Key: tag.Name, Value: tag.Value, Quoted: q})
+	}
+	return attrs
+}
+
+// markQuoted records whether an unrecognised attribute was quoted when parsed
+func markQuoted(quoted map[string]bool, attr attribute) map[string]bool {
+	if quoted == nil {
+		quoted = make(map[string]bool)
+	}
+	quoted[attr.Key] = attr.Quoted
+	return quoted
+}
+
+// attributeBuilder collects the attributes of a tag, skipping empty values
+type attributeBuilder []attribute
+
+func (b *attributeBuilder) quoted(key, value string) {
+	if value != "" {
+		*b = append(*b, attribute{Key: key, Value: value, Quoted: true})
+	}
+}
+
+func (b *attributeBuilder) plain(key, value string) {
+	if value != "" {
+		*b = append(*b, attribute{Key: key, Value: value})
+	}
+}
+
+func (b *attributeBuilder) flag(key string, value bool) {
+	if value {
+		*b = append(*b, attribute{Key: key, Value: "YES"})
+	}
+}
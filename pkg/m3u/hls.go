@@ -0,0 +1,233 @@
+package m3u
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// programDateTimeLayout is used to write EXT-X-PROGRAM-DATE-TIME, with millisecond precision
+const programDateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// programDateTimeLayouts are accepted when reading EXT-X-PROGRAM-DATE-TIME, some servers omit the colon in the zone
+var programDateTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999Z0700"}
+
+// Key represents an EXT-X-KEY tag, it applies to the segment it precedes and all following segments
+// until the next EXT-X-KEY with the same KEYFORMAT
+type Key struct {
+	Method            string // NONE, AES-128 or SAMPLE-AES
+	URI               string
+	IV                string
+	KeyFormat         string
+	KeyFormatVersions string
+}
+
+func parseKey(line string) Key {
+	var key Key
+	for _, attr := range parseAttributes(line) {
+		switch attr.Key {
+		case "METHOD":
+			key.Method = attr.Value
+		case "URI":
+			key.URI = attr.Value
+		case "IV":
+			key.IV = attr.Value
+		case "KEYFORMAT":
+			key.KeyFormat = attr.Value
+		case "KEYFORMATVERSIONS":
+			key.KeyFormatVersions = attr.Value
+		}
+	}
+	return key
+}
+
+func (k *Key) MarshalM3U() ([]byte, error) {
+	var attrs attributeBuilder
+	attrs.plain("METHOD", k.Method)
+	attrs.quoted("URI", k.URI)
+	attrs.plain("IV", k.IV)
+	attrs.quoted("KEYFORMAT", k.KeyFormat)
+	attrs.quoted("KEYFORMATVERSIONS", k.KeyFormatVersions)
+	return []byte("#EXT-X-KEY:" + formatAttributes(attrs) + "\n"), nil
+}
+
+// Map represents an EXT-X-MAP tag pointing at the media initialization section, it applies to
+// the segment it precedes and all following segments until the next EXT-X-MAP
+type Map struct {
+	URI       string
+	ByteRange string
+}
+
+func parseMap(line string) Map {
+	var m Map
+	for _, attr := range parseAttributes(line) {
+		switch attr.Key {
+		case "URI":
+			m.URI = attr.Value
+		case "BYTERANGE":
+			m.ByteRange = attr.Value
+		}
+	}
+	return m
+}
+
+func (m *Map) MarshalM3U() ([]byte, error) {
+	var attrs attributeBuilder
+	attrs.quoted("URI", m.URI)
+	attrs.quoted("BYTERANGE", m.ByteRange)
+	return []byte("#EXT-X-MAP:" + formatAttributes(attrs) + "\n"), nil
+}
+
+// Rendition represents an alternative rendition declared with EXT-X-MEDIA in a master playlist
+type Rendition struct {
+	Type            string // AUDIO, VIDEO, SUBTITLES or CLOSED-CAPTIONS
+	GroupID         string
+	Name            string
+	Language        string
+	AssocLanguage   string
+	URI             string
+	Default         bool
+	AutoSelect      bool
+	Forced          bool
+	InstreamID      string
+	Characteristics string
+	Channels        string
+
+	Tags   []Tag
+	quoted map[string]bool // Quoting of the unrecognised attributes in Tags as parsed
+}
+
+func parseRendition(line string) Rendition {
+	r := Rendition{Tags: make([]Tag, 0)}
+	for _, attr := range parseAttributes(line) {
+		switch attr.Key {
+		case "TYPE":
+			r.Type = attr.Value
+		case "GROUP-ID":
+			r.GroupID = attr.Value
+		case "NAME":
+			r.Name = attr.Value
+		case "LANGUAGE":
+			r.Language = attr.Value
+		case "ASSOC-LANGUAGE":
+			r.AssocLanguage = attr.Value
+		case "URI":
+			r.URI = attr.Value
+		case "DEFAULT":
+			r.Default = strings.EqualFold(attr.Value, "YES")
+		case "AUTOSELECT":
+			r.AutoSelect = strings.EqualFold(attr.Value, "YES")
+		case "FORCED":
+			r.Forced = strings.EqualFold(attr.Value, "YES")
+		case "INSTREAM-ID":
+			r.InstreamID = attr.Value
+		case "CHARACTERISTICS":
+			r.Characteristics = attr.Value
+		case "CHANNELS":
+			r.Channels = attr.Value
+		default:
+			r.Tags = append(r.Tags, Tag{Name: attr.Key, Value: attr.Value})
+			r.quoted = markQuoted(r.quoted, attr)
+		}
+	}
+	return r
+}
+
+func (r *Rendition) MarshalM3U() ([]byte, error) {
+	var attrs attributeBuilder
+	attrs.plain("TYPE", r.Type)
+	attrs.quoted("GROUP-ID", r.GroupID)
+	attrs.quoted("NAME", r.Name)
+	attrs.quoted("LANGUAGE", r.Language)
+	attrs.quoted("ASSOC-LANGUAGE", r.AssocLanguage)
+	attrs.flag("DEFAULT", r.Default)
+	attrs.flag("AUTOSELECT", r.AutoSelect)
+	attrs.flag("FORCED", r.Forced)
+	attrs.quoted("INSTREAM-ID", r.InstreamID)
+	attrs.quoted("CHARACTERISTICS", r.Characteristics)
+	attrs.quoted("CHANNELS", r.Channels)
+	attrs.quoted("URI", r.URI)
+	attrs = append(attrs, tagAttributes(r.Tags, r.quoted)...)
+	return []byte("#EXT-X-MEDIA:" + formatAttributes(attrs) + "\n"), nil
+}
+
+// IFrameStream represents an EXT-X-I-FRAME-STREAM-INF tag, the playlist of I-frames is given by its URI attribute
+type IFrameStream struct {
+	URI              string
+	Bandwidth        int
+	AverageBandwidth int
+	Codecs           string
+	Resolution       string
+	HdcpLevel        string
+	Video            string
+
+	Tags   []Tag
+	quoted map[string]bool // Quoting of the unrecognised attributes in Tags as parsed
+}
+
+func parseIFrameStream(line string) (*IFrameStream, error) {
+	s := &IFrameStream{Tags: make([]Tag, 0)}
+	for _, attr := range parseAttributes(line) {
+		switch attr.Key {
+		case "URI":
+			s.URI = attr.Value
+		case "BANDWIDTH":
+			bandwidth, err := strconv.Atoi(attr.Value)
+			if err != nil {
+				return nil, errors.New("unable to parse bandwidth")
+			}
+			s.Bandwidth = bandwidth
+		case "AVERAGE-BANDWIDTH":
+			avgBandwidth, err := strconv.Atoi(attr.Value)
+			if err != nil {
+				return nil, errors.New("unable to parse average bandwidth")
+			}
+			s.AverageBandwidth = avgBandwidth
+		case "CODECS":
+			s.Codecs = attr.Value
+		case "RESOLUTION":
+			s.Resolution = attr.Value
+		case "HDCP-LEVEL":
+			s.HdcpLevel = attr.Value
+		case "VIDEO":
+			s.Video = attr.Value
+		default:
+			s.Tags = append(s.Tags, Tag{Name: attr.Key, Value: attr.Value})
+			s.quoted = markQuoted(s.quoted, attr)
+		}
+	}
+	return s, nil
+}
+
+func (s *IFrameStream) MarshalM3U() ([]byte, error) {
+	if s.URI == "" {
+		return nil, errors.New("EXT-X-I-FRAME-STREAM-INF requires a URI")
+	}
+
+	var attrs attributeBuilder
+	if s.Bandwidth > 0 {
+		attrs.plain("BANDWIDTH", strconv.Itoa(s.Bandwidth))
+	}
+	if s.AverageBandwidth > 0 {
+		attrs.plain("AVERAGE-BANDWIDTH", strconv.Itoa(s.AverageBandwidth))
+	}
+	attrs.quoted("CODECS", s.Codecs)
+	attrs.plain("RESOLUTION", s.Resolution)
+	attrs.plain("HDCP-LEVEL", s.HdcpLevel)
+	attrs.quoted("VIDEO", s.Video)
+	attrs.quoted("URI", s.URI)
+	attrs = append(attrs, tagAttributes(s.Tags, s.quoted)...)
+	return []byte("#EXT-X-I-FRAME-STREAM-INF:" + formatAttributes(attrs) + "\n"), nil
+}
+
+func parseProgramDateTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range programDateTimeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
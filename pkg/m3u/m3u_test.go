@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestUnmarshalPlaylist(t *testing.T) {
@@ -119,3 +122,324 @@ http://example.com/test.ts
 		t.Errorf("Marshalled content doesn't match expected.\nExpected:\n%s\nGot:\n%s", expected, string(data))
 	}
 }
+
+func TestTrackTags(t *testing.T) {
+	track := Track{Tags: []Tag{{Name: "GROUP-TITLE", Value: "News"}}}
+
+	if got := track.GetTag("group-title"); got != "News" {
+		t.Errorf("Expected tag value 'News', got %s", got)
+	}
+
+	track.SetTag("group-title", "Sports")
+	track.SetTag("tvg-id", "ch1")
+
+	if len(track.Tags) != 2 {
+		t.Fatalf("Expected 2 tags, got %d", len(track.Tags))
+	}
+	if track.Tags[0].Value != "Sports" || track.GetTag("TVG-ID") != "ch1" {
+		t.Errorf("Unexpected tags: %+v", track.Tags)
+	}
+}
+
+func TestMarshalPlaylistTags(t *testing.T) {
+	playlist := NewPlaylist()
+	playlist.SetTag("x-tvg-url", "http://example.com/epg.xml")
+	playlist.SetTag("catchup", "append")
+	playlist.SetTag("X-TVG-URL", "http://example.com/epg2.xml")
+
+	data, err := Marshal(&playlist)
+	if err != nil {
+		t.Fatalf("Failed to marshal playlist: %v", err)
+	}
+
+	expected := "#EXTM3U x-tvg-url=\"http://example.com/epg2.xml\" catchup=\"append\"\n"
+	if string(data) != expected {
+		t.Errorf("Marshalled content doesn't match expected.\nExpected:\n%s\nGot:\n%s", expected, string(data))
+	}
+}
+
+func TestVLCOpts(t *testing.T) {
+	data := "#EXTM3U\n" +
+		"#EXTINF:-1 tvg-id=\"cctv1\",CCTV1\n" +
+		"#EXTVLCOPT:http-user-agent=Mozilla/5.0\n" +
+		"#EXTVLCOPT:http-referrer=http://example.com/\n" +
+		"http://example.com/cctv1.m3u8\n" +
+		"#EXTVLCOPT:http-user-agent=ignored\n" +
+		"#EXTINF:-1,CCTV2\n" +
+		"http://example.com/cctv2.m3u8\n"
+
+	var playlist Playlist
+	if err := Unmarshal([]byte(data), &playlist); err != nil {
+		t.Fatalf("Failed to unmarshal playlist: %v", err)
+	}
+
+	if len(playlist.Tracks[0].VLCOpts) != 2 || len(playlist.Tracks[1].VLCOpts) != 0 {
+		t.Fatalf("Unexpected vlc opts: %+v", playlist.Tracks)
+	}
+	if got := playlist.Tracks[0].GetVLCOpt("HTTP-USER-AGENT"); got != "Mozilla/5.0" {
+		t.Errorf("Expected user agent 'Mozilla/5.0', got %s", got)
+	}
+
+	out, err := playlist.Tracks[0].MarshalM3U()
+	if err != nil {
+		t.Fatalf("Failed to marshal track: %v", err)
+	}
+	expected := "#EXTINF:-1.000000 TVG-ID=\"cctv1\", CCTV1\n" +
+		"#EXTVLCOPT:http-user-agent=Mozilla/5.0\n" +
+		"#EXTVLCOPT:http-referrer=http://example.com/\n" +
+		"http://example.com/cctv1.m3u8\n"
+	if string(out) != expected {
+		t.Errorf("Marshalled content doesn't match expected.\nExpected:\n%s\nGot:\n%s", expected, string(out))
+	}
+}
+
+func TestHLSMasterPlaylist(t *testing.T) {
+	data := "#EXTM3U\n" +
+		"#EXT-X-VERSION:6\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",VALUE=\"Live\"\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",LANGUAGE=\"zh\",NAME=\"中文\",DEFAULT=YES,AUTOSELECT=YES,CHANNELS=\"2\",URI=\"audio/zh.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2500000,AVERAGE-BANDWIDTH=2000000,CODECS=\"avc1.4d401f,mp4a.40.2\",RESOLUTION=1280x720,FRAME-RATE=25,AUDIO=\"aac\",CLOSED-CAPTIONS=NONE,PROGRAM-ID=1\n" +
+		"720p/index.m3u8\n" +
+		"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=300000,CODECS=\"avc1.4d401f\",RESOLUTION=1280x720,URI=\"720p/iframe.m3u8\"\n"
+
+	var playlist Playlist
+	if err := Unmarshal([]byte(data), &playlist); err != nil {
+		t.Fatalf("Failed to unmarshal playlist: %v", err)
+	}
+
+	if !playlist.IsMaster() {
+		t.Fatal("Expected a master playlist")
+	}
+	stream := playlist.VariantStreams[0]
+	if stream.Codecs != "avc1.4d401f,mp4a.40.2" || stream.URI != "720p/index.m3u8" || stream.Audio != "aac" {
+		t.Errorf("Unexpected variant stream: %+v", stream)
+	}
+	if len(stream.Tags) != 1 || stream.Tags[0].Name != "PROGRAM-ID" || stream.Tags[0].Value != "1" {
+		t.Errorf("Unexpected variant stream tags: %+v", stream.Tags)
+	}
+	rendition := playlist.Renditions[0]
+	if rendition.Type != "AUDIO" || rendition.GroupID != "aac" || !rendition.Default || rendition.Forced {
+		t.Errorf("Unexpected rendition: %+v", rendition)
+	}
+	if playlist.IFrameStreams[0].URI != "720p/iframe.m3u8" || playlist.IFrameStreams[0].Bandwidth != 300000 {
+		t.Errorf("Unexpected I-frame stream: %+v", playlist.IFrameStreams[0])
+	}
+
+	out, err := Marshal(&playlist)
+	if err != nil {
+		t.Fatalf("Failed to marshal playlist: %v", err)
+	}
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:6\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",VALUE=\"Live\"\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"中文\",LANGUAGE=\"zh\",DEFAULT=YES,AUTOSELECT=YES,CHANNELS=\"2\",URI=\"audio/zh.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2500000,AVERAGE-BANDWIDTH=2000000,CODECS=\"avc1.4d401f,mp4a.40.2\",RESOLUTION=1280x720,FRAME-RATE=25.000,AUDIO=\"aac\",CLOSED-CAPTIONS=NONE,PROGRAM-ID=1\n" +
+		"720p/index.m3u8\n" +
+		"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=300000,CODECS=\"avc1.4d401f\",RESOLUTION=1280x720,URI=\"720p/iframe.m3u8\"\n"
+	if string(out) != expected {
+		t.Errorf("Marshalled content doesn't match expected.\nExpected:\n%s\nGot:\n%s", expected, string(out))
+	}
+}
+
+func TestHLSMediaPlaylist(t *testing.T) {
+	data := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-TARGETDURATION:6\n" +
+		"#EXT-X-MEDIA-SEQUENCE:1024\n" +
+		"#EXT-X-DISCONTINUITY-SEQUENCE:2\n" +
+		"#EXT-X-PLAYLIST-TYPE:EVENT\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys.example.com/1.key\",IV=0x0000000000000000000000000000000A\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2024-10-01T12:00:00.000+08:00\n" +
+		"#EXTINF:6.006,\n" +
+		"seg1024.m4s\n" +
+		"#EXT-X-DISCONTINUITY\n" +
+		"#EXTINF:5.005,\n" +
+		"#EXT-X-BYTERANGE:1000@0\n" +
+		"seg1025.m4s\n" +
+		"#EXT-X-ENDLIST\n"
+
+	var playlist Playlist
+	if err := Unmarshal([]byte(data), &playlist); err != nil {
+		t.Fatalf("Failed to unmarshal playlist: %v", err)
+	}
+
+	if playlist.IsMaster() {
+		t.Fatal("Expected a media playlist")
+	}
+	if playlist.TargetDuration != 6 || playlist.MediaSequence != 1024 || playlist.DiscontinuitySequence != 2 ||
+		playlist.PlaylistType != "EVENT" || !playlist.EndList {
+		t.Errorf("Unexpected playlist tags: %+v", playlist)
+	}
+	if len(playlist.Tracks) != 2 {
+		t.Fatalf("Expected 2 segments, got %d", len(playlist.Tracks))
+	}
+
+	first, second := playlist.Tracks[0], playlist.Tracks[1]
+	if first.Map == nil || first.Map.URI != "init.mp4" || len(first.Keys) != 1 || first.Keys[0].Method != "AES-128" {
+		t.Errorf("Unexpected first segment: %+v", first)
+	}
+	if first.ProgramDateTime.UTC() != time.Date(2024, 10, 1, 4, 0, 0, 0, time.UTC) {
+		t.Errorf("Unexpected program date time: %v", first.ProgramDateTime)
+	}
+	if first.Discontinuity || !second.Discontinuity || second.Map != nil || len(second.Keys) != 0 {
+		t.Errorf("Unexpected segment tags: %+v %+v", first, second)
+	}
+	if len(second.Extensions) != 1 || second.Extensions[0] != "#EXT-X-BYTERANGE:1000@0" || second.URI != "seg1025.m4s" {
+		t.Errorf("Unexpected second segment: %+v", second)
+	}
+
+	out, err := Marshal(&playlist)
+	if err != nil {
+		t.Fatalf("Failed to marshal playlist: %v", err)
+	}
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-TARGETDURATION:6\n" +
+		"#EXT-X-MEDIA-SEQUENCE:1024\n" +
+		"#EXT-X-DISCONTINUITY-SEQUENCE:2\n" +
+		"#EXT-X-PLAYLIST-TYPE:EVENT\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys.example.com/1.key\",IV=0x0000000000000000000000000000000A\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2024-10-01T12:00:00.000+08:00\n" +
+		"#EXTINF:6.006000,\n" +
+		"seg1024.m4s\n" +
+		"#EXT-X-DISCONTINUITY\n" +
+		"#EXTINF:5.005000,\n" +
+		"#EXT-X-BYTERANGE:1000@0\n" +
+		"seg1025.m4s\n" +
+		"#EXT-X-ENDLIST\n"
+	if string(out) != expected {
+		t.Errorf("Marshalled content doesn't match expected.\nExpected:\n%s\nGot:\n%s", expected, string(out))
+	}
+
+	// 重新解析后内容保持一致
+	var again Playlist
+	if err := Unmarshal(out, &again); err != nil {
+		t.Fatalf("Failed to unmarshal marshalled playlist: %v", err)
+	}
+	if !reflect.DeepEqual(playlist, again) {
+		t.Errorf("Round trip changed the playlist.\nBefore: %+v\nAfter: %+v", playlist, again)
+	}
+}
+
+func TestTrailingExtensions(t *testing.T) {
+	data := "#EXTM3U\n" +
+		"#EXT-X-TARGETDURATION:4\n" +
+		"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.0\n" +
+		"#EXT-X-PART-INF:PART-TARGET=0.33334\n" +
+		"#EXTINF:4.000,\n" +
+		"seg1.m4s\n" +
+		"#EXT-X-PART:DURATION=0.33334,URI=\"seg2.0.m4s\",INDEPENDENT=YES\n" +
+		"#EXT-X-PART:DURATION=0.33334,URI=\"seg2.1.m4s\"\n" +
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"seg2.2.m4s\"\n" +
+		"#EXT-X-RENDITION-REPORT:URI=\"../low/index.m3u8\",LAST-MSN=2,LAST-PART=1\n"
+
+	var playlist Playlist
+	if err := Unmarshal([]byte(data), &playlist); err != nil {
+		t.Fatalf("Failed to unmarshal playlist: %v", err)
+	}
+
+	if len(playlist.Tracks) != 1 || len(playlist.Tracks[0].Extensions) != 0 {
+		t.Fatalf("Unexpected tracks: %+v", playlist.Tracks)
+	}
+	if len(playlist.Extensions) != 2 {
+		t.Errorf("Expected 2 playlist extensions, got %v", playlist.Extensions)
+	}
+	expectedTrailing := []string{
+		"#EXT-X-PART:DURATION=0.33334,URI=\"seg2.0.m4s\",INDEPENDENT=YES",
+		"#EXT-X-PART:DURATION=0.33334,URI=\"seg2.1.m4s\"",
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"seg2.2.m4s\"",
+		"#EXT-X-RENDITION-REPORT:URI=\"../low/index.m3u8\",LAST-MSN=2,LAST-PART=1",
+	}
+	if !reflect.DeepEqual(playlist.TrailingExtensions, expectedTrailing) {
+		t.Errorf("Unexpected trailing extensions: %v", playlist.TrailingExtensions)
+	}
+
+	// Trailing tags are written after the tracks and before EXT-X-ENDLIST
+	playlist.EndList = true
+	out, err := Marshal(&playlist)
+	if err != nil {
+		t.Fatalf("Failed to marshal playlist: %v", err)
+	}
+	expected := "#EXTM3U\n" +
+		"#EXT-X-TARGETDURATION:4\n" +
+		"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.0\n" +
+		"#EXT-X-PART-INF:PART-TARGET=0.33334\n" +
+		"#EXTINF:4.000000,\n" +
+		"seg1.m4s\n" +
+		strings.Join(expectedTrailing, "\n") + "\n" +
+		"#EXT-X-ENDLIST\n"
+	if string(out) != expected {
+		t.Errorf("Marshalled content doesn't match expected.\nExpected:\n%s\nGot:\n%s", expected, string(out))
+	}
+
+	var again Playlist
+	if err := Unmarshal(out, &again); err != nil {
+		t.Fatalf("Failed to unmarshal marshalled playlist: %v", err)
+	}
+	if !reflect.DeepEqual(playlist, again) {
+		t.Errorf("Round trip changed the playlist.\nBefore: %+v\nAfter: %+v", playlist, again)
+	}
+
+	// Segment tags without a following segment are kept as well
+	var pending Playlist
+	if err := Unmarshal([]byte("#EXTM3U\n#EXTINF:4,\nseg1.ts\n#EXT-X-DISCONTINUITY\n"), &pending); err != nil {
+		t.Fatalf("Failed to unmarshal playlist: %v", err)
+	}
+	if !reflect.DeepEqual(pending.TrailingExtensions, []string{"#EXT-X-DISCONTINUITY"}) {
+		t.Errorf("Unexpected trailing extensions: %v", pending.TrailingExtensions)
+	}
+}
+
+func TestContentSteeringAttributes(t *testing.T) {
+	// Unrecognised attributes keep their quoting, even for values that look like enumerated strings
+	data := "#EXTM3U\n" +
+		"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\",URI=\"audio/en.m3u8\",STABLE-RENDITION-ID=\"A1\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2500000,AUDIO=\"aac\",PATHWAY-ID=\"CDN-A\",STABLE-VARIANT-ID=\"HI\",SCORE=2.5\n" +
+		"720p/index.m3u8\n" +
+		"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=300000,URI=\"720p/iframe.m3u8\",PATHWAY-ID=\"CDN-A\",STABLE-VARIANT-ID=\"HI\"\n"
+
+	var playlist Playlist
+	if err := Unmarshal([]byte(data), &playlist); err != nil {
+		t.Fatalf("Failed to unmarshal playlist: %v", err)
+	}
+	out, err := Marshal(&playlist)
+	if err != nil {
+		t.Fatalf("Failed to marshal playlist: %v", err)
+	}
+	if string(out) != data {
+		t.Errorf("Marshalled content doesn't match expected.\nExpected:\n%s\nGot:\n%s", data, string(out))
+	}
+
+	// Tags added by callers are quoted unless they look like enumerated strings
+	stream := playlist.VariantStreams[0]
+	stream.Tags = append(stream.Tags, Tag{Name: "X-PROGRAM", Value: "news"}, Tag{Name: "X-LEVEL", Value: "L1"})
+	out, err = stream.MarshalM3U()
+	if err != nil {
+		t.Fatalf("Failed to marshal variant stream: %v", err)
+	}
+	if !strings.Contains(string(out), `SCORE=2.5,X-PROGRAM="news",X-LEVEL=L1`) {
+		t.Errorf("Unexpected variant stream: %s", out)
+	}
+}
+
+func TestVariantStreamMarshal(t *testing.T) {
+	stream := VariantStream{Bandwidth: 800000, Resolution: "640x360", Codecs: "avc1.42e01e,mp4a.40.2", URI: "360p.m3u8"}
+
+	out, err := stream.MarshalM3U()
+	if err != nil {
+		t.Fatalf("Failed to marshal variant stream: %v", err)
+	}
+	expected := "#EXT-X-STREAM-INF:BANDWIDTH=800000,CODECS=\"avc1.42e01e,mp4a.40.2\",RESOLUTION=640x360\n360p.m3u8\n"
+	if string(out) != expected {
+		t.Errorf("Marshalled content doesn't match expected.\nExpected:\n%s\nGot:\n%s", expected, string(out))
+	}
+
+	stream.URI = ""
+	if _, err := stream.MarshalM3U(); err == nil {
+		t.Error("Expected an error for a variant stream without URI")
+	}
+}
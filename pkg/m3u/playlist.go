@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -18,12 +19,40 @@ func NewPlaylist() Playlist {
 	}
 }
 
-// Playlist is a type that represents an m3u playlist containing 0 or more tracks or streams
+// Playlist is a type that represents an m3u playlist containing 0 or more tracks or streams.
+// HLS master playlists list variant streams, renditions and I-frame streams, while HLS media
+// playlists and IPTV channel lists list tracks.
 type Playlist struct {
 	Tracks         []Track
 	VariantStreams []VariantStream
 	Version        int
 	Tags           []Tag
+
+	// HLS media playlist tags
+	TargetDuration        int
+	MediaSequence         int
+	DiscontinuitySequence int
+	PlaylistType          string // VOD or EVENT
+	EndList               bool
+
+	// HLS master playlist tags
+	Renditions    []Rendition
+	IFrameStreams []IFrameStream
+
+	IndependentSegments bool
+
+	// Extensions keeps unrecognised #EXT tags found before the first track, such as EXT-X-START
+	// or EXT-X-SESSION-DATA, they are written after the known playlist tags
+	Extensions []string
+	// TrailingExtensions keeps the tags found after the last segment, such as the EXT-X-PART,
+	// EXT-X-PRELOAD-HINT and EXT-X-RENDITION-REPORT tags of low-latency HLS, they are written
+	// after the tracks and before EXT-X-ENDLIST
+	TrailingExtensions []string
+}
+
+// IsMaster reports whether the playlist is an HLS master playlist
+func (p *Playlist) IsMaster() bool {
+	return len(p.VariantStreams) > 0 || len(p.IFrameStreams) > 0 || len(p.Renditions) > 0
 }
 
 // A Tag is a key/value pair
@@ -32,6 +61,28 @@ type Tag struct {
 	Value string
 }
 
+// GetTag returns the value of the header tag with the given name, the name is case-insensitive
+func (p *Playlist) GetTag(name string) string {
+	for _, tag := range p.Tags {
+		if strings.EqualFold(tag.Name, name) {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// SetTag sets the value of the header tag with the given name, the name is case-insensitive.
+// A new tag is appended if the playlist does not have it yet.
+func (p *Playlist) SetTag(name, value string) {
+	for i := range p.Tags {
+		if strings.EqualFold(p.Tags[i].Name, name) {
+			p.Tags[i].Value = value
+			return
+		}
+	}
+	p.Tags = append(p.Tags, Tag{Name: name, Value: value})
+}
+
 // UnmarshalM3U implements the Unmarshaler interface
 func (p *Playlist) UnmarshalM3U(data []byte) error {
 	scanner := bufio.NewScanner(bytes.NewReader(data))
@@ -47,57 +98,111 @@ func (p *Playlist) MarshalM3U() ([]byte, error) {
 }
 
 func (p *Playlist) marshalM3U(writer io.Writer) error {
-	writer.Write([]byte("#EXTM3U"))
+	buf := bytes.NewBufferString("#EXTM3U")
 
 	for i := range p.Tags {
 		tag := &p.Tags[i]
-		sep := " "
-		if i == len(p.Tags)-1 && i != 0 {
-			sep = ""
-		}
-		writer.Write([]byte(fmt.Sprintf("%s%s=%q", sep, tag.Name, tag.Value)))
+		buf.WriteString(fmt.Sprintf(" %s=%q", tag.Name, tag.Value))
 	}
 
-	writer.Write([]byte("\n"))
+	buf.WriteString("\n")
 
-	for _, track := range p.Tracks {
-		trackBytes, err := track.MarshalM3U()
-		if err != nil {
-			return err
-		}
-		writer.Write(trackBytes)
+	if p.Version > 0 {
+		buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", p.Version))
+	}
+	if p.IndependentSegments {
+		buf.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+	if p.TargetDuration > 0 {
+		buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", p.TargetDuration))
+	}
+	if p.MediaSequence > 0 {
+		buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", p.MediaSequence))
+	}
+	if p.DiscontinuitySequence > 0 {
+		buf.WriteString(fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.DiscontinuitySequence))
+	}
+	if p.PlaylistType != "" {
+		buf.WriteString("#EXT-X-PLAYLIST-TYPE:" + p.PlaylistType + "\n")
+	}
+	for _, ext := range p.Extensions {
+		buf.WriteString(ext + "\n")
 	}
 
-	for _, stream := range p.VariantStreams {
-		streamBytes, err := stream.MarshalM3U()
+	marshalers := make([]Marshaler, 0, len(p.Renditions)+len(p.VariantStreams)+len(p.IFrameStreams)+len(p.Tracks))
+	for i := range p.Renditions {
+		marshalers = append(marshalers, &p.Renditions[i])
+	}
+	for i := range p.VariantStreams {
+		marshalers = append(marshalers, &p.VariantStreams[i])
+	}
+	for i := range p.IFrameStreams {
+		marshalers = append(marshalers, &p.IFrameStreams[i])
+	}
+	for i := range p.Tracks {
+		marshalers = append(marshalers, &p.Tracks[i])
+	}
+	for _, m := range marshalers {
+		data, err := m.MarshalM3U()
 		if err != nil {
 			return err
 		}
-		writer.Write(streamBytes)
+		buf.Write(data)
 	}
 
-	return nil
+	for _, ext := range p.TrailingExtensions {
+		buf.WriteString(ext + "\n")
+	}
+	if p.EndList {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	_, err := writer.Write(buf.Bytes())
+	return err
+}
+
+// uriOwner is the entry that the next URI line belongs to
+type uriOwner int
+
+const (
+	uriOwnerNone uriOwner = iota
+	uriOwnerTrack
+	uriOwnerVariant
+)
+
+// playlistParser keeps the state needed while reading a playlist line by line
+type playlistParser struct {
+	p     *Playlist
+	owner uriOwner
+	// pending collects media segment tags that precede the next EXTINF line
+	pending Track
 }
 
 func (p *Playlist) unmarshalScanner(scanner *bufio.Scanner) error {
+	parser := &playlistParser{p: p}
 	onFirstLine := true
 
 	for scanner.Scan() {
-		line := scanner.Text()
+		line := strings.TrimSpace(scanner.Text())
 		if onFirstLine && !strings.HasPrefix(line, "#EXTM3U") {
 			return errors.New("invalid m3u file format. Expected #EXTM3U file header")
 		}
 		onFirstLine = false
 
-		if err := p.parseLine(line); err != nil {
+		if err := parser.parseLine(line); err != nil {
 			return err
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
 
-	return scanner.Err()
+	parser.flushPending()
+	return nil
 }
 
-func (p *Playlist) parseLine(line string) error {
+func (ps *playlistParser) parseLine(line string) error {
+	p := ps.p
 	switch {
 	case strings.HasPrefix(line, "#EXTM3U"):
 		p.Tags = p.parseTag(line[7:])
@@ -106,41 +211,149 @@ func (p *Playlist) parseLine(line string) error {
 		if err != nil {
 			return err
 		}
+		ps.applyPending(track)
 		p.Tracks = append(p.Tracks, *track)
+		ps.owner = uriOwnerTrack
 	case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
 		stream, err := parseVariantStream(line[18:])
 		if err != nil {
 			return err
 		}
 		p.VariantStreams = append(p.VariantStreams, *stream)
-	case strings.HasPrefix(line, "#EXT-X-VERSION:"):
-		version, err := strconv.Atoi(strings.TrimSpace(line[15:]))
+		ps.owner = uriOwnerVariant
+	case strings.HasPrefix(line, "#EXT-X-I-FRAME-STREAM-INF:"):
+		stream, err := parseIFrameStream(line[26:])
 		if err != nil {
 			return err
 		}
-		p.Version = version
+		p.IFrameStreams = append(p.IFrameStreams, *stream)
+	case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+		p.Renditions = append(p.Renditions, parseRendition(line[13:]))
+	case strings.HasPrefix(line, "#EXT-X-VERSION:"):
+		return parseIntTag(line[15:], &p.Version)
+	case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+		duration, err := strconv.ParseFloat(strings.TrimSpace(line[22:]), 64)
+		if err != nil {
+			return errors.New("unable to parse target duration")
+		}
+		p.TargetDuration = int(math.Ceil(duration))
+	case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+		return parseIntTag(line[22:], &p.MediaSequence)
+	case strings.HasPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"):
+		return parseIntTag(line[30:], &p.DiscontinuitySequence)
+	case strings.HasPrefix(line, "#EXT-X-PLAYLIST-TYPE:"):
+		p.PlaylistType = strings.TrimSpace(line[21:])
+	case line == "#EXT-X-INDEPENDENT-SEGMENTS":
+		p.IndependentSegments = true
+	case line == "#EXT-X-ENDLIST":
+		p.EndList = true
+	case line == "#EXT-X-DISCONTINUITY":
+		ps.segment().Discontinuity = true
+	case strings.HasPrefix(line, "#EXT-X-KEY:"):
+		segment := ps.segment()
+		segment.Keys = append(segment.Keys, parseKey(line[11:]))
+	case strings.HasPrefix(line, "#EXT-X-MAP:"):
+		m := parseMap(line[11:])
+		ps.segment().Map = &m
+	case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+		t, err := parseProgramDateTime(line[25:])
+		if err != nil {
+			ps.addExtension(line)
+			return nil
+		}
+		ps.segment().ProgramDateTime = t
+	case strings.HasPrefix(line, "#EXTVLCOPT:"):
+		p.handleVLCOpt(line[11:])
+	case strings.HasPrefix(line, "#EXT"):
+		ps.addExtension(line)
 	case strings.HasPrefix(line, "#") || line == "":
 		return nil
 	default:
-		return p.handleURI(line)
+		return ps.handleURI(line)
 	}
 	return nil
 }
 
-func (p *Playlist) handleURI(line string) error {
-	if len(p.Tracks) == 0 && len(p.VariantStreams) == 0 {
-		return errors.New("URI provided for playlist with no tracks or streams")
+// segment returns the track that media segment tags apply to: the current track while its URI
+// has not been read yet, otherwise the next track
+func (ps *playlistParser) segment() *Track {
+	if ps.owner == uriOwnerTrack {
+		return &ps.p.Tracks[len(ps.p.Tracks)-1]
+	}
+	return &ps.pending
+}
+
+// addExtension keeps an unrecognised tag on the playlist before the first track, otherwise on the track it precedes
+func (ps *playlistParser) addExtension(line string) {
+	if len(ps.p.Tracks) == 0 && ps.owner != uriOwnerTrack {
+		ps.p.Extensions = append(ps.p.Extensions, line)
+		return
+	}
+	segment := ps.segment()
+	segment.Extensions = append(segment.Extensions, line)
+}
+
+func (ps *playlistParser) applyPending(track *Track) {
+	track.Discontinuity = ps.pending.Discontinuity
+	track.Keys = ps.pending.Keys
+	track.Map = ps.pending.Map
+	track.ProgramDateTime = ps.pending.ProgramDateTime
+	track.Extensions = ps.pending.Extensions
+	ps.pending = Track{}
+}
+
+// flushPending keeps the segment tags that are not followed by another track as trailing extensions
+func (ps *playlistParser) flushPending() {
+	buf := new(bytes.Buffer)
+	ps.pending.marshalSegmentTags(buf)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line != "" {
+			ps.p.TrailingExtensions = append(ps.p.TrailingExtensions, line)
+		}
 	}
+	ps.p.TrailingExtensions = append(ps.p.TrailingExtensions, ps.pending.Extensions...)
+	ps.pending = Track{}
+}
 
+func (ps *playlistParser) handleURI(line string) error {
+	p := ps.p
 	uri := strings.Trim(line, " ")
-	if len(p.VariantStreams) > 0 {
+	switch {
+	case ps.owner == uriOwnerVariant:
+		p.VariantStreams[len(p.VariantStreams)-1].URI = uri
+	case ps.owner == uriOwnerTrack:
+		p.Tracks[len(p.Tracks)-1].URI = uri
+	// A repeated URI line replaces the URI of the last entry
+	case len(p.VariantStreams) > 0:
 		p.VariantStreams[len(p.VariantStreams)-1].URI = uri
-	} else {
+	case len(p.Tracks) > 0:
 		p.Tracks[len(p.Tracks)-1].URI = uri
+	default:
+		return errors.New("URI provided for playlist with no tracks or streams")
+	}
+	ps.owner = uriOwnerNone
+	return nil
+}
+
+func parseIntTag(value string, dst *int) error {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return err
 	}
+	*dst = n
 	return nil
 }
 
+// handleVLCOpt attaches a #EXTVLCOPT option to the track whose URI has not been read yet
+func (p *Playlist) handleVLCOpt(option string) {
+	if len(p.Tracks) == 0 || p.Tracks[len(p.Tracks)-1].URI != "" {
+		return
+	}
+	name, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+	track := &p.Tracks[len(p.Tracks)-1]
+	track.VLCOpts = append(track.VLCOpts, Tag{Name: name, Value: value})
+}
+
 func (p *Playlist) parseTag(line string) []Tag {
 	tags := make([]Tag, 0)
 	for k, v := range DecodeAttributeList(line) {
@@ -6,14 +6,59 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// Track represents an m3u track with a Name, Lengh, URI and a set of tags
+// Track represents an m3u track with a Name, Lengh, URI and a set of tags.
+// In an HLS media playlist each track is a media segment.
 type Track struct {
 	Name     string
 	Duration float64
 	URI      string
 	Tags     []Tag
+	VLCOpts  []Tag // #EXTVLCOPT options between the EXTINF line and the URI, e.g. http-user-agent
+
+	// HLS media segment tags, written before the EXTINF line
+	Discontinuity   bool
+	Keys            []Key // EXT-X-KEY tags preceding this segment, they also apply to the following segments
+	Map             *Map  // EXT-X-MAP preceding this segment, it also applies to the following segments
+	ProgramDateTime time.Time
+
+	// Extensions keeps unrecognised #EXT tags of this track such as EXT-X-BYTERANGE or #EXTGRP,
+	// they are written between the EXTINF line and the URI
+	Extensions []string
+}
+
+// GetVLCOpt returns the value of the #EXTVLCOPT option with the given name, the name is case-insensitive
+func (t *Track) GetVLCOpt(name string) string {
+	for _, opt := range t.VLCOpts {
+		if strings.EqualFold(opt.Name, name) {
+			return opt.Value
+		}
+	}
+	return ""
+}
+
+// GetTag returns the value of the tag with the given name, the name is case-insensitive
+func (t *Track) GetTag(name string) string {
+	for _, tag := range t.Tags {
+		if strings.EqualFold(tag.Name, name) {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// SetTag sets the value of the tag with the given name, the name is case-insensitive.
+// A new tag is appended if the track does not have it yet.
+func (t *Track) SetTag(name, value string) {
+	for i := range t.Tags {
+		if strings.EqualFold(t.Tags[i].Name, name) {
+			t.Tags[i].Value = value
+			return
+		}
+	}
+	t.Tags = append(t.Tags, Tag{Name: name, Value: value})
 }
 
 func (t *Track) UnmarshalM3U(data []byte) error {
@@ -28,26 +73,51 @@ func (t *Track) UnmarshalM3U(data []byte) error {
 func (track *Track) MarshalM3U() ([]byte, error) {
 	buf := new(bytes.Buffer)
 
+	track.marshalSegmentTags(buf)
+
 	buf.WriteString("#EXTINF:")
-	buf.WriteString(fmt.Sprintf("%f ", track.Duration))
+	buf.WriteString(fmt.Sprintf("%f", track.Duration))
 	for i := range track.Tags {
 		tag := &track.Tags[i]
-		sep := " "
-		if i == len(track.Tags)-1 {
-			sep = ""
-		}
-		buf.WriteString(fmt.Sprintf("%s=%q%s", tag.Name, tag.Value, sep))
+		buf.WriteString(fmt.Sprintf(" %s=%q", tag.Name, tag.Value))
+	}
+	buf.WriteString(",")
+	if track.Name != "" {
+		buf.WriteString(" " + track.Name)
 	}
-	buf.WriteString(", ")
 
-	buf.WriteString(track.Name)
 	buf.WriteRune('\n')
+	for _, opt := range track.VLCOpts {
+		buf.WriteString(fmt.Sprintf("#EXTVLCOPT:%s=%s\n", opt.Name, opt.Value))
+	}
+	for _, ext := range track.Extensions {
+		buf.WriteString(ext)
+		buf.WriteRune('\n')
+	}
 	buf.WriteString(track.URI)
 	buf.WriteRune('\n')
 
 	return buf.Bytes(), nil
 }
 
+// marshalSegmentTags writes the HLS media segment tags that precede the EXTINF line
+func (track *Track) marshalSegmentTags(buf *bytes.Buffer) {
+	if track.Discontinuity {
+		buf.WriteString("#EXT-X-DISCONTINUITY\n")
+	}
+	for i := range track.Keys {
+		key, _ := track.Keys[i].MarshalM3U()
+		buf.Write(key)
+	}
+	if track.Map != nil {
+		m, _ := track.Map.MarshalM3U()
+		buf.Write(m)
+	}
+	if !track.ProgramDateTime.IsZero() {
+		buf.WriteString("#EXT-X-PROGRAM-DATE-TIME:" + track.ProgramDateTime.Format(programDateTimeLayout) + "\n")
+	}
+}
+
 func parseTrack(line string) (*Track, error) {
 	trackInfo := strings.Split(line, ",")
 	if len(trackInfo) < 2 {
@@ -2,9 +2,7 @@ package m3u
 
 import (
 	"errors"
-	"fmt"
 	"strconv"
-	"strings"
 )
 
 // VariantStream represents an m3u variant stream with a set of attributes
@@ -22,7 +20,8 @@ type VariantStream struct {
 	Subtitle        string
 	ClosedCaptions  string
 
-	Tags []Tag
+	Tags   []Tag
+	quoted map[string]bool // Quoting of the unrecognised attributes in Tags as parsed
 }
 
 func (s *VariantStream) UnmarshalM3U(data []byte) error {
@@ -35,66 +34,44 @@ func (s *VariantStream) UnmarshalM3U(data []byte) error {
 }
 
 func (s *VariantStream) MarshalM3U() ([]byte, error) {
-	args := make([]string, 0)
-
-	if s.Resolution != "" {
-		args = append(args, fmt.Sprintf("RESOLUTION=%s", s.Resolution))
+	if s.URI == "" {
+		return nil, errors.New("EXT-X-STREAM-INF must be followed by a URI")
 	}
+
+	var attrs attributeBuilder
 	if s.Bandwidth > 0 {
-		args = append(args, fmt.Sprintf("BANDWIDTH=%d", s.Bandwidth))
+		attrs.plain("BANDWIDTH", strconv.Itoa(s.Bandwidth))
 	}
 	if s.AverageBandwith > 0 {
-		args = append(args, fmt.Sprintf("AVERAGE-BANDWIDTH=%d", s.AverageBandwith))
+		attrs.plain("AVERAGE-BANDWIDTH", strconv.Itoa(s.AverageBandwith))
 	}
+	attrs.quoted("CODECS", s.Codecs)
+	attrs.plain("RESOLUTION", s.Resolution)
 	if s.FrameRate > 0 {
-		args = append(args, fmt.Sprintf("FRAME-RATE=%f", s.FrameRate))
-	}
-	if s.Codecs != "" {
-		args = append(args, fmt.Sprintf("CODECS=%s", s.Codecs))
-	}
-	if s.HdcpLevel != "" {
-		args = append(args, fmt.Sprintf("HDCP-LEVEL=%s", s.HdcpLevel))
-	}
-	if s.Video != "" {
-		args = append(args, fmt.Sprintf("VIDEO=%s", s.Video))
-	}
-	if s.Audio != "" {
-		args = append(args, fmt.Sprintf("AUDIO=%s", s.Audio))
-	}
-	if s.Subtitle != "" {
-		args = append(args, fmt.Sprintf("SUBTITLES=%s", s.Subtitle))
-	}
-	if s.ClosedCaptions != "" {
-		args = append(args, fmt.Sprintf("CLOSED-CAPTIONS=%s", s.ClosedCaptions))
-	}
-	if s.Name != "" {
-		args = append(args, fmt.Sprintf("NAME=%s", s.Name))
-	}
-	if s.URI != "" {
-		args = append(args, fmt.Sprintf("URI=%s", s.URI))
-	}
+		attrs.plain("FRAME-RATE", strconv.FormatFloat(s.FrameRate, 'f', 3, 64))
+	}
+	attrs.plain("HDCP-LEVEL", s.HdcpLevel)
+	attrs.quoted("VIDEO", s.Video)
+	attrs.quoted("AUDIO", s.Audio)
+	attrs.quoted("SUBTITLES", s.Subtitle)
+	if s.ClosedCaptions == "NONE" {
+		attrs.plain("CLOSED-CAPTIONS", s.ClosedCaptions)
+	} else {
+		attrs.quoted("CLOSED-CAPTIONS", s.ClosedCaptions)
+	}
+	attrs.quoted("NAME", s.Name)
+	attrs = append(attrs, tagAttributes(s.Tags, s.quoted)...)
 
-	for i := range s.Tags {
-		tag := &s.Tags[i]
-		args = append(args, fmt.Sprintf("%s=%q", tag.Name, tag.Value))
-	}
-
-	return []byte("#EXT-X-STREAM-INF: " + strings.Join(args, ",")), nil
+	return []byte("#EXT-X-STREAM-INF:" + formatAttributes(attrs) + "\n" + s.URI + "\n"), nil
 }
 
 func parseVariantStream(line string) (*VariantStream, error) {
-	streamInfo := strings.Split(line, ",")
-	if len(streamInfo) < 1 {
-		return nil, errors.New("invalid m3u file format. Expected EXT-X-STREAM-INF metadata to contain stream data")
-	}
-
 	stream := &VariantStream{
 		Tags: make([]Tag, 0),
 	}
 
-	for _, param := range streamInfo {
-		param = strings.TrimSpace(param)
-		if err := stream.parseParameter(param); err != nil {
+	for _, attr := range parseAttributes(line) {
+		if err := stream.parseParameter(attr); err != nil {
 			return nil, err
 		}
 	}
@@ -102,14 +79,8 @@ func parseVariantStream(line string) (*VariantStream, error) {
 	return stream, nil
 }
 
-func (s *VariantStream) parseParameter(param string) error {
-	parts := strings.SplitN(param, "=", 2)
-	if len(parts) != 2 {
-		return nil // Skip malformed parameters
-	}
-
-	key, value := parts[0], parts[1]
-	key = strings.TrimSpace(strings.ToUpper(key))
+func (s *VariantStream) parseParameter(attr attribute) error {
+	key, value := attr.Key, attr.Value
 	switch key {
 	case "BANDWIDTH":
 		bandwidth, err := strconv.Atoi(value)
@@ -146,11 +117,8 @@ func (s *VariantStream) parseParameter(param string) error {
 	case "NAME":
 		s.Name = value
 	default:
-		tag := Tag{
-			Name:  key,
-			Value: value,
-		}
-		s.Tags = append(s.Tags, tag)
+		s.Tags = append(s.Tags, Tag{Name: key, Value: value})
+		s.quoted = markQuoted(s.quoted, attr)
 	}
 
 	return nil
@@ -0,0 +1,83 @@
+package mixer
+
+import (
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/epg"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+// normalizeTrack 将轨道名称替换为规范名称, 并将 tvg-name 与 tvg-id 设置为规范名称与 ID
+func (o *mixOptions) normalizeTrack(track *m3u.Track) {
+	if o.normalizer == nil {
+		return
+	}
+	name := track.Name
+	if name == "" {
+		name = track.GetTag("tvg-name")
+	}
+	if name == "" {
+		return
+	}
+
+	channel := o.normalizer.Normalize(name)
+	track.Name = channel.Name
+	track.SetTag("tvg-name", channel.Name)
+	track.SetTag("tvg-id", channel.ID)
+}
+
+// normalizeLive 将内嵌频道的名称替换为规范名称, 同一分组中规范名称相同的频道合并地址
+func (o *mixOptions) normalizeLive(live *config.TvBoxLive) {
+	if o.normalizer == nil || len(live.Channels) == 0 {
+		return
+	}
+
+	index := make(map[string]int, len(live.Channels))
+	channels := make([]config.TvBoxLiveChannel, 0, len(live.Channels))
+	for _, channel := range live.Channels {
+		channel.Name = o.normalizer.Normalize(channel.Name).Name
+		if i, ok := index[channel.Name]; ok {
+			channels[i].URLs = append(channels[i].URLs, channel.URLs...)
+			continue
+		}
+		index[channel.Name] = len(channels)
+		channels = append(channels, channel)
+	}
+	live.Channels = channels
+}
+
+// normalizeEPG 将同一源的频道 ID 与名称替换为规范 ID 与名称, 并同步修改节目所属的频道
+func (o *mixOptions) normalizeEPG(channels []epg.Channel, programmes []epg.Programme) {
+	if o.normalizer == nil {
+		return
+	}
+
+	ids := make(map[string]string, len(channels))
+	for i := range channels {
+		name := channels[i].DisplayName.Text
+		if name == "" {
+			name = channels[i].ID
+		}
+		channel := o.normalizer.Normalize(name)
+		ids[channels[i].ID] = channel.ID
+		channels[i].ID = channel.ID
+		channels[i].DisplayName.Text = channel.Name
+	}
+	for i := range programmes {
+		if id, ok := ids[programmes[i].Channel]; ok {
+			programmes[i].Channel = id
+		}
+	}
+}
+
+// FindEPGChannel 按频道 ID 或名称查找频道, 找不到时使用规范化后的名称再次查找
+func FindEPGChannel(e *epg.EPG, name string, opts ...MixOption) (epg.Channel, bool) {
+	if channel, ok := e.FindChannel(name); ok {
+		return channel, true
+	}
+	o := newMixOptions(opts)
+	if o.normalizer == nil {
+		return epg.Channel{}, false
+	}
+	channel := o.normalizer.Normalize(name)
+	return e.FindChannel(channel.ID)
+}
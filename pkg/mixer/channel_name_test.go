@@ -0,0 +1,117 @@
+package mixer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/chname"
+)
+
+func TestMixWithChannelNormalizer(t *testing.T) {
+	normalizer := chname.NewNormalizer([]byte("凤凰中文,凤凰卫视中文台\n"))
+
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"m3u_a": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1 group-title=\"央视\",CCTV-1 综合\nhttp://a/cctv1.m3u8\n" +
+					"#EXTINF:-1 group-title=\"央视\",CCTV5+ 体育赛事\nhttp://a/cctv5p.m3u8\n"),
+			},
+			"txt_b": {
+				config: config.Source{Type: config.SourceTypeLiveTXT},
+				data: []byte("央视,#genre#\ncctv-1 HD,http://b/cctv1.m3u8\n" +
+					"港澳,#genre#\n凤凰卫视中文台,http://b/phoenix.m3u8\n"),
+			},
+			"epg_a": {
+				config: config.Source{Type: config.SourceTypeEPG},
+				data: []byte(`<tv>
+    <channel id="1"><display-name>CCTV1高清</display-name></channel>
+    <channel id="2"><display-name>凤凰卫视中文台</display-name></channel>
+    <programme channel="1" start="20240101000000" stop="20240101010000"><title>新闻联播</title></programme>
+    <programme channel="2" start="20240101000000" stop="20240101010000"><title>时事直通车</title></programme>
+</tv>`),
+			},
+			"single": {
+				config: config.Source{Type: config.SourceTypeTvBoxSingle},
+				data: []byte(`{"lives":[{"group":"央视","channels":[` +
+					`{"name":"CCTV-1","urls":["http://c/1.m3u8"]},{"name":"CCTV1 HD","urls":["http://c/2.m3u8"]}]}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		M3UOpt: config.M3UOpt{
+			MediaPlaylistFilters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "m3u_a"}},
+				{MixOpt: config.MixOpt{SourceName: "txt_b"}, FilterBy: "name", Include: "^(CCTV1|凤凰中文)$"},
+			},
+			Dedup: config.M3UDedupOpt{Enable: true},
+		},
+		EPGOpt: config.EPGOpt{
+			Filters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "epg_a"}, FilterBy: string(config.EPGFilterTypeChannelID)},
+			},
+		},
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Lives: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "txt_b"}},
+				{MixOpt: config.MixOpt{SourceName: "single", Field: "lives"}},
+			},
+		},
+	}
+	cfg.Fixture()
+	cfg.EPGOpt.LiveEPG = false
+	cfg.EPGOpt.LiveLogo = false
+
+	t.Run("M3U", func(t *testing.T) {
+		result, err := MixM3UMediaPlayList(cfg, mockSourcer, WithChannelNormalizer(normalizer))
+		assert.NoError(t, err)
+		assert.Len(t, result.Tracks, 4)
+
+		assert.Equal(t, "CCTV1", result.Tracks[0].Name)
+		assert.Equal(t, "cctv1", result.Tracks[0].GetTag("tvg-id"))
+		assert.Equal(t, "CCTV1", result.Tracks[0].GetTag("tvg-name"))
+		// 不同写法的同一频道被识别为同一频道
+		assert.Equal(t, "http://b/cctv1.m3u8", result.Tracks[1].URI)
+		assert.Equal(t, "CCTV5+", result.Tracks[2].Name)
+		assert.Equal(t, "凤凰中文", result.Tracks[3].Name)
+		assert.Equal(t, "凤凰中文", result.Tracks[3].GetTag("tvg-id"))
+	})
+
+	t.Run("EPG", func(t *testing.T) {
+		result, err := MixEPG(cfg, mockSourcer, WithChannelNormalizer(normalizer))
+		assert.NoError(t, err)
+		assert.Len(t, result.Channel, 2)
+
+		channel, ok := FindEPGChannel(result, "CCTV-1 综合", WithChannelNormalizer(normalizer))
+		assert.True(t, ok)
+		assert.Equal(t, "cctv1", channel.ID)
+		assert.Equal(t, "CCTV1", channel.DisplayName.Text)
+
+		channel, ok = FindEPGChannel(result, "凤凰卫视中文台", WithChannelNormalizer(normalizer))
+		assert.True(t, ok)
+		assert.Equal(t, "凤凰中文", channel.ID)
+
+		for _, programme := range result.Programme {
+			assert.Contains(t, []string{"cctv1", "凤凰中文"}, programme.Channel)
+		}
+
+		_, ok = FindEPGChannel(result, "CCTV-1 综合")
+		assert.False(t, ok)
+	})
+
+	t.Run("Lives", func(t *testing.T) {
+		result, err := MixTvBoxRepo(cfg, mockSourcer, WithChannelNormalizer(normalizer))
+		assert.NoError(t, err)
+		assert.Len(t, result.Lives, 3)
+
+		assert.Equal(t, "CCTV1", result.Lives[0].Channels[0].Name)
+		assert.Equal(t, "凤凰中文", result.Lives[1].Channels[0].Name)
+		// 同一分组中规范名称相同的频道合并地址
+		assert.Len(t, result.Lives[2].Channels, 1)
+		assert.Equal(t, []string{"http://c/1.m3u8", "http://c/2.m3u8"}, result.Lives[2].Channels[0].URLs)
+	})
+}
@@ -0,0 +1,239 @@
+package mixer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+// ChannelNumberer 为混合后的频道分配 tvg-chno
+// 分配过的编号保存在状态文件中, 频道暂时从上游消失时其编号保留, 不会分配给其他频道
+type ChannelNumberer struct {
+	opt    config.ChannelNumberOpt
+	pinned map[string]int // 规范化后的频道名称 -> 固定编号
+	bases  map[string]int // 分组 -> 起始编号
+	mu     sync.Mutex
+	state  map[string]int // 频道标识 -> 已分配的编号
+	logger *slog.Logger
+}
+
+func NewChannelNumberer(opt config.ChannelNumberOpt, logger *slog.Logger) (*ChannelNumberer, error) {
+	n := &ChannelNumberer{
+		opt:    opt,
+		pinned: make(map[string]int),
+		bases:  make(map[string]int, len(opt.GroupBases)),
+		state:  make(map[string]int),
+	}
+
+	if logger != nil {
+		n.logger = logger.With("manager", "channel_numberer")
+	}
+
+	// 编号未持久化时重启 (如容器重建) 后频道编号会变化
+	if opt.StateFile == "" {
+		return nil, errors.New("channel numbering requires state_file")
+	}
+	if isTempPath(opt.StateFile) && n.logger != nil {
+		n.logger.Warn("channel number state file is in the temp directory and may be lost on restart",
+			"state_file", opt.StateFile)
+	}
+
+	for _, base := range opt.GroupBases {
+		n.bases[base.Group] = base.Base
+	}
+
+	if opt.MappingFile != "" {
+		data, err := os.ReadFile(opt.MappingFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading channel number mapping: %w", err)
+		}
+		if n.pinned, err = parseChannelNumberMapping(data); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(opt.StateFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading channel number state: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &n.state); err != nil {
+			n.log("ignore invalid channel number state %s: %v", opt.StateFile, err)
+			n.state = make(map[string]int)
+		}
+	}
+
+	return n, nil
+}
+
+// isTempPath 判断路径是否位于系统临时目录
+func isTempPath(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(filepath.Clean(os.TempDir()), abs)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (n *ChannelNumberer) log(format string, args ...any) {
+	if n.logger != nil {
+		n.logger.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+// parseChannelNumberMapping 解析固定编号文件, 每行为 "编号,频道名称", # 开头为注释
+func parseChannelNumberMapping(data []byte) (map[string]int, error) {
+	pinned := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		number, name, ok := strings.Cut(text, ",")
+		chno, err := strconv.Atoi(strings.TrimSpace(number))
+		if !ok || err != nil || chno <= 0 || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid channel number mapping at line %d: %q", line, text)
+		}
+		pinned[normalizeChannelKey(name)] = chno
+	}
+	return pinned, scanner.Err()
+}
+
+// numberedChannel 为同一频道的所有轨道, 去重后的备用轨道与主轨道使用相同的编号
+type numberedChannel struct {
+	key    string
+	group  string
+	tracks []int
+	chno   int
+}
+
+// Assign 为轨道设置 tvg-chno
+// 优先级依次为: 固定编号、上游编号 (仅 keep 方式)、已保存的编号、从分组起始编号开始的第一个空闲编号
+func (n *ChannelNumberer) Assign(tracks []m3u.Track) {
+	var channels []*numberedChannel
+	byKey := make(map[string]*numberedChannel)
+	for i := range tracks {
+		key := channelKey(&tracks[i])
+		channel, ok := byKey[key]
+		if !ok {
+			channel = &numberedChannel{key: key, group: tracks[i].GetTag("group-title")}
+			byKey[key] = channel
+			channels = append(channels, channel)
+		}
+		channel.tracks = append(channel.tracks, i)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	used := make(map[int]bool)
+	take := func(channel *numberedChannel, chno int) bool {
+		if chno <= 0 || used[chno] {
+			return false
+		}
+		channel.chno = chno
+		used[chno] = true
+		return true
+	}
+
+	for _, channel := range channels {
+		track := &tracks[channel.tracks[0]]
+		if chno, ok := n.pinned[normalizeChannelKey(track.Name)]; ok {
+			take(channel, chno)
+		} else if chno, ok := n.pinned[normalizeChannelKey(track.GetTag("tvg-id"))]; ok {
+			take(channel, chno)
+		}
+	}
+
+	// 固定编号只属于映射文件中的频道, 即使该频道本次未出现也不分配给其他频道
+	reserved := make(map[int]bool, len(n.pinned)+len(n.state))
+	for _, chno := range n.pinned {
+		reserved[chno] = true
+	}
+
+	if n.opt.Mode == config.ChannelNumberKeep {
+		for _, channel := range channels {
+			if channel.chno == 0 {
+				chno, _ := strconv.Atoi(strings.TrimSpace(tracks[channel.tracks[0]].GetTag("tvg-chno")))
+				if !reserved[chno] {
+					take(channel, chno)
+				}
+			}
+		}
+	}
+
+	for _, channel := range channels {
+		if chno := n.state[channel.key]; channel.chno == 0 && !reserved[chno] {
+			take(channel, chno)
+		}
+	}
+
+	// 已保存但本次未出现的频道的编号保留, 以便频道恢复后编号不变
+	for key, chno := range n.state {
+		if _, ok := byKey[key]; !ok {
+			reserved[chno] = true
+		}
+	}
+
+	changed := false
+	for _, channel := range channels {
+		if channel.chno == 0 {
+			chno := n.base(channel.group)
+			for used[chno] || reserved[chno] {
+				chno++
+			}
+			take(channel, chno)
+		}
+		if n.state[channel.key] != channel.chno {
+			n.state[channel.key] = channel.chno
+			changed = true
+		}
+		for _, i := range channel.tracks {
+			tracks[i].SetTag("tvg-chno", strconv.Itoa(channel.chno))
+		}
+	}
+
+	if changed {
+		if err := n.save(); err != nil {
+			n.log("save channel number state: %v", err)
+		}
+	}
+}
+
+// base 返回分组的起始编号
+func (n *ChannelNumberer) base(group string) int {
+	if n.opt.Mode == config.ChannelNumberGroup {
+		if base, ok := n.bases[group]; ok && base > 0 {
+			return base
+		}
+	}
+	return max(n.opt.Start, 1)
+}
+
+func (n *ChannelNumberer) save() error {
+	data, err := json.MarshalIndent(n.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(n.opt.StateFile), 0o755); err != nil {
+		return err
+	}
+	tmpFile := n.opt.StateFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, n.opt.StateFile)
+}
@@ -0,0 +1,138 @@
+package mixer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+func parseTestPlaylist(t *testing.T, data string) []m3u.Track {
+	t.Helper()
+	playlist, err := config.ParseM3U8Config(strings.NewReader(data))
+	assert.NoError(t, err)
+	return playlist.Tracks
+}
+
+func channelNumbers(tracks []m3u.Track) map[string]string {
+	result := make(map[string]string)
+	for _, track := range tracks {
+		result[track.Name] = track.GetTag("tvg-chno")
+	}
+	return result
+}
+
+func TestChannelNumberer_Group(t *testing.T) {
+	dir := t.TempDir()
+	mappingFile := filepath.Join(dir, "chno.txt")
+	assert.NoError(t, os.WriteFile(mappingFile, []byte("# 固定编号\n100,湖南卫视\n"), 0o644))
+
+	opt := config.ChannelNumberOpt{
+		Mode: config.ChannelNumberGroup,
+		GroupBases: []config.GroupNumberBase{
+			{Group: "央视", Base: 1},
+			{Group: "卫视", Base: 101},
+		},
+		MappingFile: mappingFile,
+		StateFile:   filepath.Join(dir, "state", "chno.json"),
+	}
+	opt.Start = 500
+
+	numberer, err := NewChannelNumberer(opt, nil)
+	assert.NoError(t, err)
+
+	tracks := parseTestPlaylist(t, "#EXTM3U\n"+
+		"#EXTINF:-1 tvg-chno=\"9\" group-title=\"央视\",CCTV1\nhttp://a/cctv1.m3u8\n"+
+		"#EXTINF:-1 group-title=\"央视\",CCTV1\nhttp://b/cctv1.m3u8\n"+
+		"#EXTINF:-1 group-title=\"央视\",CCTV2\nhttp://a/cctv2.m3u8\n"+
+		"#EXTINF:-1 group-title=\"卫视\",湖南卫视\nhttp://a/hunan.m3u8\n"+
+		"#EXTINF:-1 group-title=\"卫视\",东方卫视\nhttp://a/dongfang.m3u8\n"+
+		"#EXTINF:-1 group-title=\"其他\",CGTN\nhttp://a/cgtn.m3u8\n")
+	numberer.Assign(tracks)
+
+	assert.Equal(t, map[string]string{
+		"CCTV1": "1", "CCTV2": "2", "湖南卫视": "100", "东方卫视": "101", "CGTN": "500",
+	}, channelNumbers(tracks))
+	// 同一频道的备用轨道使用相同的编号
+	assert.Equal(t, "1", tracks[1].GetTag("tvg-chno"))
+
+	// 重启后从状态文件恢复编号, CCTV1 暂时消失时其编号不会分配给新频道
+	numberer, err = NewChannelNumberer(opt, nil)
+	assert.NoError(t, err)
+	tracks = parseTestPlaylist(t, "#EXTM3U\n"+
+		"#EXTINF:-1 group-title=\"央视\",CCTV5\nhttp://a/cctv5.m3u8\n"+
+		"#EXTINF:-1 group-title=\"央视\",CCTV2\nhttp://a/cctv2.m3u8\n"+
+		"#EXTINF:-1 group-title=\"卫视\",东方卫视\nhttp://a/dongfang.m3u8\n")
+	numberer.Assign(tracks)
+	assert.Equal(t, map[string]string{"CCTV5": "3", "CCTV2": "2", "东方卫视": "101"}, channelNumbers(tracks))
+
+	tracks = parseTestPlaylist(t, "#EXTM3U\n"+
+		"#EXTINF:-1 group-title=\"央视\",CCTV1\nhttp://a/cctv1.m3u8\n"+
+		"#EXTINF:-1 group-title=\"央视\",CCTV5\nhttp://a/cctv5.m3u8\n")
+	numberer.Assign(tracks)
+	assert.Equal(t, map[string]string{"CCTV1": "1", "CCTV5": "3"}, channelNumbers(tracks))
+}
+
+func TestChannelNumberer_PinnedMissing(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "chno.txt")
+	assert.NoError(t, os.WriteFile(mappingFile, []byte("1,CCTV1\n2,CCTV2\n"), 0o644))
+
+	numberer, err := NewChannelNumberer(config.ChannelNumberOpt{
+		Mode:        config.ChannelNumberKeep,
+		Start:       1,
+		MappingFile: mappingFile,
+		StateFile:   filepath.Join(t.TempDir(), "chno.json"),
+	}, nil)
+	assert.NoError(t, err)
+
+	// 固定编号的频道未出现时, 其编号不会分配给其他频道, 包括源中自带的编号
+	tracks := parseTestPlaylist(t, "#EXTM3U\n"+
+		"#EXTINF:-1 tvg-chno=\"2\",CCTV5\nhttp://a/cctv5.m3u8\n"+
+		"#EXTINF:-1,CCTV6\nhttp://a/cctv6.m3u8\n")
+	numberer.Assign(tracks)
+	assert.Equal(t, map[string]string{"CCTV5": "3", "CCTV6": "4"}, channelNumbers(tracks))
+
+	tracks = parseTestPlaylist(t, "#EXTM3U\n"+
+		"#EXTINF:-1,CCTV1\nhttp://a/cctv1.m3u8\n"+
+		"#EXTINF:-1,CCTV5\nhttp://a/cctv5.m3u8\n")
+	numberer.Assign(tracks)
+	assert.Equal(t, map[string]string{"CCTV1": "1", "CCTV5": "3"}, channelNumbers(tracks))
+}
+
+func TestChannelNumberer_Keep(t *testing.T) {
+	numberer, err := NewChannelNumberer(config.ChannelNumberOpt{
+		Mode:      config.ChannelNumberKeep,
+		Start:     1,
+		StateFile: filepath.Join(t.TempDir(), "chno.json"),
+	}, nil)
+	assert.NoError(t, err)
+
+	tracks := parseTestPlaylist(t, "#EXTM3U\n"+
+		"#EXTINF:-1 tvg-chno=\"2\",CCTV1\nhttp://a/cctv1.m3u8\n"+
+		"#EXTINF:-1 tvg-chno=\"2\",CCTV2\nhttp://a/cctv2.m3u8\n"+
+		"#EXTINF:-1,CCTV3\nhttp://a/cctv3.m3u8\n")
+	numberer.Assign(tracks)
+
+	// 编号冲突的频道重新分配
+	assert.Equal(t, map[string]string{"CCTV1": "2", "CCTV2": "1", "CCTV3": "3"}, channelNumbers(tracks))
+}
+
+func TestNewChannelNumberer_InvalidMapping(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "chno.txt")
+	assert.NoError(t, os.WriteFile(mappingFile, []byte("CCTV1\n"), 0o644))
+
+	_, err := NewChannelNumberer(config.ChannelNumberOpt{
+		MappingFile: mappingFile,
+		StateFile:   filepath.Join(t.TempDir(), "chno.json"),
+	}, nil)
+	assert.ErrorContains(t, err, "mapping")
+
+	// 未配置 state_file 时编号无法在重启后保持
+	_, err = NewChannelNumberer(config.ChannelNumberOpt{}, nil)
+	assert.ErrorContains(t, err, "state_file")
+}
@@ -0,0 +1,495 @@
+package mixer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+const (
+	cmsRefSeparator  = "::"  // 聚合 vod_id 中上游站点 key 与原始 id 的分隔符
+	cmsRefsSeparator = ";"   // 聚合 vod_id 中多个上游条目的分隔符
+	cmsPlaySeparator = "$$$" // vod_play_from/vod_play_url 中多个播放源的分隔符
+
+	maxCMSDetailRefs   = 32      // 单个详情请求最多请求的上游条目数
+	maxCMSConcurrency  = 8       // 单个详情请求同时请求上游的数量
+	maxCMSResponseSize = 8 << 20 // 上游响应的大小上限
+)
+
+// CMSResponse 是苹果 CMS v10 JSON 接口的响应格式
+type CMSResponse struct {
+	Code      int                          `json:"code"`
+	Msg       string                       `json:"msg"`
+	Page      int                          `json:"page"`
+	PageCount int                          `json:"pagecount"`
+	Limit     int                          `json:"limit"`
+	Total     int                          `json:"total"`
+	Class     []json.RawMessage            `json:"class"`
+	List      []map[string]json.RawMessage `json:"list"`
+}
+
+type cmsUpstream struct {
+	Key  string
+	Name string
+	API  string
+}
+
+// cmsItem 为上游站点返回的单个影片条目
+type cmsItem struct {
+	upstream cmsUpstream
+	fields   map[string]json.RawMessage
+}
+
+func (i cmsItem) get(name string) string {
+	return gjson.ParseBytes(i.fields[name]).String()
+}
+
+func setCMSField(fields map[string]json.RawMessage, name, value string) {
+	raw, _ := json.Marshal(value)
+	fields[name] = raw
+}
+
+// newSearchSite 生成指向本服务聚合搜索接口的站点
+func newSearchSite(cfg *config.Config) config.TvBoxSite {
+	opt := cfg.TvBoxSingleRepoOpt.SearchSite
+	return config.TvBoxSite{
+		Key:         opt.Key,
+		Name:        opt.Name,
+		Type:        1,
+		API:         getExternalURL(cfg) + "/v1/tvbox/search",
+		Searchable:  1,
+		QuickSearch: 1,
+	}
+}
+
+// searchUpstreams 从混合后的站点中选出参与聚合搜索的 JSON CMS 站点
+func searchUpstreams(cfg *config.Config, repo *config.TvBoxRepoConfig) []cmsUpstream {
+	opt := cfg.TvBoxSingleRepoOpt.SearchSite
+	includeRegex := compileRegex(opt.Include)
+	excludeRegex := compileRegex(opt.Exclude)
+
+	var upstreams []cmsUpstream
+	seen := make(map[string]bool)
+	for _, site := range repo.Sites {
+		if site.Type != 1 || site.Key == opt.Key || !isHTTPURL(site.API) || seen[site.Key] {
+			continue
+		}
+		value := site.Key
+		if opt.FilterBy == "name" {
+			value = site.Name
+		}
+		if !matchFilter(value, includeRegex, excludeRegex) {
+			continue
+		}
+		seen[site.Key] = true
+		upstreams = append(upstreams, cmsUpstream{Key: site.Key, Name: site.Name, API: site.API})
+	}
+	return upstreams
+}
+
+// CMSSearch 实现聚合搜索站点的 CMS 接口
+// 参与聚合的站点在站点所在的源刷新或站点健康检查完成后才重新混合, 避免每个请求都完整混合单仓
+type CMSSearch struct {
+	cfg        *config.Config
+	sourcer    Sourcer
+	opts       []MixOption
+	siteProber *SiteProber
+
+	mu        sync.Mutex
+	resolved  bool
+	versions  map[string]time.Time // 混合时各站点源的更新时间
+	probedAt  time.Time            // 混合时站点健康检查的完成时间
+	upstreams []cmsUpstream
+}
+
+func NewCMSSearch(cfg *config.Config, sourcer Sourcer, opts ...MixOption) *CMSSearch {
+	return &CMSSearch{
+		cfg:        cfg,
+		sourcer:    sourcer,
+		opts:       opts,
+		siteProber: newMixOptions(opts).siteProber,
+	}
+}
+
+// resolveUpstreams 返回参与聚合的上游站点, 站点源与健康检查结果未变化时复用上一次的结果
+func (s *CMSSearch) resolveUpstreams() ([]cmsUpstream, error) {
+	versions, cacheable := siteSourceVersions(s.cfg, s.sourcer)
+	probedAt := s.siteProber.version()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cacheable && s.resolved && sameSourceVersions(s.versions, versions) && s.probedAt.Equal(probedAt) {
+		return s.upstreams, nil
+	}
+
+	repo, err := MixTvBoxRepo(s.cfg, s.sourcer, s.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("mixing repo: %w", err)
+	}
+
+	upstreams := searchUpstreams(s.cfg, repo)
+	if cacheable {
+		s.resolved, s.versions, s.probedAt, s.upstreams = true, versions, probedAt, upstreams
+	}
+	return upstreams, nil
+}
+
+// siteSourceVersions 返回 sites 引用的各个源的更新时间, 无法获取的源记为零值
+// 存在已获取但没有更新时间的源时无法判断是否变化, 不应缓存
+func siteSourceVersions(cfg *config.Config, sourcer Sourcer) (map[string]time.Time, bool) {
+	var names []string
+	for _, opt := range cfg.TvBoxSingleRepoOpt.Sites {
+		if opt.SourceName != "" {
+			names = append(names, opt.SourceName)
+		}
+		if lister, ok := sourcer.(SourceLister); ok && opt.SourcePattern != "" {
+			matched, _ := matchSourceNames(opt.SourcePattern, lister.SourceNames())
+			names = append(names, matched...)
+		}
+	}
+
+	versions := make(map[string]time.Time, len(names))
+	cacheable := true
+	for _, name := range names {
+		if _, ok := versions[name]; ok {
+			continue
+		}
+		source, err := sourcer.GetSource(name)
+		if err != nil {
+			versions[name] = time.Time{}
+			continue
+		}
+		if source.lastUpdate.IsZero() {
+			cacheable = false
+		}
+		versions[name] = source.lastUpdate
+	}
+	return versions, cacheable
+}
+
+func sameSourceVersions(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, t := range a {
+		if other, ok := b[name]; !ok || !t.Equal(other) {
+			return false
+		}
+	}
+	return true
+}
+
+// Query 处理 CMS 请求
+// 带 ids 时返回详情; 带 t 时将分类请求转发给分类所属的上游站点;
+// 其余请求并发请求所有上游站点并合并同名影片, 带 wd 时为搜索, 否则为首页列表, 同时合并各站点的分类
+func (s *CMSSearch) Query(query url.Values) (*CMSResponse, error) {
+	upstreams, err := s.resolveUpstreams()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(s.cfg.TvBoxSingleRepoOpt.SearchSite.Timeout) * time.Second
+	page, _ := strconv.Atoi(query.Get("pg"))
+	if page < 1 {
+		page = 1
+	}
+	ac := query.Get("ac")
+	if ac == "" {
+		ac = "list"
+	}
+
+	result := &CMSResponse{Code: 1, Msg: "数据列表", Page: page, PageCount: 1, Class: []json.RawMessage{}}
+	switch {
+	case query.Get("ids") != "":
+		result.Page = 1
+		result.List = cmsDetail(upstreams, strings.Split(query.Get("ids"), ","), timeout)
+	case query.Get("t") != "":
+		upstreamQuery := url.Values{}
+		upstreamQuery.Set("ac", ac)
+		upstreamQuery.Set("pg", strconv.Itoa(page))
+		if f := query.Get("f"); f != "" {
+			upstreamQuery.Set("f", f)
+		}
+		result.List, result.PageCount = cmsCategory(upstreams, query.Get("t"), upstreamQuery, timeout)
+	default:
+		upstreamQuery := url.Values{}
+		upstreamQuery.Set("ac", ac)
+		upstreamQuery.Set("pg", strconv.Itoa(page))
+		wd := query.Get("wd")
+		if wd != "" {
+			upstreamQuery.Set("wd", wd)
+		}
+		var classes []json.RawMessage
+		result.List, result.PageCount, classes = cmsFanOut(upstreams, upstreamQuery, timeout)
+		if wd == "" && page == 1 {
+			result.Class = classes
+		}
+	}
+
+	if result.List == nil {
+		result.List = []map[string]json.RawMessage{}
+	}
+	result.Total = len(result.List)
+	result.Limit = len(result.List)
+	return result, nil
+}
+
+// cmsFanOut 以相同的参数并发请求所有上游站点，同名同年份的影片合并为一个条目
+// 各站点的分类 type_id 改写为 {站点 key}::{type_id}, 分类名称前加上站点名称
+func cmsFanOut(
+	upstreams []cmsUpstream, query url.Values, timeout time.Duration,
+) ([]map[string]json.RawMessage, int, []json.RawMessage) {
+	responses := make([]gjson.Result, len(upstreams))
+	var wg sync.WaitGroup
+	for i, upstream := range upstreams {
+		wg.Add(1)
+		go func(i int, upstream cmsUpstream) {
+			defer wg.Done()
+			responses[i], _ = fetchCMS(upstream.API, query, timeout)
+		}(i, upstream)
+	}
+	wg.Wait()
+
+	var list []map[string]json.RawMessage
+	var classes []json.RawMessage
+	merged := make(map[string]int) // 去重 key -> list 下标
+	pageCount := 1
+	for i, resp := range responses {
+		if n := int(resp.Get("pagecount").Int()); n > pageCount {
+			pageCount = n
+		}
+		for _, raw := range resp.Get("class").Array() {
+			if class, ok := prefixCMSClass(upstreams[i], raw); ok {
+				classes = append(classes, class)
+			}
+		}
+		for _, raw := range resp.Get("list").Array() {
+			item, ok := parseCMSItem(upstreams[i], raw)
+			if !ok {
+				continue
+			}
+			ref := upstreams[i].Key + cmsRefSeparator + item.get("vod_id")
+			key := strings.ToLower(strings.TrimSpace(item.get("vod_name"))) + "|" + item.get("vod_year")
+			if idx, ok := merged[key]; ok {
+				ids := gjson.ParseBytes(list[idx]["vod_id"]).String()
+				if !strings.Contains(cmsRefsSeparator+ids+cmsRefsSeparator, cmsRefsSeparator+ref+cmsRefsSeparator) {
+					setCMSField(list[idx], "vod_id", ids+cmsRefsSeparator+ref)
+				}
+				continue
+			}
+			setCMSField(item.fields, "vod_id", ref)
+			merged[key] = len(list)
+			list = append(list, item.fields)
+		}
+	}
+	return list, pageCount, classes
+}
+
+func prefixCMSClass(upstream cmsUpstream, raw gjson.Result) (json.RawMessage, bool) {
+	typeID := raw.Get("type_id").String()
+	if !raw.IsObject() || typeID == "" {
+		return nil, false
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw.Raw), &fields); err != nil {
+		return nil, false
+	}
+	setCMSField(fields, "type_id", upstream.Key+cmsRefSeparator+typeID)
+	setCMSField(fields, "type_name", upstream.Name+"-"+raw.Get("type_name").String())
+	class, err := json.Marshal(fields)
+	return class, err == nil
+}
+
+// cmsCategory 将分类请求转发给分类所属的上游站点
+func cmsCategory(
+	upstreams []cmsUpstream, class string, query url.Values, timeout time.Duration,
+) ([]map[string]json.RawMessage, int) {
+	idx := strings.LastIndex(class, cmsRefSeparator)
+	if idx < 0 {
+		return nil, 1
+	}
+	key, typeID := class[:idx], class[idx+len(cmsRefSeparator):]
+
+	for _, upstream := range upstreams {
+		if upstream.Key != key {
+			continue
+		}
+		query.Set("t", typeID)
+		resp, err := fetchCMS(upstream.API, query, timeout)
+		if err != nil {
+			return nil, 1
+		}
+
+		var list []map[string]json.RawMessage
+		for _, raw := range resp.Get("list").Array() {
+			item, ok := parseCMSItem(upstream, raw)
+			if !ok {
+				continue
+			}
+			setCMSField(item.fields, "vod_id", upstream.Key+cmsRefSeparator+item.get("vod_id"))
+			list = append(list, item.fields)
+		}
+		return list, max(int(resp.Get("pagecount").Int()), 1)
+	}
+	return nil, 1
+}
+
+// cmsDetail 按聚合 vod_id 请求上游详情，多个上游的播放源合并到同一条目中
+// ids 由客户端提供, 单个请求最多请求 maxCMSDetailRefs 个上游条目, 并限制同时请求的数量
+func cmsDetail(upstreams []cmsUpstream, ids []string, timeout time.Duration) []map[string]json.RawMessage {
+	byKey := make(map[string]cmsUpstream, len(upstreams))
+	for _, upstream := range upstreams {
+		byKey[upstream.Key] = upstream
+	}
+
+	type detailRef struct {
+		upstream cmsUpstream
+		id       string
+		item     cmsItem
+		ok       bool
+	}
+
+	refs := make([][]*detailRef, len(ids))
+	total := 0
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxCMSConcurrency)
+	for i, id := range ids {
+		for _, ref := range strings.Split(id, cmsRefsSeparator) {
+			idx := strings.LastIndex(ref, cmsRefSeparator)
+			if idx < 0 {
+				continue
+			}
+			upstream, ok := byKey[ref[:idx]]
+			if !ok {
+				continue
+			}
+			if total >= maxCMSDetailRefs {
+				break
+			}
+			total++
+			r := &detailRef{upstream: upstream, id: ref[idx+len(cmsRefSeparator):]}
+			refs[i] = append(refs[i], r)
+
+			wg.Add(1)
+			go func(r *detailRef) {
+				sem <- struct{}{}
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				query := url.Values{}
+				query.Set("ac", "detail")
+				query.Set("ids", r.id)
+				resp, err := fetchCMS(r.upstream.API, query, timeout)
+				if err != nil {
+					return
+				}
+				r.item, r.ok = parseCMSItem(r.upstream, resp.Get("list.0"))
+			}(r)
+		}
+	}
+	wg.Wait()
+
+	var list []map[string]json.RawMessage
+	for i, id := range ids {
+		var items []cmsItem
+		for _, r := range refs[i] {
+			if r.ok {
+				items = append(items, r.item)
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		fields := mergeCMSPlay(items)
+		setCMSField(fields, "vod_id", id)
+		list = append(list, fields)
+	}
+	return list
+}
+
+// mergeCMSPlay 以第一个条目为基础，合并所有条目的播放源
+// 多个上游时播放源名称前加上站点名称以便区分
+func mergeCMSPlay(items []cmsItem) map[string]json.RawMessage {
+	fields := items[0].fields
+	if len(items) == 1 {
+		return fields
+	}
+
+	var froms, urls []string
+	for _, item := range items {
+		itemFroms := strings.Split(item.get("vod_play_from"), cmsPlaySeparator)
+		itemURLs := strings.Split(item.get("vod_play_url"), cmsPlaySeparator)
+		for j, playURL := range itemURLs {
+			if playURL == "" {
+				continue
+			}
+			from := item.upstream.Name
+			if j < len(itemFroms) && itemFroms[j] != "" {
+				from += "-" + itemFroms[j]
+			}
+			froms = append(froms, from)
+			urls = append(urls, playURL)
+		}
+	}
+
+	setCMSField(fields, "vod_play_from", strings.Join(froms, cmsPlaySeparator))
+	setCMSField(fields, "vod_play_url", strings.Join(urls, cmsPlaySeparator))
+	return fields
+}
+
+func parseCMSItem(upstream cmsUpstream, raw gjson.Result) (cmsItem, bool) {
+	if !raw.IsObject() {
+		return cmsItem{}, false
+	}
+	item := cmsItem{upstream: upstream}
+	if err := json.Unmarshal([]byte(raw.Raw), &item.fields); err != nil {
+		return cmsItem{}, false
+	}
+	return item, item.get("vod_id") != ""
+}
+
+// fetchCMS 请求上游 CMS 接口, 每个请求单独计算超时
+func fetchCMS(api string, query url.Values, timeout time.Duration) (gjson.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, appendQuery(api, query.Encode()), nil)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+
+	resp, err := config.GetDefaultHttpClient().Do(req)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return gjson.Result{}, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCMSResponseSize+1))
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	if len(body) > maxCMSResponseSize {
+		return gjson.Result{}, fmt.Errorf("response exceeds %d bytes", maxCMSResponseSize)
+	}
+	if !gjson.ValidBytes(body) {
+		return gjson.Result{}, fmt.Errorf("invalid JSON response")
+	}
+	return gjson.ParseBytes(body), nil
+}
@@ -0,0 +1,202 @@
+package mixer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+func TestCMSSearch(t *testing.T) {
+	var detailCalls atomic.Int32
+	cmsA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("ids") != "" {
+			detailCalls.Add(1)
+		}
+		switch {
+		case query.Get("ids") == "1":
+			w.Write([]byte(`{"code":1,"list":[{"vod_id":1,"vod_name":"Movie","vod_play_from":"m3u8","vod_play_url":"第1集$http://a/1.m3u8"}]}`))
+		case query.Get("t") != "":
+			assert.Equal(t, "5", query.Get("t"))
+			assert.Equal(t, "2", query.Get("pg"))
+			w.Write([]byte(`{"code":1,"pagecount":7,"list":[{"vod_id":3,"vod_name":"Drama"}]}`))
+		case query.Get("wd") == "":
+			w.Write([]byte(`{"code":1,"class":[{"type_id":5,"type_name":"电视剧"}],"list":[{"vod_id":1,"vod_name":"Movie","vod_year":"2024"}]}`))
+		default:
+			assert.Equal(t, "Movie", query.Get("wd"))
+			w.Write([]byte(`{"code":1,"pagecount":"2","list":[` +
+				`{"vod_id":1,"vod_name":"Movie","vod_year":"2024"},` +
+				`{"vod_id":2,"vod_name":"Movie 2","vod_year":"2024"}]}`))
+		}
+	}))
+	defer cmsA.Close()
+
+	cmsB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Get("ids") == "9":
+			w.Write([]byte(`{"code":1,"list":[{"vod_id":"9","vod_name":"Movie","vod_play_from":"mp4","vod_play_url":"正片$http://b/9.mp4"}]}`))
+		case query.Get("wd") == "":
+			w.Write([]byte(`{"code":1,"class":[{"type_id":"1","type_name":"电影"}],"list":[]}`))
+		default:
+			w.Write([]byte(`{"code":1,"pagecount":1,"list":[{"vod_id":"9","vod_name":" movie ","vod_year":"2024"}]}`))
+		}
+	}))
+	defer cmsB.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(3 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	source := &Source{
+		data: []byte(`{
+			"sites": [
+				{"key": "a", "name": "A", "type": 1, "api": "` + cmsA.URL + `/api.php/provide/vod/"},
+				{"key": "b", "name": "B", "type": 1, "api": "` + cmsB.URL + `/api.php?x=1"},
+				{"key": "slow", "name": "Slow", "type": 1, "api": "` + slow.URL + `/api.php"},
+				{"key": "xml", "name": "Xml", "type": 0, "api": "` + cmsA.URL + `/xml"},
+				{"key": "spider", "name": "Spider", "type": 3, "api": "csp_Spider"}
+			]
+		}`),
+		lastUpdate: time.Now(),
+	}
+	mockSourcer := &MockSourcer{sources: map[string]*Source{"source1": source}}
+
+	cfg := &config.Config{
+		ExternalURL: "http://localhost:8080",
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Sites:      []config.ArrayMixOpt{{MixOpt: config.MixOpt{SourceName: "source1"}}},
+			SearchSite: config.SearchSiteOpt{Enable: true, Timeout: 1},
+		},
+	}
+	cfg.Fixture()
+	prober := &SiteProber{results: make(map[string]*SiteHealth)}
+	search := NewCMSSearch(cfg, mockSourcer, WithSiteProber(prober))
+
+	t.Run("Site", func(t *testing.T) {
+		result, err := MixTvBoxRepo(cfg, mockSourcer)
+		assert.NoError(t, err)
+		assert.Len(t, result.Sites, 6)
+		assert.Equal(t, "tv_mixproxy_search", result.Sites[0].Key)
+		assert.Equal(t, config.FlexInt(1), result.Sites[0].Type)
+		assert.Equal(t, "http://localhost:8080/v1/tvbox/search", result.Sites[0].API)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		start := time.Now()
+		result, err := search.Query(url.Values{"wd": {"Movie"}})
+		assert.NoError(t, err)
+		assert.Less(t, time.Since(start), 3*time.Second)
+		assert.Equal(t, 2, result.PageCount)
+		assert.Len(t, result.List, 2)
+		assert.Equal(t, "a::1;b::9", gjson.ParseBytes(result.List[0]["vod_id"]).String())
+		assert.Equal(t, "a::2", gjson.ParseBytes(result.List[1]["vod_id"]).String())
+	})
+
+	t.Run("Detail", func(t *testing.T) {
+		result, err := search.Query(url.Values{"ac": {"detail"}, "ids": {"a::1;b::9,unknown::1"}})
+		assert.NoError(t, err)
+		assert.Len(t, result.List, 1)
+		item := result.List[0]
+		assert.Equal(t, "a::1;b::9", gjson.ParseBytes(item["vod_id"]).String())
+		assert.Equal(t, "Movie", gjson.ParseBytes(item["vod_name"]).String())
+		assert.Equal(t, "A-m3u8$$$B-mp4", gjson.ParseBytes(item["vod_play_from"]).String())
+		assert.Equal(t, "第1集$http://a/1.m3u8$$$正片$http://b/9.mp4", gjson.ParseBytes(item["vod_play_url"]).String())
+	})
+
+	t.Run("DetailLimit", func(t *testing.T) {
+		// 客户端提供的 ids 不能无限制地放大为上游请求
+		detailCalls.Store(0)
+		ids := strings.Repeat("a::1"+cmsRefsSeparator, 100) + "a::1"
+		result, err := search.Query(url.Values{"ac": {"detail"}, "ids": {ids + "," + ids}})
+		assert.NoError(t, err)
+		assert.Len(t, result.List, 1)
+		assert.Equal(t, int32(maxCMSDetailRefs), detailCalls.Load())
+	})
+
+	t.Run("Home", func(t *testing.T) {
+		result, err := search.Query(url.Values{"ac": {"list"}})
+		assert.NoError(t, err)
+		assert.Len(t, result.List, 1)
+		assert.Equal(t, "a::1", gjson.ParseBytes(result.List[0]["vod_id"]).String())
+		assert.Len(t, result.Class, 2)
+		assert.JSONEq(t, `{"type_id":"a::5","type_name":"A-电视剧"}`, string(result.Class[0]))
+		assert.JSONEq(t, `{"type_id":"b::1","type_name":"B-电影"}`, string(result.Class[1]))
+	})
+
+	t.Run("Category", func(t *testing.T) {
+		result, err := search.Query(url.Values{"ac": {"detail"}, "t": {"a::5"}, "pg": {"2"}})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, result.Page)
+		assert.Equal(t, 7, result.PageCount)
+		assert.Len(t, result.List, 1)
+		assert.Equal(t, "a::3", gjson.ParseBytes(result.List[0]["vod_id"]).String())
+
+		result, err = search.Query(url.Values{"t": {"unknown::1"}})
+		assert.NoError(t, err)
+		assert.Empty(t, result.List)
+		assert.NotNil(t, result.List)
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		// 源未刷新时沿用已解析的站点
+		data := source.data
+		source.data = []byte(`{"sites": []}`)
+		result, err := search.Query(url.Values{"wd": {"Movie"}})
+		assert.NoError(t, err)
+		assert.Len(t, result.List, 2)
+
+		source.lastUpdate = source.lastUpdate.Add(time.Minute)
+		result, err = search.Query(url.Values{"wd": {"Movie"}})
+		assert.NoError(t, err)
+		assert.Empty(t, result.List)
+		source.data = data
+
+		// 站点健康检查完成后重新混合, 使 min_health 的排除结果生效
+		source.lastUpdate = source.lastUpdate.Add(time.Minute)
+		result, err = search.Query(url.Values{"wd": {"Movie"}})
+		assert.NoError(t, err)
+		assert.Len(t, result.List, 2)
+
+		cfg.TvBoxSingleRepoOpt.Sites[0].MinHealth = 0.5
+		defer func() { cfg.TvBoxSingleRepoOpt.Sites[0].MinHealth = 0 }()
+		prober.mu.Lock()
+		prober.results[cmsA.URL+"/api.php/provide/vod/"] = &SiteHealth{Score: 0.1}
+		prober.mu.Unlock()
+		result, err = search.Query(url.Values{"wd": {"Movie"}})
+		assert.NoError(t, err)
+		assert.Len(t, result.List, 2)
+
+		prober.mu.Lock()
+		prober.probedAt = time.Now()
+		prober.mu.Unlock()
+		result, err = search.Query(url.Values{"wd": {"Movie"}})
+		assert.NoError(t, err)
+		assert.Len(t, result.List, 1)
+		assert.Equal(t, "b::9", gjson.ParseBytes(result.List[0]["vod_id"]).String())
+	})
+}
+
+func TestFetchCMS_TooLarge(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"list":["`))
+		w.Write([]byte(strings.Repeat("x", maxCMSResponseSize)))
+		w.Write([]byte(`"]}`))
+	}))
+	defer upstream.Close()
+
+	_, err := fetchCMS(upstream.URL, url.Values{}, time.Second)
+	assert.ErrorContains(t, err, "exceeds")
+}
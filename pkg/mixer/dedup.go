@@ -0,0 +1,161 @@
+package mixer
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+// fallbackVLCOpt 为 vlcopt 模式下备用地址使用的 #EXTVLCOPT 选项名
+const fallbackVLCOpt = "fallback-url"
+
+// sourcedTrack 记录轨道所属的源, 用于去重时按源优先级排序
+type sourcedTrack struct {
+	track  m3u.Track
+	source string
+}
+
+// channelKey 返回用于去重的频道标识, 优先使用 tvg-id, 否则使用规范化后的频道名
+func channelKey(track *m3u.Track) string {
+	if id := strings.TrimSpace(track.GetTag("tvg-id")); id != "" {
+		return "id:" + strings.ToLower(id)
+	}
+	name := track.Name
+	if name == "" {
+		name = track.GetTag("tvg-name")
+	}
+	return "name:" + normalizeChannelKey(name)
+}
+
+var channelKeyReplacer = strings.NewReplacer(" ", "", "-", "", "_", "")
+
+func normalizeChannelKey(name string) string {
+	return channelKeyReplacer.Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// dedupTracks 将同一频道的轨道合并, 频道保持首次出现的顺序
+// 每个频道输出优先级最高的轨道, 其余不重复的地址作为备用轨道紧随其后
+// 备用轨道沿用主轨道的名称与标签, 以便各输出格式将其识别为同一频道
+// latency 不为 nil 时地址按首字节时间排列, 没有检查结果的地址排在最后, 源优先级作为次要依据
+func dedupTracks(
+	tracks []sourcedTrack, opt config.M3UDedupOpt, latency func(uri string) (time.Duration, bool),
+) []m3u.Track {
+	priority := make(map[string]int, len(opt.SourcePriority))
+	for i, name := range opt.SourcePriority {
+		if _, ok := priority[name]; !ok {
+			priority[name] = i
+		}
+	}
+	rank := func(source string) int {
+		if p, ok := priority[source]; ok {
+			return p
+		}
+		return len(opt.SourcePriority)
+	}
+
+	var order []string
+	groups := make(map[string][]sourcedTrack)
+	for _, st := range tracks {
+		key := channelKey(&st.track)
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], st)
+	}
+
+	result := make([]m3u.Track, 0, len(tracks))
+	for _, key := range order {
+		group := groups[key]
+		sort.SliceStable(group, func(i, j int) bool {
+			if latency != nil {
+				li, iok := latency(group[i].track.URI)
+				lj, jok := latency(group[j].track.URI)
+				if iok != jok {
+					return iok
+				}
+				if li != lj {
+					return li < lj
+				}
+			}
+			return rank(group[i].source) < rank(group[j].source)
+		})
+
+		primary := group[0].track
+		result = append(result, primary)
+
+		seen := map[string]bool{primary.URI: true}
+		alternates := 0
+		for _, st := range group[1:] {
+			if seen[st.track.URI] {
+				continue
+			}
+			if opt.MaxAlternates > 0 && alternates >= opt.MaxAlternates {
+				break
+			}
+			seen[st.track.URI] = true
+			alternates++
+
+			alt := primary
+			alt.Tags = append([]m3u.Tag(nil), primary.Tags...)
+			alt.URI = st.track.URI
+			alt.VLCOpts = st.track.VLCOpts
+			result = append(result, alt)
+		}
+	}
+
+	return result
+}
+
+// collapseAlternates 将连续的同一频道轨道合并为一个轨道, 备用地址以 #EXTVLCOPT:fallback-url 输出
+// 备用地址只能沿用主轨道的 #EXTVLCOPT, 选项 (如 http-user-agent/http-referrer) 不同的备用轨道保持独立
+func collapseAlternates(playlist *m3u.Playlist) *m3u.Playlist {
+	result := *playlist
+	result.Tracks = make([]m3u.Track, 0, len(playlist.Tracks))
+
+	for _, track := range playlist.Tracks {
+		if n := len(result.Tracks); n > 0 && sameChannel(&result.Tracks[n-1], &track) &&
+			sameVLCOpts(result.Tracks[n-1].VLCOpts, track.VLCOpts) {
+			last := &result.Tracks[n-1]
+			last.VLCOpts = append(last.VLCOpts, m3u.Tag{Name: fallbackVLCOpt, Value: track.URI})
+			continue
+		}
+		track.VLCOpts = append([]m3u.Tag(nil), track.VLCOpts...)
+		result.Tracks = append(result.Tracks, track)
+	}
+
+	return &result
+}
+
+func sameChannel(a, b *m3u.Track) bool {
+	return a.Name == b.Name &&
+		a.GetTag("group-title") == b.GetTag("group-title") &&
+		channelKey(a) == channelKey(b)
+}
+
+// sameVLCOpts 判断两组 #EXTVLCOPT 是否相同, 忽略顺序及已合并的 fallback-url
+func sameVLCOpts(a, b []m3u.Tag) bool {
+	count := make(map[m3u.Tag]int)
+	for _, opt := range a {
+		if opt.Name != fallbackVLCOpt {
+			count[opt]++
+		}
+	}
+	for _, opt := range b {
+		if opt.Name == fallbackVLCOpt {
+			continue
+		}
+		if count[opt] == 0 {
+			return false
+		}
+		count[opt]--
+	}
+	for _, n := range count {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
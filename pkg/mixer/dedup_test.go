@@ -0,0 +1,106 @@
+package mixer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+func TestMixM3UMediaPlayList_Dedup(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"m3u_a": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1 tvg-id=\"cctv1\" group-title=\"央视\",CCTV-1\nhttp://a/cctv1.m3u8\n" +
+					"#EXTINF:-1 group-title=\"卫视\",湖南卫视\nhttp://a/hunan.m3u8\n"),
+			},
+			"m3u_b": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1 tvg-id=\"CCTV1\" group-title=\"CCTV\",CCTV1 综合\n" +
+					"#EXTVLCOPT:http-user-agent=okhttp\n" +
+					"http://b/cctv1.m3u8\n" +
+					"#EXTINF:-1 group-title=\"其他\",湖南 卫视\nhttp://b/hunan.m3u8\n" +
+					"#EXTINF:-1 group-title=\"其他\",湖南卫视\nhttp://a/hunan.m3u8\n"),
+			},
+			"txt_c": {
+				config: config.Source{Type: config.SourceTypeLiveTXT},
+				data:   []byte("卫视,#genre#\n湖南卫视,http://c/hunan1.m3u8#http://c/hunan2.m3u8\n"),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		M3UOpt: config.M3UOpt{
+			MediaPlaylistFilters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "m3u_a"}},
+				{MixOpt: config.MixOpt{SourceName: "m3u_b"}},
+				{MixOpt: config.MixOpt{SourceName: "txt_c"}},
+			},
+			Dedup: config.M3UDedupOpt{
+				Enable:         true,
+				SourcePriority: []string{"m3u_b"},
+				MaxAlternates:  2,
+			},
+		},
+	}
+	cfg.Fixture()
+
+	result, err := MixM3UMediaPlayList(cfg, mockSourcer)
+	assert.NoError(t, err)
+
+	var uris []string
+	for _, track := range result.Tracks {
+		uris = append(uris, track.URI)
+	}
+	assert.Equal(t, []string{
+		"http://b/cctv1.m3u8",
+		"http://a/cctv1.m3u8",
+		"http://b/hunan.m3u8",
+		"http://a/hunan.m3u8",
+		"http://c/hunan1.m3u8",
+	}, uris)
+
+	// 备用轨道沿用主轨道的名称与分组
+	assert.Equal(t, "CCTV1 综合", result.Tracks[1].Name)
+	assert.Equal(t, "CCTV", result.Tracks[1].GetTag("group-title"))
+	assert.Equal(t, "okhttp", result.Tracks[0].GetVLCOpt("http-user-agent"))
+	assert.Empty(t, result.Tracks[1].VLCOpts)
+	assert.Equal(t, "湖南 卫视", result.Tracks[4].Name)
+
+	t.Run("TXT", func(t *testing.T) {
+		data, err := EncodePlaylist(cfg, result, PlaylistFormatTXT)
+		assert.NoError(t, err)
+		assert.Equal(t, "CCTV,#genre#\n"+
+			"CCTV1 综合,http://b/cctv1.m3u8#http://a/cctv1.m3u8\n"+
+			"\n"+
+			"其他,#genre#\n"+
+			"湖南 卫视,http://b/hunan.m3u8#http://a/hunan.m3u8#http://c/hunan1.m3u8\n", string(data))
+	})
+
+	t.Run("M3UTracks", func(t *testing.T) {
+		data, err := EncodePlaylist(cfg, result, PlaylistFormatM3U)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, strings.Count(string(data), "#EXTINF"))
+	})
+
+	t.Run("M3UVLCOpt", func(t *testing.T) {
+		cfg.M3UOpt.Dedup.M3UFallback = config.M3UFallbackVLCOpt
+		data, err := EncodePlaylist(cfg, result, PlaylistFormatM3U)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, strings.Count(string(data), "#EXTINF"))
+		// 备用地址的请求头与主轨道不同时不合并
+		assert.Contains(t, string(data), "#EXTVLCOPT:http-user-agent=okhttp\nhttp://b/cctv1.m3u8\n")
+		assert.Contains(t, string(data), ", CCTV1 综合\nhttp://a/cctv1.m3u8\n")
+		assert.NotContains(t, string(data), "fallback-url=http://a/cctv1.m3u8")
+		assert.Contains(t, string(data), "#EXTVLCOPT:fallback-url=http://a/hunan.m3u8\n"+
+			"#EXTVLCOPT:fallback-url=http://c/hunan1.m3u8\n")
+
+		// 不修改原播放列表
+		assert.Len(t, result.Tracks[0].VLCOpts, 1)
+	})
+}
@@ -9,12 +9,14 @@ import (
 )
 
 func MixEPG(
-	cfg *config.Config, sourcer Sourcer,
+	cfg *config.Config, sourcer Sourcer, opts ...MixOption,
 ) (*epg.EPG, error) {
 	if cfg.EPGOpt.Disable {
 		return &epg.EPG{}, nil
 	}
 
+	o := newMixOptions(opts)
+
 	mixedEPG := &epg.EPG{}
 	channelMap := make(map[string]epg.Channel) // 用于追踪已添加的频道
 
@@ -39,25 +41,29 @@ func MixEPG(
 			sourceChannelMap[channel.ID] = channel
 		}
 
+		var channels []epg.Channel
+		var programmes []epg.Programme
 		if filter.FilterBy == string(config.EPGFilterTypeChannelID) {
 			// 按频道ID过滤
-			filteredChannels := filterChannels(sourceEpg.Channel, filter)
-			for _, channel := range filteredChannels {
-				channelMap[channel.ID] = channel
-			}
-			filteredProgrammes := filterProgrammes(sourceEpg.Programme, filter)
-			mixedEPG.Programme = append(mixedEPG.Programme, filteredProgrammes...)
+			channels = filterChannels(sourceEpg.Channel, filter)
+			programmes = filterProgrammes(sourceEpg.Programme, filter)
 		} else if filter.FilterBy == string(config.EPGFilterTypeProgramTitle) {
 			// 按节目标题过滤
-			filteredProgrammes := filterProgrammes(sourceEpg.Programme, filter)
+			programmes = filterProgrammes(sourceEpg.Programme, filter)
 			// 收集匹配节目对应的频道
-			for _, programme := range filteredProgrammes {
+			for _, programme := range programmes {
 				if channel, exists := sourceChannelMap[programme.Channel]; exists {
-					channelMap[channel.ID] = channel
+					channels = append(channels, channel)
 				}
 			}
-			mixedEPG.Programme = append(mixedEPG.Programme, filteredProgrammes...)
 		}
+
+		// 不同源的频道 ID 各不相同, 需要在合并前按源规范化
+		o.normalizeEPG(channels, programmes)
+		for _, channel := range channels {
+			channelMap[channel.ID] = channel
+		}
+		mixedEPG.Programme = append(mixedEPG.Programme, programmes...)
 	}
 
 	// 将收集的所有频道添加到最终的EPG中
@@ -68,6 +74,21 @@ func MixEPG(
 	return mixedEPG, nil
 }
 
+// epgURL 返回本服务的 XMLTV EPG 地址
+func epgURL(cfg *config.Config) string {
+	return getExternalURL(cfg) + "/v1/epg.xml"
+}
+
+// liveEPGURL 返回 TvBox lives 使用的按频道查询 EPG 的地址模板
+func liveEPGURL(cfg *config.Config) string {
+	return getExternalURL(cfg) + "/v1/epg/diyp?ch={name}&date={date}"
+}
+
+// liveLogoURL 返回 TvBox lives 使用的频道台标地址模板
+func liveLogoURL(cfg *config.Config) string {
+	return getExternalURL(cfg) + "/v1/epg/logo?ch={name}"
+}
+
 func filterChannels(channels []epg.Channel, filter config.ArrayMixOpt) []epg.Channel {
 	var filtered []epg.Channel
 	includeRegex := compileRegex(filter.Include)
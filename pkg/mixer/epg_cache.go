@@ -0,0 +1,145 @@
+package mixer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/epg"
+)
+
+// EPGCache 缓存混合后的 EPG 及频道索引
+// TvBox 每次切换频道都会请求 /v1/epg/diyp 与 /v1/epg/logo, 只有 EPG 源刷新后才重新解析与混合
+type EPGCache struct {
+	cfg     *config.Config
+	sourcer Sourcer
+	opts    []MixOption
+
+	mu       sync.Mutex
+	versions []time.Time // 混合时各 EPG 源的更新时间
+	guide    *MixedEPG
+}
+
+func NewEPGCache(cfg *config.Config, sourcer Sourcer, opts ...MixOption) *EPGCache {
+	return &EPGCache{
+		cfg:     cfg,
+		sourcer: sourcer,
+		opts:    opts,
+	}
+}
+
+// Get 返回混合后的 EPG, EPG 源未刷新时复用上一次的结果
+func (c *EPGCache) Get() (*MixedEPG, error) {
+	versions := make([]time.Time, 0, len(c.cfg.EPGOpt.Filters))
+	cacheable := true
+	for _, filter := range c.cfg.EPGOpt.Filters {
+		source, err := c.sourcer.GetSource(filter.SourceName)
+		if err != nil {
+			return nil, fmt.Errorf("get source %s: %w", filter.SourceName, err)
+		}
+		// 尚未成功拉取的源没有更新时间, 无法判断是否变化
+		if source.lastUpdate.IsZero() {
+			cacheable = false
+		}
+		versions = append(versions, source.lastUpdate)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cacheable && c.guide != nil && sameVersions(c.versions, versions) {
+		return c.guide, nil
+	}
+
+	result, err := MixEPG(c.cfg, c.sourcer, c.opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	guide := newMixedEPG(result, c.opts...)
+	if cacheable {
+		c.versions, c.guide = versions, guide
+	}
+	return guide, nil
+}
+
+func sameVersions(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MixedEPG 为混合后的 EPG 及按频道建立的索引
+type MixedEPG struct {
+	*epg.EPG
+
+	opts       []MixOption
+	channels   map[string]int             // 小写的频道 ID 及名称到 Channel 下标的映射
+	programmes map[string][]epg.Programme // 频道 ID 到节目的映射
+
+	iconsOnce sync.Once
+	icons     logoIndex // 带 icon 的频道索引, 首次补全台标时建立
+}
+
+func newMixedEPG(e *epg.EPG, opts ...MixOption) *MixedEPG {
+	g := &MixedEPG{
+		EPG:        e,
+		opts:       opts,
+		channels:   make(map[string]int, len(e.Channel)*2),
+		programmes: make(map[string][]epg.Programme, len(e.Channel)),
+	}
+
+	// 与 epg.FindChannel 一致, 同名时先出现的频道优先
+	for i, channel := range e.Channel {
+		for _, key := range []string{strings.ToLower(channel.ID), strings.ToLower(channel.DisplayName.Text)} {
+			if _, ok := g.channels[key]; key != "" && !ok {
+				g.channels[key] = i
+			}
+		}
+	}
+	for _, programme := range e.Programme {
+		g.programmes[programme.Channel] = append(g.programmes[programme.Channel], programme)
+	}
+
+	return g
+}
+
+// FindChannel 按频道 ID 或名称查找频道, 未找到时使用规范化后的频道名称查找
+func (g *MixedEPG) FindChannel(name string) (epg.Channel, bool) {
+	if i, ok := g.channels[strings.ToLower(name)]; ok {
+		return g.Channel[i], true
+	}
+	o := newMixOptions(g.opts)
+	if o.normalizer == nil {
+		return epg.Channel{}, false
+	}
+	if i, ok := g.channels[strings.ToLower(o.normalizer.Normalize(name).ID)]; ok {
+		return g.Channel[i], true
+	}
+	return epg.Channel{}, false
+}
+
+// logoIndex 返回 EPG 中带 icon 的频道索引, 同一份 EPG 只建立一次
+func (g *MixedEPG) logoIndex() logoIndex {
+	if g == nil {
+		return nil
+	}
+	g.iconsOnce.Do(func() {
+		g.icons = newLogoIndex(g.EPG)
+	})
+	return g.icons
+}
+
+// DIYP 返回指定频道在指定日期的节目单, 只遍历该频道的节目
+func (g *MixedEPG) DIYP(channel epg.Channel, date time.Time, loc *time.Location) epg.DIYP {
+	e := &epg.EPG{Programme: g.programmes[channel.ID]}
+	return e.DIYP(channel, date, loc)
+}
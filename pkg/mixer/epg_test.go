@@ -3,9 +3,11 @@ package mixer
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/chname"
 	"github.com/wayjam/tv-mixproxy/pkg/epg"
 )
 
@@ -305,3 +307,63 @@ func TestFilterProgrammes(t *testing.T) {
 		})
 	}
 }
+
+func TestEPGCache(t *testing.T) {
+	source := &Source{
+		config: config.Source{Type: config.SourceTypeEPG},
+		data: []byte(`<tv>
+    <channel id="CCTV1"><display-name>CCTV-1 综合</display-name></channel>
+    <channel id="HUNAN"><display-name>湖南卫视</display-name></channel>
+    <programme channel="CCTV1" start="20240101080000 +0800" stop="20240101090000 +0800"><title>朝闻天下</title></programme>
+    <programme channel="HUNAN" start="20240101080000 +0800" stop="20240101090000 +0800"><title>快乐大本营</title></programme>
+</tv>`),
+		lastUpdate: time.Now(),
+	}
+	mockSourcer := &MockSourcer{sources: map[string]*Source{"epg": source}}
+
+	cfg := &config.Config{
+		EPGOpt: config.EPGOpt{
+			Filters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "epg"}, FilterBy: string(config.EPGFilterTypeChannelID)},
+			},
+		},
+	}
+	cfg.Fixture()
+
+	cache := NewEPGCache(cfg, mockSourcer)
+	guide, err := cache.Get()
+	assert.NoError(t, err)
+
+	channel, ok := guide.FindChannel("湖南卫视")
+	assert.True(t, ok)
+	assert.Equal(t, "HUNAN", channel.ID)
+	_, ok = guide.FindChannel("cctv1")
+	assert.True(t, ok)
+	_, ok = guide.FindChannel("东方卫视")
+	assert.False(t, ok)
+
+	loc := time.FixedZone("CST", 8*3600)
+	diyp := guide.DIYP(channel, time.Date(2024, 1, 1, 0, 0, 0, 0, loc), loc)
+	assert.Len(t, diyp.EPGData, 1)
+	assert.Equal(t, "快乐大本营", diyp.EPGData[0].Title)
+
+	// 源未刷新时复用上一次的结果
+	cached, err := cache.Get()
+	assert.NoError(t, err)
+	assert.Same(t, guide, cached)
+
+	source.lastUpdate = source.lastUpdate.Add(time.Minute)
+	refreshed, err := cache.Get()
+	assert.NoError(t, err)
+	assert.NotSame(t, guide, refreshed)
+
+	t.Run("Normalizer", func(t *testing.T) {
+		cache := NewEPGCache(cfg, mockSourcer, WithChannelNormalizer(chname.NewNormalizer(nil)))
+		guide, err := cache.Get()
+		assert.NoError(t, err)
+		// 混合时 EPG 的频道 ID 已规范化
+		channel, ok := guide.FindChannel("CCTV-1 HD")
+		assert.True(t, ok)
+		assert.Equal(t, "cctv1", channel.ID)
+	})
+}
@@ -0,0 +1,265 @@
+package mixer
+
+import (
+	"crypto/md5" //nolint:gosec // md5 is required by the TvBox jar checksum format
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+// maxJarBytes 单个 jar 的大小上限
+const maxJarBytes = 32 << 20
+
+// JarCache 将 spider 及站点 jar 缓存到本地磁盘，并以 hash 作为访问标识
+type JarCache struct {
+	dir      string
+	interval time.Duration
+	mu       sync.RWMutex
+	entries  map[string]*jarEntry
+	logger   *slog.Logger
+}
+
+type jarEntry struct {
+	URL       string    `json:"url"`
+	MD5       string    `json:"md5"`
+	FetchedAt time.Time `json:"fetched_at"`
+
+	refreshing bool
+	lastError  time.Time
+}
+
+func NewJarCache(dir string, interval time.Duration, logger *slog.Logger) (*JarCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating jar cache dir: %w", err)
+	}
+
+	jc := &JarCache{
+		dir:      dir,
+		interval: interval,
+		entries:  make(map[string]*jarEntry),
+	}
+
+	if logger != nil {
+		jc.logger = logger.With("manager", "jar_cache")
+	}
+
+	jc.loadEntries()
+
+	return jc, nil
+}
+
+func (jc *JarCache) log(format string, args ...any) {
+	if jc.logger != nil {
+		jc.logger.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+// loadEntries 加载磁盘上已缓存的 jar，保证重启后仍可提供服务
+func (jc *JarCache) loadEntries() {
+	metaFiles, err := filepath.Glob(filepath.Join(jc.dir, "*.json"))
+	if err != nil {
+		return
+	}
+
+	for _, metaFile := range metaFiles {
+		data, err := os.ReadFile(metaFile)
+		if err != nil {
+			continue
+		}
+		var entry jarEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			continue
+		}
+		hash := strings.TrimSuffix(filepath.Base(metaFile), ".json")
+		if _, err := os.Stat(jc.jarPath(hash)); err != nil {
+			continue
+		}
+		jc.entries[hash] = &entry
+	}
+}
+
+// JarHash 根据 jar 地址计算缓存标识，忽略地址中的校验信息
+func JarHash(link string) string {
+	sum := md5.Sum([]byte(stripJarChecksum(link))) //nolint:gosec
+	return hex.EncodeToString(sum[:])
+}
+
+// stripJarChecksum 移除 jar 地址中的 ;md5;xxx 校验信息
+func stripJarChecksum(link string) string {
+	return strings.Split(link, ";")[0]
+}
+
+func (jc *JarCache) jarPath(hash string) string {
+	return filepath.Join(jc.dir, hash+".jar")
+}
+
+func (jc *JarCache) metaPath(hash string) string {
+	return filepath.Join(jc.dir, hash+".json")
+}
+
+// Resolve 确保 jar 已被缓存，返回缓存标识与内容的 md5
+// 已缓存但过期时会在后台刷新，并继续返回当前缓存的校验值
+// 仅缓存 http(s) 地址, 上游仓库声明的 file:// 等地址不会被读取
+func (jc *JarCache) Resolve(link string) (string, string, error) {
+	link = stripJarChecksum(link)
+	hash := JarHash(link)
+	if !isHTTPURL(link) {
+		return hash, "", fmt.Errorf("jar %s is not an http link", link)
+	}
+
+	jc.mu.RLock()
+	entry, ok := jc.entries[hash]
+	var checksum string
+	var expired bool
+	if ok {
+		checksum = entry.MD5
+		expired = time.Since(entry.FetchedAt) > jc.interval
+	}
+	jc.mu.RUnlock()
+
+	if !ok || checksum == "" {
+		if err := jc.refresh(hash, link); err != nil {
+			return hash, "", err
+		}
+		jc.mu.RLock()
+		checksum = jc.entries[hash].MD5
+		jc.mu.RUnlock()
+		return hash, checksum, nil
+	}
+
+	if expired {
+		go jc.refresh(hash, link) //nolint:errcheck // 刷新失败时继续使用旧缓存
+	}
+
+	return hash, checksum, nil
+}
+
+// refresh 拉取 jar 并原子地替换缓存文件，失败时保留上一次成功的缓存
+func (jc *JarCache) refresh(hash, link string) (err error) {
+	jc.mu.Lock()
+	entry, ok := jc.entries[hash]
+	if !ok {
+		entry = &jarEntry{URL: link}
+		jc.entries[hash] = entry
+	}
+	if entry.refreshing {
+		jc.mu.Unlock()
+		return nil
+	}
+	// 最近失败过的 jar 至少间隔一分钟再重试，避免上游宕机时每次请求都去拉取
+	if !entry.lastError.IsZero() && time.Since(entry.lastError) < time.Minute {
+		jc.mu.Unlock()
+		return fmt.Errorf("fetching jar %s failed recently, try again later", link)
+	}
+	entry.refreshing = true
+	jc.mu.Unlock()
+
+	defer func() {
+		if err != nil {
+			jc.log("refresh jar %s: %v", link, err)
+		}
+	}()
+
+	data, err := config.FetchDataLimit(link, maxJarBytes)
+
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	entry.refreshing = false
+
+	if err != nil {
+		entry.lastError = time.Now()
+		return err
+	}
+
+	sum := md5.Sum(data) //nolint:gosec
+	checksum := hex.EncodeToString(sum[:])
+
+	tmpFile := jc.jarPath(hash) + ".tmp"
+	if err = os.WriteFile(tmpFile, data, 0o600); err != nil {
+		return fmt.Errorf("writing jar cache: %w", err)
+	}
+	if err = os.Rename(tmpFile, jc.jarPath(hash)); err != nil {
+		return fmt.Errorf("writing jar cache: %w", err)
+	}
+
+	entry.URL = link
+	entry.MD5 = checksum
+	entry.FetchedAt = time.Now()
+	entry.lastError = time.Time{}
+
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding jar meta: %w", err)
+	}
+	if err = os.WriteFile(jc.metaPath(hash), meta, 0o600); err != nil {
+		return fmt.Errorf("writing jar meta: %w", err)
+	}
+
+	return nil
+}
+
+// ServeJar 以 hash 提供缓存的 jar，支持 Range 与 HEAD 请求
+func (jc *JarCache) ServeJar(w http.ResponseWriter, r *http.Request, hash string) {
+	hash = strings.TrimSuffix(hash, ".jar")
+
+	jc.mu.RLock()
+	entry, ok := jc.entries[hash]
+	var link string
+	var expired bool
+	if ok {
+		link = entry.URL
+		expired = time.Since(entry.FetchedAt) > jc.interval
+	}
+	jc.mu.RUnlock()
+
+	if !ok {
+		http.Error(w, "jar not found", http.StatusNotFound)
+		return
+	}
+
+	if expired {
+		go jc.refresh(hash, link) //nolint:errcheck // 刷新失败时继续使用旧缓存
+	}
+
+	f, err := os.Open(jc.jarPath(hash))
+	if err != nil {
+		// 尚未成功缓存过，尝试同步拉取一次
+		if err = jc.refresh(hash, link); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if f, err = os.Open(jc.jarPath(hash)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/java-archive")
+	http.ServeContent(w, r, hash+".jar", stat.ModTime(), f)
+}
+
+// ServeLink 提供指定 jar 地址的缓存内容
+func (jc *JarCache) ServeLink(w http.ResponseWriter, r *http.Request, link string) {
+	hash, _, err := jc.Resolve(link)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	jc.ServeJar(w, r, hash)
+}
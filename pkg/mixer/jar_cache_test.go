@@ -0,0 +1,154 @@
+package mixer
+
+import (
+	"crypto/md5" //nolint:gosec
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+func TestJarCache(t *testing.T) {
+	jarContent := []byte("PK-fake-jar-content")
+	sum := md5.Sum(jarContent) //nolint:gosec
+	expectedMD5 := hex.EncodeToString(sum[:])
+
+	callCount := 0
+	upstreamDown := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+		if upstreamDown {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(jarContent)
+	}))
+	defer upstream.Close()
+
+	jc, err := NewJarCache(t.TempDir(), time.Hour, nil)
+	assert.NoError(t, err)
+
+	link := upstream.URL + "/spider.jar;md5;outdated"
+
+	t.Run("Resolve", func(t *testing.T) {
+		hash, checksum, err := jc.Resolve(link)
+		assert.NoError(t, err)
+		assert.Equal(t, JarHash(upstream.URL+"/spider.jar"), hash)
+		assert.Equal(t, expectedMD5, checksum)
+
+		// 再次解析应直接使用缓存
+		_, _, err = jc.Resolve(link)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, callCount)
+	})
+
+	t.Run("Serve range", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Range", "bytes=0-1")
+		rec := httptest.NewRecorder()
+		jc.ServeJar(rec, req, JarHash(link))
+
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		body, _ := io.ReadAll(rec.Body)
+		assert.Equal(t, "PK", string(body))
+	})
+
+	t.Run("Serve head", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodHead, "/", nil)
+		rec := httptest.NewRecorder()
+		jc.ServeJar(rec, req, JarHash(link)+".jar")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "19", rec.Header().Get("Content-Length"))
+		assert.Empty(t, rec.Body.Bytes())
+	})
+
+	t.Run("Upstream down keeps last good jar", func(t *testing.T) {
+		upstreamDown = true
+		assert.Error(t, jc.refresh(JarHash(link), upstream.URL+"/spider.jar"))
+
+		// 重新加载磁盘缓存，模拟进程重启
+		reloaded, err := NewJarCache(jc.dir, time.Hour, nil)
+		assert.NoError(t, err)
+		_, checksum, err := reloaded.Resolve(link)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedMD5, checksum)
+
+		rec := httptest.NewRecorder()
+		reloaded.ServeJar(rec, httptest.NewRequest(http.MethodGet, "/", nil), JarHash(link))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, jarContent, rec.Body.Bytes())
+	})
+
+	t.Run("Unknown hash", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		jc.ServeJar(rec, httptest.NewRequest(http.MethodGet, "/", nil), "unknown")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Local file", func(t *testing.T) {
+		// 上游仓库声明的本地文件不会被读取和缓存
+		local := "file:///etc/passwd;md5;abc"
+		_, _, err := jc.Resolve(local)
+		assert.Error(t, err)
+		assert.Equal(t, local, (&mixOptions{jarCache: jc}).cacheJar(&config.Config{}, local))
+
+		rec := httptest.NewRecorder()
+		jc.ServeJar(rec, httptest.NewRequest(http.MethodGet, "/", nil), JarHash(local))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestMixRepo_JarCache(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				data: []byte(`{"spider":"` + upstream.URL + `/spider1.jar;md5;abc",` +
+					`"sites":[{"key":"site1","name":"Site 1","type":3}]}`),
+			},
+			"source2": {
+				data: []byte(`{"spider":"` + upstream.URL + `/spider2.jar",` +
+					`"sites":[{"key":"site2","name":"Site 2","type":3}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://proxy.local",
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "source1", Field: "spider"},
+			Sites: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "source1", Field: "sites"}},
+				{MixOpt: config.MixOpt{SourceName: "source2", Field: "sites"}},
+			},
+		},
+	}
+
+	jc, err := NewJarCache(t.TempDir(), time.Hour, nil)
+	assert.NoError(t, err)
+
+	result, err := MixTvBoxRepo(cfg, mockSourcer, WithJarCache(jc))
+	assert.NoError(t, err)
+
+	sum := md5.Sum([]byte("/spider1.jar")) //nolint:gosec
+	assert.Equal(t,
+		"http://proxy.local/v1/tvbox/jar/"+JarHash(upstream.URL+"/spider1.jar")+";md5;"+hex.EncodeToString(sum[:]),
+		result.Spider)
+	assert.Empty(t, result.Sites[0].Jar)
+
+	sum = md5.Sum([]byte("/spider2.jar")) //nolint:gosec
+	assert.Equal(t,
+		"http://proxy.local/v1/tvbox/jar/"+JarHash(upstream.URL+"/spider2.jar")+";md5;"+hex.EncodeToString(sum[:]),
+		result.Sites[1].Jar)
+	assert.Equal(t, []string{"site2"}, result.JarInjectedSites)
+}
@@ -0,0 +1,164 @@
+package mixer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/livetxt"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+// parseChannelPlaylist 将 m3u、live_txt 及 tvbox_single 源中的频道统一转换为 M3U 播放列表
+// 其他类型的源返回 false
+func parseChannelPlaylist(source *Source) (m3u.Playlist, bool, error) {
+	var playlist m3u.Playlist
+	var err error
+
+	switch source.Type() {
+	case config.SourceTypeM3U:
+		playlist, err = config.ParseM3U8Config(bytes.NewReader(source.Data()))
+		if err != nil {
+			return playlist, true, fmt.Errorf("decode media playlist: %w", err)
+		}
+	case config.SourceTypeLiveTXT:
+		playlist, err = parseLiveTXTPlaylist(source.Data())
+		if err != nil {
+			return playlist, true, fmt.Errorf("decode live txt: %w", err)
+		}
+	case config.SourceTypeTvBoxSingle:
+		// 旧版 TvBox 单仓直接在 lives 中内嵌频道
+		playlist, err = parseTvBoxLivesPlaylist(source.Data())
+		if err != nil {
+			return playlist, true, fmt.Errorf("decode tvbox lives: %w", err)
+		}
+	default:
+		return playlist, false, nil
+	}
+
+	return playlist, true, nil
+}
+
+// parseLiveTXTPlaylist 将 TXT 直播源列表转换为 M3U 播放列表
+func parseLiveTXTPlaylist(data []byte) (m3u.Playlist, error) {
+	playlist := m3u.NewPlaylist()
+
+	var txt livetxt.Playlist
+	if err := livetxt.Unmarshal(data, &txt); err != nil {
+		return playlist, err
+	}
+
+	for _, group := range txt.Groups {
+		live := config.TvBoxLive{Group: group.Name}
+		for _, channel := range group.Channels {
+			live.Channels = append(live.Channels, config.TvBoxLiveChannel{Name: channel.Name, URLs: channel.URLs})
+		}
+		playlist.Tracks = append(playlist.Tracks, liveToTracks(live)...)
+	}
+
+	return playlist, nil
+}
+
+// playlistToLiveTXT 按 group-title 将轨道分组，同一分组内同名的轨道合并为一个频道的多个地址
+// 分组与频道保持首次出现的顺序
+func playlistToLiveTXT(playlist *m3u.Playlist) livetxt.Playlist {
+	var result livetxt.Playlist
+	groupIndex := make(map[string]int)
+	channelIndex := make(map[[2]string]int)
+
+	for _, track := range playlist.Tracks {
+		if track.URI == "" {
+			continue
+		}
+		groupName := track.GetTag("group-title")
+		gi, ok := groupIndex[groupName]
+		if !ok {
+			gi = len(result.Groups)
+			groupIndex[groupName] = gi
+			result.Groups = append(result.Groups, livetxt.Group{Name: groupName})
+		}
+		group := &result.Groups[gi]
+
+		key := [2]string{groupName, track.Name}
+		if ci, ok := channelIndex[key]; ok {
+			group.Channels[ci].URLs = append(group.Channels[ci].URLs, track.URI)
+			continue
+		}
+		channelIndex[key] = len(group.Channels)
+		group.Channels = append(group.Channels, livetxt.Channel{Name: track.Name, URLs: []string{track.URI}})
+	}
+
+	return result
+}
+
+// playlistToLives 将 M3U 播放列表转换为旧版内嵌 group/channels 的 lives 条目
+func playlistToLives(playlist *m3u.Playlist) []config.TvBoxLive {
+	var lives []config.TvBoxLive
+	for _, group := range playlistToLiveTXT(playlist).Groups {
+		live := config.TvBoxLive{Group: group.Name}
+		for _, channel := range group.Channels {
+			live.Channels = append(live.Channels, config.TvBoxLiveChannel{Name: channel.Name, URLs: channel.URLs})
+		}
+		lives = append(lives, live)
+	}
+	return lives
+}
+
+// parseTvBoxLivesPlaylist 将 TvBox 单仓中内嵌的 lives 频道转换为 M3U 播放列表
+func parseTvBoxLivesPlaylist(data []byte) (m3u.Playlist, error) {
+	playlist := m3u.NewPlaylist()
+
+	lives := gjson.GetBytes(data, "lives")
+	if !lives.Exists() || !lives.IsArray() {
+		return playlist, nil
+	}
+
+	for _, item := range lives.Array() {
+		var live config.TvBoxLive
+		if err := json.Unmarshal([]byte(item.Raw), &live); err != nil {
+			return playlist, fmt.Errorf("unmarshal live: %w", err)
+		}
+		playlist.Tracks = append(playlist.Tracks, liveToTracks(live)...)
+	}
+
+	return playlist, nil
+}
+
+// liveToTracks 将旧版 lives 条目中的 group/channels 转换为 M3U 轨道
+// 一个频道有多个地址时，每个地址输出为一个同名轨道
+func liveToTracks(live config.TvBoxLive) []m3u.Track {
+	var tracks []m3u.Track
+	for _, channel := range live.Channels {
+		for _, uri := range channel.URLs {
+			track := m3u.Track{
+				Name:     channel.Name,
+				Duration: -1,
+				URI:      uri,
+				Tags:     make([]m3u.Tag, 0, 2),
+			}
+			track.SetTag("tvg-name", channel.Name)
+			if live.Group != "" {
+				track.SetTag("group-title", live.Group)
+			}
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks
+}
+
+// newM3ULive 生成指向本服务 M3U 媒体播放列表的 lives 条目
+func newM3ULive(cfg *config.Config) config.TvBoxLive {
+	name := cfg.TvBoxSingleRepoOpt.M3ULive.Name
+	if name == "" {
+		name = "Tv MixProxy"
+	}
+
+	return config.TvBoxLive{
+		Name: name,
+		Type: new(config.FlexInt), // type 0 为 M3U/TXT 直播源
+		URL:  getExternalURL(cfg) + "/v1/m3u/media_playlist",
+	}
+}
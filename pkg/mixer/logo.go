@@ -0,0 +1,401 @@
+package mixer
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // md5 is only used to derive cache keys
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"  // 注册 gif 解码器
+	_ "image/jpeg" // 注册 jpeg 解码器
+	"image/png"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/chname"
+	"github.com/wayjam/tv-mixproxy/pkg/epg"
+	"github.com/wayjam/tv-mixproxy/pkg/imageutil"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+const (
+	maxLogoBytes  = 5 << 20     // 单个台标的最大字节数
+	maxLogoPixels = 4096 * 4096 // 解码台标前允许的最大像素数, 避免上游图片声明超大尺寸耗尽内存
+)
+
+// logoExts 为本地台标目录中识别的图片扩展名
+var logoExts = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true}
+
+// LogoManager 为频道补全台标, 并以 /v1/logos/{id} 提供缓存及缩放后的台标
+type LogoManager struct {
+	cfg      *config.Config
+	interval time.Duration
+	local    map[string]string // 规范化后的频道名称 -> 本地台标路径
+	mu       sync.RWMutex
+	entries  map[string]*logoEntry
+	logger   *slog.Logger
+}
+
+type logoEntry struct {
+	URL         string    `json:"url"` // 台标地址, 本地台标为 file:// 地址
+	ContentType string    `json:"content_type"`
+	FetchedAt   time.Time `json:"fetched_at"`
+
+	refreshing bool
+	lastError  time.Time
+}
+
+func NewLogoManager(cfg *config.Config, logger *slog.Logger) (*LogoManager, error) {
+	opt := cfg.LogoOpt
+	if err := os.MkdirAll(opt.CacheDir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating logo cache dir: %w", err)
+	}
+
+	m := &LogoManager{
+		cfg:      cfg,
+		interval: time.Duration(opt.Interval) * time.Second,
+		local:    make(map[string]string),
+		entries:  make(map[string]*logoEntry),
+	}
+
+	if logger != nil {
+		m.logger = logger.With("manager", "logo")
+	}
+
+	if opt.Dir != "" {
+		files, err := os.ReadDir(opt.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("reading logo dir: %w", err)
+		}
+		for _, file := range files {
+			ext := strings.ToLower(filepath.Ext(file.Name()))
+			if file.IsDir() || !logoExts[ext] {
+				continue
+			}
+			key := chname.Normalize(strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))).ID
+			if _, ok := m.local[key]; !ok {
+				m.local[key] = filepath.Join(opt.Dir, file.Name())
+			}
+		}
+	}
+
+	m.loadEntries()
+
+	return m, nil
+}
+
+func (m *LogoManager) log(format string, args ...any) {
+	if m.logger != nil {
+		m.logger.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+// loadEntries 加载磁盘上已缓存的台标，保证重启后仍可提供服务
+func (m *LogoManager) loadEntries() {
+	metaFiles, err := filepath.Glob(filepath.Join(m.cfg.LogoOpt.CacheDir, "*.json"))
+	if err != nil {
+		return
+	}
+
+	for _, metaFile := range metaFiles {
+		data, err := os.ReadFile(metaFile)
+		if err != nil {
+			continue
+		}
+		var entry logoEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			continue
+		}
+		id := strings.TrimSuffix(filepath.Base(metaFile), ".json")
+		if _, err := os.Stat(m.dataPath(id)); err != nil {
+			continue
+		}
+		m.entries[id] = &entry
+	}
+}
+
+// LogoID 根据台标地址计算缓存标识
+func LogoID(link string) string {
+	sum := md5.Sum([]byte(link)) //nolint:gosec
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *LogoManager) dataPath(id string) string {
+	return filepath.Join(m.cfg.LogoOpt.CacheDir, id+".img")
+}
+
+func (m *LogoManager) metaPath(id string) string {
+	return filepath.Join(m.cfg.LogoOpt.CacheDir, id+".json")
+}
+
+// logoURL 返回本服务提供台标的地址
+func logoURL(cfg *config.Config, id string) string {
+	return getExternalURL(cfg) + "/v1/logos/" + id
+}
+
+// register 登记台标地址并返回本服务提供该台标的地址
+func (m *LogoManager) register(link string) string {
+	id := LogoID(link)
+	m.mu.Lock()
+	if _, ok := m.entries[id]; !ok {
+		m.entries[id] = &logoEntry{URL: link}
+	}
+	m.mu.Unlock()
+	return logoURL(m.cfg, id)
+}
+
+// logoIndex 为 EPG 中带 icon 的频道索引, key 为小写的频道 ID 及规范化后的频道名称
+type logoIndex map[string]string
+
+func newLogoIndex(guide *epg.EPG) logoIndex {
+	index := make(logoIndex)
+	if guide == nil {
+		return index
+	}
+	for _, channel := range guide.Channel {
+		if channel.Icon == nil || channel.Icon.Src == "" {
+			continue
+		}
+		for _, key := range []string{
+			strings.ToLower(channel.ID),
+			chname.Normalize(channel.ID).ID,
+			chname.Normalize(channel.DisplayName.Text).ID,
+		} {
+			if _, ok := index[key]; key != "" && !ok {
+				index[key] = channel.Icon.Src
+			}
+		}
+	}
+	return index
+}
+
+// channelLogoKeys 返回用于匹配台标的频道标识
+func channelLogoKeys(name, tvgID string, normalizer *chname.Normalizer) []string {
+	var keys []string
+	if tvgID != "" {
+		keys = append(keys, strings.ToLower(tvgID), chname.Normalize(tvgID).ID)
+	}
+	if name != "" {
+		keys = append(keys, chname.Normalize(name).ID)
+		if normalizer != nil {
+			keys = append(keys, normalizer.Normalize(name).ID)
+		}
+	}
+	return keys
+}
+
+// resolve 返回频道最终使用的台标地址
+// 没有台标时依次使用本地台标目录与 EPG 中的 icon, 本地台标及启用 proxy 时的远程台标改写为本服务的地址
+// 均未找到且启用 placeholder 时使用占位台标
+func (m *LogoManager) resolve(name, tvgID, current string, icons logoIndex, normalizer *chname.Normalizer) string {
+	logo := current
+	if logo == "" {
+		keys := channelLogoKeys(name, tvgID, normalizer)
+		for _, key := range keys {
+			if path, ok := m.local[key]; ok {
+				return m.register("file://" + path)
+			}
+		}
+		for _, key := range keys {
+			if src, ok := icons[key]; ok {
+				logo = src
+				break
+			}
+		}
+	}
+
+	if logo != "" && m.cfg.LogoOpt.Proxy && isHTTPURL(logo) &&
+		!strings.HasPrefix(logo, getExternalURL(m.cfg)+"/v1/logos/") {
+		return m.register(logo)
+	}
+	if logo == "" && name != "" && m.cfg.LogoOpt.Placeholder {
+		return badgeURL(m.cfg, name)
+	}
+	return logo
+}
+
+// badgeURL 返回频道占位台标的地址
+func badgeURL(cfg *config.Config, name string) string {
+	return getExternalURL(cfg) + "/v1/logos/badge?ch=" + url.QueryEscape(name)
+}
+
+// enrichTracks 为轨道补全或改写 tvg-logo
+func (m *LogoManager) enrichTracks(tracks []m3u.Track, icons logoIndex, normalizer *chname.Normalizer) {
+	for i := range tracks {
+		track := &tracks[i]
+		logo := m.resolve(track.Name, track.GetTag("tvg-id"), track.GetTag("tvg-logo"), icons, normalizer)
+		if logo != "" {
+			track.SetTag("tvg-logo", logo)
+		}
+	}
+}
+
+// Lookup 返回指定频道的台标地址, 供 TvBox lives 的台标接口使用
+func (m *LogoManager) Lookup(name string, guide *MixedEPG, opts ...MixOption) (string, bool) {
+	o := newMixOptions(opts)
+	logo := m.resolve(name, "", "", guide.logoIndex(), o.normalizer)
+	return logo, logo != ""
+}
+
+// ServeLogo 以 id 提供缓存的台标, 过期时在后台刷新, 尚未缓存时同步拉取
+func (m *LogoManager) ServeLogo(w http.ResponseWriter, r *http.Request, id string) {
+	id = strings.TrimSuffix(id, filepath.Ext(id))
+
+	m.mu.RLock()
+	entry, ok := m.entries[id]
+	var link, contentType string
+	var cached, expired bool
+	if ok {
+		link, contentType = entry.URL, entry.ContentType
+		cached = !entry.FetchedAt.IsZero()
+		expired = time.Since(entry.FetchedAt) > m.interval
+	}
+	m.mu.RUnlock()
+
+	if !ok {
+		http.Error(w, "logo not found", http.StatusNotFound)
+		return
+	}
+
+	if !cached {
+		if err := m.refresh(id, link); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		m.mu.RLock()
+		contentType = entry.ContentType
+		m.mu.RUnlock()
+	} else if expired {
+		go m.refresh(id, link) //nolint:errcheck // 刷新失败时继续使用旧缓存
+	}
+
+	f, err := os.Open(m.dataPath(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	// 上游台标由本服务的域名提供, 禁止其中的脚本与外部资源, 对旧版本缓存的非图片内容同样生效
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(m.interval.Seconds())))
+	http.ServeContent(w, r, id, stat.ModTime(), f)
+}
+
+// refresh 拉取台标并写入缓存, 可解码的图片会被缩放并转换为 PNG, 其余图片格式 (如 svg) 原样保存, 非图片内容被拒绝
+func (m *LogoManager) refresh(id, link string) (err error) {
+	m.mu.Lock()
+	entry := m.entries[id]
+	if entry.refreshing || time.Since(entry.lastError) < time.Minute {
+		cached := !entry.FetchedAt.IsZero()
+		m.mu.Unlock()
+		if !cached {
+			return fmt.Errorf("logo %s is not available", link)
+		}
+		return nil
+	}
+	entry.refreshing = true
+	m.mu.Unlock()
+
+	defer func() {
+		if err != nil {
+			m.log("refresh logo %s: %v", link, err)
+		}
+	}()
+
+	var contentType string
+	data, err := config.FetchDataLimit(link, maxLogoBytes)
+	if err == nil {
+		data, err = m.process(data)
+	}
+	if err == nil {
+		contentType, err = logoContentType(data)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry.refreshing = false
+
+	if err != nil {
+		entry.lastError = time.Now()
+		return err
+	}
+
+	tmpFile := m.dataPath(id) + ".tmp"
+	if err = os.WriteFile(tmpFile, data, 0o600); err != nil {
+		return fmt.Errorf("writing logo cache: %w", err)
+	}
+	if err = os.Rename(tmpFile, m.dataPath(id)); err != nil {
+		return fmt.Errorf("writing logo cache: %w", err)
+	}
+
+	entry.ContentType = contentType
+	entry.FetchedAt = time.Now()
+	entry.lastError = time.Time{}
+
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding logo meta: %w", err)
+	}
+	if err = os.WriteFile(m.metaPath(id), meta, 0o600); err != nil {
+		return fmt.Errorf("writing logo meta: %w", err)
+	}
+
+	return nil
+}
+
+// logoContentType 识别台标的类型, 上游返回的 HTML 等非图片内容会被拒绝, 避免以本服务的域名提供
+func logoContentType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if strings.HasPrefix(contentType, "image/") {
+		return contentType, nil
+	}
+	// svg 被识别为 text/xml 或 text/plain
+	if (strings.HasPrefix(contentType, "text/xml") || strings.HasPrefix(contentType, "text/plain")) &&
+		bytes.Contains(data, []byte("<svg")) {
+		return "image/svg+xml", nil
+	}
+	return "", fmt.Errorf("logo is not an image: %s", contentType)
+}
+
+// process 缩放可解码的图片, 无法解码的图片原样返回, 尺寸超过 maxLogoPixels 的图片被拒绝
+func (m *LogoManager) process(data []byte) ([]byte, error) {
+	imgCfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return data, nil //nolint:nilerr // svg、webp 等无法解码的格式原样提供
+	}
+	if int64(imgCfg.Width)*int64(imgCfg.Height) > maxLogoPixels {
+		return nil, fmt.Errorf("logo is too large: %dx%d", imgCfg.Width, imgCfg.Height)
+	}
+	if m.cfg.LogoOpt.Size < 0 ||
+		(imgCfg.Width <= m.cfg.LogoOpt.Size && imgCfg.Height <= m.cfg.LogoOpt.Size) {
+		return data, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding logo: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, imageutil.Fit(img, m.cfg.LogoOpt.Size)); err != nil {
+		return nil, fmt.Errorf("encoding logo: %w", err)
+	}
+	return buf.Bytes(), nil
+}
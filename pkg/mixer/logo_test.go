@@ -0,0 +1,232 @@
+package mixer
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+func testPNG(t *testing.T, size int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, size, size/2))
+	for y := 0; y < size/2; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestLogoManager(t *testing.T) {
+	requests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/hunan.png":
+			w.Write(testPNG(t, 512))
+		case "/cgtn.svg":
+			w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`))
+		case "/page.png":
+			w.Write([]byte(`<html><body><svg></svg><script>alert(1)</script></body></html>`))
+		case "/huge.gif":
+			// 只有声明了 65535x65535 尺寸的 gif 头部
+			w.Write([]byte("GIF89a\xff\xff\xff\xff\x00\x00\x00"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	logoDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(logoDir, "CCTV-1.png"), testPNG(t, 64), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(logoDir, "readme.txt"), []byte("ignored"), 0o644))
+
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"m3u": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1,CCTV1 综合\nhttp://a/cctv1.m3u8\n" +
+					"#EXTINF:-1 tvg-id=\"hunan\",湖南卫视\nhttp://a/hunan.m3u8\n" +
+					"#EXTINF:-1 tvg-logo=\"" + upstream.URL + "/cgtn.svg\",CGTN\nhttp://a/cgtn.m3u8\n" +
+					"#EXTINF:-1,未知频道\nhttp://a/unknown.m3u8\n"),
+			},
+			"epg": {
+				config: config.Source{Type: config.SourceTypeEPG},
+				data: []byte(`<tv>
+    <channel id="HUNAN"><display-name>湖南卫视 HD</display-name><icon src="` + upstream.URL + `/hunan.png"/></channel>
+</tv>`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://proxy",
+		M3UOpt: config.M3UOpt{
+			MediaPlaylistFilters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "m3u"}},
+			},
+		},
+		EPGOpt: config.EPGOpt{
+			Filters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "epg"}, FilterBy: string(config.EPGFilterTypeChannelID)},
+			},
+		},
+		LogoOpt: config.LogoOpt{
+			FromEPG:  true,
+			Dir:      logoDir,
+			Proxy:    true,
+			CacheDir: t.TempDir(),
+			Size:     128,
+		},
+	}
+	cfg.Fixture()
+	cfg.EPGOpt.M3UTvgURL = false
+
+	logos, err := NewLogoManager(cfg, nil)
+	assert.NoError(t, err)
+
+	result, err := MixM3UMediaPlayList(cfg, mockSourcer, WithLogoManager(logos))
+	assert.NoError(t, err)
+
+	localURL := "http://proxy/v1/logos/" + LogoID("file://"+filepath.Join(logoDir, "CCTV-1.png"))
+	hunanURL := "http://proxy/v1/logos/" + LogoID(upstream.URL+"/hunan.png")
+	cgtnURL := "http://proxy/v1/logos/" + LogoID(upstream.URL+"/cgtn.svg")
+	assert.Equal(t, localURL, result.Tracks[0].GetTag("tvg-logo"))
+	assert.Equal(t, hunanURL, result.Tracks[1].GetTag("tvg-logo"))
+	assert.Equal(t, cgtnURL, result.Tracks[2].GetTag("tvg-logo"))
+	assert.Equal(t, "", result.Tracks[3].GetTag("tvg-logo"))
+
+	serve := func(link string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		logos.ServeLogo(w, httptest.NewRequest(http.MethodGet, link, nil), strings.TrimPrefix(link, "http://proxy/v1/logos/"))
+		return w
+	}
+
+	t.Run("Resize", func(t *testing.T) {
+		w := serve(hunanURL)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Security-Policy"), "sandbox")
+		img, err := png.Decode(w.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 128, 64), img.Bounds())
+
+		// 已缓存的台标不再请求上游
+		before := requests
+		assert.Equal(t, http.StatusOK, serve(hunanURL).Code)
+		assert.Equal(t, before, requests)
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		w := serve(localURL)
+		assert.Equal(t, http.StatusOK, w.Code)
+		img, err := png.Decode(w.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 64, 32), img.Bounds())
+	})
+
+	t.Run("SVG", func(t *testing.T) {
+		w := serve(cgtnURL)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Security-Policy"), "sandbox")
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		link := logos.register(upstream.URL + "/huge.gif")
+		w := serve(link)
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Contains(t, w.Body.String(), "too large")
+	})
+
+	t.Run("NotImage", func(t *testing.T) {
+		link := logos.register(upstream.URL + "/page.png")
+		w := serve(link)
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Contains(t, w.Body.String(), "not an image")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve("http://proxy/v1/logos/unknown").Code)
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		guide, err := NewEPGCache(cfg, mockSourcer).Get()
+		assert.NoError(t, err)
+		logo, ok := logos.Lookup("湖南卫视", guide)
+		assert.True(t, ok)
+		assert.Equal(t, hunanURL, logo)
+		logo, ok = logos.Lookup("CCTV1", nil)
+		assert.True(t, ok)
+		assert.Equal(t, localURL, logo)
+		_, ok = logos.Lookup("未知频道", guide)
+		assert.False(t, ok)
+	})
+
+	t.Run("EPGCache", func(t *testing.T) {
+		result, err := MixM3UMediaPlayList(cfg, mockSourcer,
+			WithLogoManager(logos), WithEPGCache(NewEPGCache(cfg, mockSourcer)))
+		assert.NoError(t, err)
+		assert.Equal(t, hunanURL, result.Tracks[1].GetTag("tvg-logo"))
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		restarted, err := NewLogoManager(cfg, nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		restarted.ServeLogo(w, httptest.NewRequest(http.MethodGet, hunanURL, nil), LogoID(upstream.URL+"/hunan.png"))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestLogoManager_Placeholder(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"m3u": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1 tvg-logo=\"http://a/cctv1.png\",CCTV1\nhttp://a/cctv1.m3u8\n" +
+					"#EXTINF:-1,湖南 卫视\nhttp://a/hunan.m3u8\n"),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://proxy",
+		M3UOpt: config.M3UOpt{
+			MediaPlaylistFilters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "m3u"}},
+			},
+		},
+		EPGOpt:  config.EPGOpt{Disable: true},
+		LogoOpt: config.LogoOpt{Placeholder: true, CacheDir: t.TempDir()},
+	}
+	cfg.Fixture()
+	assert.True(t, cfg.LogoOpt.Enabled())
+
+	logos, err := NewLogoManager(cfg, nil)
+	assert.NoError(t, err)
+
+	result, err := MixM3UMediaPlayList(cfg, mockSourcer, WithLogoManager(logos))
+	assert.NoError(t, err)
+	assert.Equal(t, "http://a/cctv1.png", result.Tracks[0].GetTag("tvg-logo"))
+	assert.Equal(t, "http://proxy/v1/logos/badge?ch=%E6%B9%96%E5%8D%97+%E5%8D%AB%E8%A7%86", result.Tracks[1].GetTag("tvg-logo"))
+
+	logo, ok := logos.Lookup("CGTN", nil)
+	assert.True(t, ok)
+	assert.Equal(t, "http://proxy/v1/logos/badge?ch=CGTN", logo)
+}
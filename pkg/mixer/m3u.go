@@ -3,18 +3,21 @@ package mixer
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	"github.com/wayjam/tv-mixproxy/config"
 	"github.com/wayjam/tv-mixproxy/pkg/m3u"
 )
 
 func MixM3UMediaPlayList(
-	cfg *config.Config, sourcer Sourcer,
+	cfg *config.Config, sourcer Sourcer, opts ...MixOption,
 ) (*m3u.Playlist, error) {
 	if cfg.M3UOpt.Disable {
 		return nil, nil
 	}
 
+	o := newMixOptions(opts)
+
 	result := m3u.NewPlaylist()
 
 	if cfg.M3UOpt.MediaPlaylistFallback.SourceName != "" {
@@ -33,45 +36,88 @@ func MixM3UMediaPlayList(
 		}
 	}
 
+	var tracks []sourcedTrack
 	for _, filter := range cfg.M3UOpt.MediaPlaylistFilters {
 		source, err := sourcer.GetSource(filter.SourceName)
 		if err != nil {
 			return nil, fmt.Errorf("get source %s: %w", filter.SourceName, err)
 		}
 
-		if source.Type() != config.SourceTypeM3U {
-			continue
-		}
-
-		playlist, err := config.ParseM3U8Config(bytes.NewReader(source.Data()))
+		playlist, ok, err := parseChannelPlaylist(source)
 		if err != nil {
-			return nil, fmt.Errorf("decode media playlist: %w", err)
+			return nil, err
+		}
+		if !ok {
+			continue
 		}
 
-		includeRegex := compileRegex(filter.Include)
-		excludeRegex := compileRegex(filter.Exclude)
+		trackFilter := newTrackFilter(filter)
 
 		for i := range playlist.Tracks {
 			track := playlist.Tracks[i]
-			if filter.FilterBy != "" {
-				if !matchFilter(track.Name, includeRegex, excludeRegex) {
-					continue
-				}
+			o.normalizeTrack(&track)
+			if !trackFilter.matchTrack(&track) {
+				continue
 			}
 
-			result.Tracks = append(result.Tracks, track)
+			tracks = append(tracks, sourcedTrack{track: track, source: filter.SourceName})
 		}
 
 		for i := range playlist.VariantStreams {
 			variant := playlist.VariantStreams[i]
-			if filter.FilterBy != "" {
-				if !matchFilter(variant.Name, includeRegex, excludeRegex) {
-					continue
-				}
+			if !trackFilter.matchVariant(&variant) {
+				continue
 			}
 			result.VariantStreams = append(result.VariantStreams, variant)
 		}
 	}
 
+	healthCheckOpt := cfg.M3UOpt.HealthCheck
+	if o.streamProber != nil && healthCheckOpt.DropDead {
+		alive := tracks[:0]
+		for _, st := range tracks {
+			if o.streamProber.alive(st.track.URI) {
+				alive = append(alive, st)
+			}
+		}
+		tracks = alive
+	}
+
+	if cfg.M3UOpt.Dedup.Enable {
+		var latency func(uri string) (time.Duration, bool)
+		if o.streamProber != nil && healthCheckOpt.SortAlternates {
+			latency = o.streamProber.ttfb
+		}
+		result.Tracks = dedupTracks(tracks, cfg.M3UOpt.Dedup, latency)
+	} else {
+		for _, st := range tracks {
+			result.Tracks = append(result.Tracks, st.track)
+		}
+	}
+
+	result.Tracks = applyGroupRules(result.Tracks, cfg.M3UOpt.Groups)
+	if o.numberer != nil {
+		o.numberer.Assign(result.Tracks)
+	}
+	if o.logos != nil {
+		var guide *MixedEPG
+		if cfg.LogoOpt.FromEPG && !cfg.EPGOpt.Disable {
+			// EPG 仅用于补全台标, 混合失败时不影响播放列表
+			if o.epgCache != nil {
+				guide, _ = o.epgCache.Get()
+			} else if result, err := MixEPG(cfg, sourcer, opts...); err == nil {
+				guide = newMixedEPG(result, opts...)
+			}
+		}
+		o.logos.enrichTracks(result.Tracks, guide.logoIndex(), o.normalizer)
+	}
+	if o.relay != nil {
+		o.relay.relayTracks(result.Tracks)
+	}
+
+	if !cfg.EPGOpt.Disable && cfg.EPGOpt.M3UTvgURL {
+		result.SetTag("x-tvg-url", epgURL(cfg))
+	}
+
 	return &result, nil
 }
@@ -0,0 +1,204 @@
+package mixer
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+// trackFilter 为 M3U 轨道与变体流的过滤条件
+type trackFilter struct {
+	conditions []trackCondition
+	any        bool
+}
+
+type trackCondition struct {
+	field            string
+	scope            filterScope
+	include, exclude *regexp.Regexp
+	min, max         float64
+}
+
+// filterScope 为过滤条件适用的条目类型
+type filterScope int
+
+const (
+	scopeAll     filterScope = iota // 轨道与变体流
+	scopeTrack                      // 仅轨道, 如 group-title 等标签
+	scopeVariant                    // 仅变体流, 如 bandwidth、resolution 等属性
+)
+
+// variantFields 为变体流的属性名
+var variantFields = map[string]bool{
+	"bandwidth":         true,
+	"average-bandwidth": true,
+	"resolution":        true,
+	"frame-rate":        true,
+	"codecs":            true,
+	"hdcp-level":        true,
+	"video":             true,
+	"audio":             true,
+	"subtitles":         true,
+	"closed-captions":   true,
+}
+
+// trackTagFields 为不含连字符的常见轨道标签名, 其余标签名均含连字符 (如 group-title、tvg-id)
+var trackTagFields = map[string]bool{
+	"catchup":   true,
+	"timeshift": true,
+	"radio":     true,
+}
+
+// parseFilterField 解析 filter_by 及其适用的条目类型
+// 旧版本的 filter_by 只是开关, 总是按名称过滤, 无法识别的值 (如 title) 按 name 处理
+func parseFilterField(filterBy string) (string, filterScope) {
+	field := strings.ToLower(strings.TrimSpace(filterBy))
+	switch field {
+	case "name", "uri":
+		return field, scopeAll
+	case "uri_host", "uri-host":
+		return "uri_host", scopeAll
+	}
+	// 属性名与标签名均使用连字符, 下划线写法 (如 group_title、tvg_id) 同样可以识别
+	attr := strings.ReplaceAll(field, "_", "-")
+	if variantFields[attr] {
+		return attr, scopeVariant
+	}
+	if strings.Contains(attr, "-") || trackTagFields[attr] {
+		return attr, scopeTrack
+	}
+	return "name", scopeAll
+}
+
+// newTrackFilter 根据 filter_by/include/exclude 与 conditions 创建过滤条件
+// filter_by 为空且没有 conditions 时不过滤
+func newTrackFilter(opt config.ArrayMixOpt) *trackFilter {
+	f := &trackFilter{any: opt.Match == config.FilterMatchAny}
+
+	conditions := opt.Conditions
+	if opt.FilterBy != "" {
+		conditions = append([]config.FilterCondition{{
+			FilterBy: opt.FilterBy,
+			Include:  opt.Include,
+			Exclude:  opt.Exclude,
+		}}, conditions...)
+	}
+	for _, c := range conditions {
+		field, scope := parseFilterField(c.FilterBy)
+		f.conditions = append(f.conditions, trackCondition{
+			field:   field,
+			scope:   scope,
+			include: compileRegex(c.Include),
+			exclude: compileRegex(c.Exclude),
+			min:     c.Min,
+			max:     c.Max,
+		})
+	}
+
+	return f
+}
+
+func (f *trackFilter) matchTrack(track *m3u.Track) bool {
+	return f.match(scopeTrack, func(field string) string {
+		switch field {
+		case "name":
+			return track.Name
+		case "uri":
+			return track.URI
+		case "uri_host":
+			return uriHost(track.URI)
+		default:
+			return track.GetTag(field)
+		}
+	})
+}
+
+func (f *trackFilter) matchVariant(stream *m3u.VariantStream) bool {
+	return f.match(scopeVariant, func(field string) string {
+		switch field {
+		case "name":
+			return stream.Name
+		case "uri":
+			return stream.URI
+		case "uri_host":
+			return uriHost(stream.URI)
+		case "bandwidth":
+			return strconv.Itoa(stream.Bandwidth)
+		case "average-bandwidth":
+			return strconv.Itoa(stream.AverageBandwith)
+		case "resolution":
+			return stream.Resolution
+		case "frame-rate":
+			return strconv.FormatFloat(stream.FrameRate, 'f', -1, 64)
+		case "codecs":
+			return stream.Codecs
+		case "hdcp-level":
+			return stream.HdcpLevel
+		case "video":
+			return stream.Video
+		case "audio":
+			return stream.Audio
+		case "subtitles":
+			return stream.Subtitle
+		case "closed-captions":
+			return stream.ClosedCaptions
+		}
+		return ""
+	})
+}
+
+// match 只使用适用于该条目类型的条件, 没有适用的条件时不过滤
+func (f *trackFilter) match(scope filterScope, value func(field string) string) bool {
+	applied := false
+	for i := range f.conditions {
+		c := &f.conditions[i]
+		if c.scope != scopeAll && c.scope != scope {
+			continue
+		}
+		applied = true
+		ok := c.match(value(c.field))
+		if f.any && ok {
+			return true
+		}
+		if !f.any && !ok {
+			return false
+		}
+	}
+	return !applied || !f.any
+}
+
+func (c *trackCondition) match(value string) bool {
+	if !matchFilter(value, c.include, c.exclude) {
+		return false
+	}
+	if c.min == 0 && c.max == 0 {
+		return true
+	}
+	n, ok := numericValue(value)
+	if !ok {
+		return false
+	}
+	return (c.min == 0 || n >= c.min) && (c.max == 0 || n <= c.max)
+}
+
+// numericValue 将属性值解析为数值, WIDTHxHEIGHT 格式的分辨率取高度
+func numericValue(value string) (float64, bool) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if _, height, ok := strings.Cut(strings.ToLower(value), "x"); ok {
+		value = height
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	return n, err == nil
+}
+
+func uriHost(uri string) string {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,159 @@
+package mixer
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+const channelSortName = "name"
+
+type groupAssignRule struct {
+	match *regexp.Regexp
+	group string
+}
+
+type groupRenameRule struct {
+	match *regexp.Regexp
+	name  string
+}
+
+// applyGroupRules 按分组规则重命名、合并并排列轨道
+// 排序是稳定的, 去重后紧随主轨道的备用轨道与主轨道名称相同, 排序后仍然相邻
+func applyGroupRules(tracks []m3u.Track, opt config.M3UGroupOpt) []m3u.Track {
+	var assigns []groupAssignRule
+	for _, rule := range opt.Assign {
+		if re := compileRegex(rule.Match); re != nil {
+			assigns = append(assigns, groupAssignRule{match: re, group: rule.Group})
+		}
+	}
+	var renames []groupRenameRule
+	for _, rule := range opt.Rename {
+		if re := compileRegex(rule.Match); re != nil {
+			renames = append(renames, groupRenameRule{match: re, name: rule.Name})
+		}
+	}
+	if len(assigns) == 0 && len(renames) == 0 && len(opt.Order) == 0 &&
+		len(opt.ChannelOrder) == 0 && opt.ChannelSort == "" {
+		return tracks
+	}
+
+	for i := range tracks {
+		group, ok := tracks[i].GetTag("group-title"), false
+		for _, rule := range assigns {
+			if rule.match.MatchString(tracks[i].Name) {
+				group, ok = rule.group, true
+				break
+			}
+		}
+		if !ok {
+			group = renameGroup(group, renames)
+		}
+		if group != "" || tracks[i].GetTag("group-title") != "" {
+			tracks[i].SetTag("group-title", group)
+		}
+	}
+
+	// 分组顺序: 列出的分组在前, 其余按首次出现的顺序
+	groupRank := make(map[string]int, len(opt.Order))
+	for i, name := range opt.Order {
+		if _, ok := groupRank[name]; !ok {
+			groupRank[name] = i
+		}
+	}
+	next := len(opt.Order)
+	for i := range tracks {
+		if group := tracks[i].GetTag("group-title"); !hasKey(groupRank, group) {
+			groupRank[group] = next
+			next++
+		}
+	}
+
+	channelOrders := make(map[string][]*regexp.Regexp, len(opt.ChannelOrder))
+	for _, order := range opt.ChannelOrder {
+		for _, pattern := range order.Channels {
+			if re := compileRegex(pattern); re != nil {
+				channelOrders[order.Group] = append(channelOrders[order.Group], re)
+			}
+		}
+	}
+	channelRank := func(track *m3u.Track) int {
+		patterns := channelOrders[track.GetTag("group-title")]
+		for i, re := range patterns {
+			if re.MatchString(track.Name) {
+				return i
+			}
+		}
+		return len(patterns)
+	}
+
+	sort.SliceStable(tracks, func(i, j int) bool {
+		a, b := &tracks[i], &tracks[j]
+		if ga, gb := groupRank[a.GetTag("group-title")], groupRank[b.GetTag("group-title")]; ga != gb {
+			return ga < gb
+		}
+		if ca, cb := channelRank(a), channelRank(b); ca != cb {
+			return ca < cb
+		}
+		if opt.ChannelSort == channelSortName {
+			return naturalLess(a.Name, b.Name)
+		}
+		return false
+	})
+
+	return tracks
+}
+
+// renameGroup 使用第一个匹配的规则重命名分组, 没有匹配的规则时返回原名称
+func renameGroup(group string, rules []groupRenameRule) string {
+	for _, rule := range rules {
+		if match := rule.match.FindStringSubmatchIndex(group); match != nil {
+			return string(rule.match.ExpandString(nil, rule.name, group, match))
+		}
+	}
+	return group
+}
+
+func hasKey(m map[string]int, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// naturalLess 按自然顺序比较名称, 连续的数字按数值比较, 例如 CCTV2 排在 CCTV10 之前
+func naturalLess(a, b string) bool {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		if isDigit(ra[i]) && isDigit(rb[j]) {
+			si, sj := i, j
+			for i < len(ra) && isDigit(ra[i]) {
+				i++
+			}
+			for j < len(rb) && isDigit(rb[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ra[si:i]), "0")
+			nb := strings.TrimLeft(string(rb[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if ra[i] != rb[j] {
+			return ra[i] < rb[j]
+		}
+		i++
+		j++
+	}
+	return len(ra)-i < len(rb)-j
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
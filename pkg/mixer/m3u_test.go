@@ -1 +1,307 @@
 package mixer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+func TestMixM3UMediaPlayList_TvBoxLives(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"m3u_source": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1 tvg-name=\"CCTV1\" group-title=\"央视\",CCTV1\nhttp://example.com/cctv1.m3u8\n"),
+			},
+			"tvbox_source": {
+				config: config.Source{Type: config.SourceTypeTvBoxSingle},
+				data: []byte(`{"lives":[` +
+					`{"name":"remote","type":0,"url":"http://example.com/live.txt"},` +
+					`{"group":"卫视","channels":[` +
+					`{"name":"湖南卫视","urls":["http://example.com/hunan1.m3u8","http://example.com/hunan2.m3u8"]},` +
+					`{"name":"浙江卫视","urls":["http://example.com/zhejiang.m3u8"]}]}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		M3UOpt: config.M3UOpt{
+			MediaPlaylistFilters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "m3u_source"}},
+				{MixOpt: config.MixOpt{SourceName: "tvbox_source"}},
+			},
+		},
+	}
+
+	result, err := MixM3UMediaPlayList(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Len(t, result.Tracks, 4)
+
+	assert.Equal(t, "CCTV1", result.Tracks[0].Name)
+	assert.Equal(t, "湖南卫视", result.Tracks[1].Name)
+	assert.Equal(t, "http://example.com/hunan1.m3u8", result.Tracks[1].URI)
+	assert.Equal(t, "卫视", result.Tracks[1].GetTag("group-title"))
+	assert.Equal(t, "http://example.com/hunan2.m3u8", result.Tracks[2].URI)
+	assert.Equal(t, "浙江卫视", result.Tracks[3].GetTag("tvg-name"))
+}
+
+func TestMixM3UMediaPlayList_TvgURL(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"m3u_source": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U x-tvg-url=\"http://upstream/epg.xml\"\n" +
+					"#EXTINF:-1 tvg-name=\"CCTV1\",CCTV1\nhttp://example.com/cctv1.m3u8\n"),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://proxy.local",
+		EPGOpt:      config.EPGOpt{M3UTvgURL: true},
+		M3UOpt: config.M3UOpt{
+			MediaPlaylistFallback: config.MixOpt{SourceName: "m3u_source"},
+		},
+	}
+
+	result, err := MixM3UMediaPlayList(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Len(t, result.Tags, 1)
+	assert.Equal(t, "http://proxy.local/v1/epg.xml", result.GetTag("x-tvg-url"))
+
+	cfg.EPGOpt.Disable = true
+	result, err = MixM3UMediaPlayList(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://upstream/epg.xml", result.GetTag("x-tvg-url"))
+}
+
+func TestMixM3UMediaPlayList_LiveTXT(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"txt_source": {
+				config: config.Source{Type: config.SourceTypeLiveTXT},
+				data: []byte("央视频道,#genre#\n" +
+					"CCTV1,http://example.com/cctv1.m3u8#http://backup.example.com/cctv1.m3u8\n" +
+					"卫视频道,#genre#\n" +
+					"湖南卫视,http://example.com/hunan.m3u8\n"),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		M3UOpt: config.M3UOpt{
+			MediaPlaylistFilters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "txt_source"}},
+			},
+		},
+	}
+
+	result, err := MixM3UMediaPlayList(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Len(t, result.Tracks, 3)
+	assert.Equal(t, "CCTV1", result.Tracks[0].Name)
+	assert.Equal(t, "央视频道", result.Tracks[0].GetTag("group-title"))
+	assert.Equal(t, "http://backup.example.com/cctv1.m3u8", result.Tracks[1].URI)
+	assert.Equal(t, "湖南卫视", result.Tracks[2].GetTag("tvg-name"))
+	assert.Equal(t, "卫视频道", result.Tracks[2].GetTag("group-title"))
+
+	// 转换回 TXT 时备用地址重新合并
+	txt := playlistToLiveTXT(result)
+	assert.Len(t, txt.Groups, 2)
+	assert.Equal(t, []string{"http://example.com/cctv1.m3u8", "http://backup.example.com/cctv1.m3u8"},
+		txt.Groups[0].Channels[0].URLs)
+}
+
+func TestMixM3UMediaPlayList_Conditions(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"tracks": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1 tvg-id=\"cctv1\" tvg-country=\"CN\" group-title=\"央视\",CCTV1\nhttp://cdn.example.com/cctv1.m3u8\n" +
+					"#EXTINF:-1 tvg-id=\"hunan\" tvg-country=\"CN\" group-title=\"卫视\",湖南卫视\nhttp://bad.example.com/hunan.m3u8\n" +
+					"#EXTINF:-1 tvg-id=\"tvb\" tvg-country=\"HK\" group-title=\"港澳\",翡翠台\nhttp://cdn.example.com/tvb.m3u8\n" +
+					"#EXTINF:-1 tvg-country=\"US\" group-title=\"海外\",CNN\nhttp://cdn.example.com/cnn.m3u8\n"),
+			},
+			"variants": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\nhttp://cdn.example.com/360.m3u8\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720\nhttp://cdn.example.com/720.m3u8\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=6000000,RESOLUTION=1920x1080\nhttp://cdn.example.com/1080.m3u8\n"),
+			},
+			"mixed": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1 group-title=\"央视\",CCTV1\nhttp://cdn.example.com/cctv1.m3u8\n" +
+					"#EXTINF:-1 group-title=\"海外\",CNN\nhttp://cdn.example.com/cnn.m3u8\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\nhttp://cdn.example.com/360.m3u8\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720\nhttp://cdn.example.com/720.m3u8\n"),
+			},
+		},
+	}
+
+	names := func(cfg *config.Config) []string {
+		result, err := MixM3UMediaPlayList(cfg, mockSourcer)
+		assert.NoError(t, err)
+		var names []string
+		for _, track := range result.Tracks {
+			names = append(names, track.Name)
+		}
+		for _, stream := range result.VariantStreams {
+			names = append(names, stream.Resolution)
+		}
+		return names
+	}
+	mix := func(filter config.ArrayMixOpt) *config.Config {
+		return &config.Config{M3UOpt: config.M3UOpt{MediaPlaylistFilters: []config.ArrayMixOpt{filter}}}
+	}
+
+	t.Run("Tag", func(t *testing.T) {
+		cfg := mix(config.ArrayMixOpt{
+			MixOpt:   config.MixOpt{SourceName: "tracks"},
+			FilterBy: "group-title",
+			Include:  "^(央视|卫视)$",
+		})
+		assert.Equal(t, []string{"CCTV1", "湖南卫视"}, names(cfg))
+
+		// 下划线写法同样按标签过滤, 而不是退化为按名称过滤
+		cfg.M3UOpt.MediaPlaylistFilters[0].FilterBy = "group_title"
+		assert.Equal(t, []string{"CCTV1", "湖南卫视"}, names(cfg))
+	})
+
+	t.Run("URIHost", func(t *testing.T) {
+		cfg := mix(config.ArrayMixOpt{
+			MixOpt:   config.MixOpt{SourceName: "tracks"},
+			FilterBy: "uri_host",
+			Exclude:  `^bad\.`,
+		})
+		assert.Equal(t, []string{"CCTV1", "翡翠台", "CNN"}, names(cfg))
+	})
+
+	t.Run("All", func(t *testing.T) {
+		cfg := mix(config.ArrayMixOpt{
+			MixOpt: config.MixOpt{SourceName: "tracks"},
+			Conditions: []config.FilterCondition{
+				{FilterBy: "tvg-country", Include: "^CN$"},
+				{FilterBy: "uri", Include: "cdn"},
+			},
+		})
+		assert.Equal(t, []string{"CCTV1"}, names(cfg))
+	})
+
+	t.Run("Any", func(t *testing.T) {
+		cfg := mix(config.ArrayMixOpt{
+			MixOpt:   config.MixOpt{SourceName: "tracks"},
+			FilterBy: "tvg-country",
+			Include:  "^HK$",
+			Conditions: []config.FilterCondition{
+				{FilterBy: "tvg-id", Include: "^cctv"},
+			},
+			Match: config.FilterMatchAny,
+		})
+		assert.Equal(t, []string{"CCTV1", "翡翠台"}, names(cfg))
+	})
+
+	t.Run("VariantStreams", func(t *testing.T) {
+		cfg := mix(config.ArrayMixOpt{
+			MixOpt: config.MixOpt{SourceName: "variants"},
+			Conditions: []config.FilterCondition{
+				{FilterBy: "resolution", Min: 720},
+				{FilterBy: "bandwidth", Max: 3000000},
+			},
+		})
+		assert.Equal(t, []string{"1280x720"}, names(cfg))
+	})
+
+	t.Run("LegacyFilterBy", func(t *testing.T) {
+		// 旧配置中 filter_by 只是开关, 无法识别的值按名称过滤
+		cfg := mix(config.ArrayMixOpt{
+			MixOpt:   config.MixOpt{SourceName: "tracks"},
+			FilterBy: "title",
+			Include:  "卫视$",
+		})
+		assert.Equal(t, []string{"湖南卫视"}, names(cfg))
+	})
+
+	t.Run("Scope", func(t *testing.T) {
+		// 标签条件不作用于变体流, 变体流属性条件不作用于轨道
+		cfg := mix(config.ArrayMixOpt{
+			MixOpt: config.MixOpt{SourceName: "mixed"},
+			Conditions: []config.FilterCondition{
+				{FilterBy: "group-title", Include: "^央视$"},
+				{FilterBy: "resolution", Min: 720},
+			},
+		})
+		assert.Equal(t, []string{"CCTV1", "1280x720"}, names(cfg))
+
+		cfg.M3UOpt.MediaPlaylistFilters[0].Match = config.FilterMatchAny
+		assert.Equal(t, []string{"CCTV1", "1280x720"}, names(cfg))
+	})
+}
+
+func TestMixM3UMediaPlayList_Groups(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"a": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1 group-title=\"卫视频道\",湖南卫视\nhttp://a/hunan.m3u8\n" +
+					"#EXTINF:-1 group-title=\"CCTV\",CCTV10\nhttp://a/cctv10.m3u8\n" +
+					"#EXTINF:-1 group-title=\"央视频道\",CCTV2\nhttp://a/cctv2.m3u8\n" +
+					"#EXTINF:-1 group-title=\"其他\",CGTN\nhttp://a/cgtn.m3u8\n" +
+					"#EXTINF:-1 group-title=\"央视\",CCTV1\nhttp://a/cctv1.m3u8\n" +
+					"#EXTINF:-1,CCTV5+\nhttp://a/cctv5p.m3u8\n" +
+					"#EXTINF:-1 group-title=\"卫视频道\",东方卫视\nhttp://a/dongfang.m3u8\n"),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		M3UOpt: config.M3UOpt{
+			MediaPlaylistFilters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "a"}},
+			},
+			Groups: config.M3UGroupOpt{
+				Assign: []config.GroupAssignRule{
+					{Match: "^(CCTV|CGTN)", Group: "央视"},
+				},
+				Rename: []config.GroupRenameRule{
+					{Match: "^(.+)频道$", Name: "$1"},
+				},
+				Order: []string{"央视", "卫视"},
+				ChannelOrder: []config.GroupChannelOrder{
+					{Group: "卫视", Channels: []string{"^东方", "^湖南"}},
+				},
+				ChannelSort: "name",
+			},
+		},
+	}
+
+	result, err := MixM3UMediaPlayList(cfg, mockSourcer)
+	assert.NoError(t, err)
+
+	var channels []string
+	for _, track := range result.Tracks {
+		channels = append(channels, track.GetTag("group-title")+"/"+track.Name)
+	}
+	assert.Equal(t, []string{
+		"央视/CCTV1",
+		"央视/CCTV2",
+		"央视/CCTV5+",
+		"央视/CCTV10",
+		"央视/CGTN",
+		"卫视/东方卫视",
+		"卫视/湖南卫视",
+	}, channels)
+}
+
+func TestNaturalLess(t *testing.T) {
+	assert.True(t, naturalLess("CCTV2", "CCTV10"))
+	assert.False(t, naturalLess("CCTV10", "CCTV2"))
+	assert.True(t, naturalLess("cctv5", "CCTV5+"))
+	assert.True(t, naturalLess("CCTV05", "CCTV6"))
+	assert.False(t, naturalLess("CCTV1", "CCTV1"))
+}
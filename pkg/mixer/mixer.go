@@ -7,8 +7,14 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
 
 	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/chname"
+	"github.com/wayjam/tv-mixproxy/pkg/doh"
 )
 
 func compileRegex(pattern string) *regexp.Regexp {
@@ -33,36 +39,217 @@ func matchFilter(value string, includeRegex, excludeRegex *regexp.Regexp) bool {
 	return true
 }
 
+// MixOption 为混合过程提供可选的依赖
+type MixOption func(*mixOptions)
+
+type mixOptions struct {
+	jarCache     *JarCache
+	repoProber   *RepoProber
+	siteProber   *SiteProber
+	streamProber *StreamProber
+	normalizer   *chname.Normalizer
+	numberer     *ChannelNumberer
+	logos        *LogoManager
+	relay        *StreamRelay
+	epgCache     *EPGCache
+	dohResolver  *doh.Resolver
+}
+
+func newMixOptions(opts []MixOption) *mixOptions {
+	o := &mixOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithJarCache 使用 jar 缓存代理 spider 和站点 jar
+func WithJarCache(jc *JarCache) MixOption {
+	return func(o *mixOptions) {
+		o.jarCache = jc
+	}
+}
+
+// WithRepoProber 根据多仓条目的健康检查结果移除或标记不可用的条目
+func WithRepoProber(p *RepoProber) MixOption {
+	return func(o *mixOptions) {
+		o.repoProber = p
+	}
+}
+
+// WithSiteProber 根据站点与解析的健康度排除低于 min_health 的条目
+func WithSiteProber(p *SiteProber) MixOption {
+	return func(o *mixOptions) {
+		o.siteProber = p
+	}
+}
+
+// WithStreamProber 根据轨道健康检查结果移除不可用的轨道, 并按首字节时间排列同一频道的地址
+func WithStreamProber(p *StreamProber) MixOption {
+	return func(o *mixOptions) {
+		o.streamProber = p
+	}
+}
+
+// WithChannelNormalizer 使用规范化后的频道名称与 ID 混合 M3U、EPG 与 TvBox lives
+func WithChannelNormalizer(n *chname.Normalizer) MixOption {
+	return func(o *mixOptions) {
+		o.normalizer = n
+	}
+}
+
+// WithChannelNumberer 为混合后的 M3U 频道设置稳定的 tvg-chno
+func WithChannelNumberer(n *ChannelNumberer) MixOption {
+	return func(o *mixOptions) {
+		o.numberer = n
+	}
+}
+
+// WithLogoManager 为混合后的 M3U 频道补全或代理台标
+func WithLogoManager(m *LogoManager) MixOption {
+	return func(o *mixOptions) {
+		o.logos = m
+	}
+}
+
+// WithStreamRelay 将混合后选中的 M3U 轨道改写为中转地址
+func WithStreamRelay(r *StreamRelay) MixOption {
+	return func(o *mixOptions) {
+		o.relay = r
+	}
+}
+
+// WithEPGCache 补全台标时使用缓存的 EPG, 避免每次混合播放列表都重新混合 EPG
+func WithEPGCache(c *EPGCache) MixOption {
+	return func(o *mixOptions) {
+		o.epgCache = c
+	}
+}
+
+// WithDOHResolver 在 doh 中注入指向本服务 /dns-query 的条目, 解析器初始化失败时不注入
+func WithDOHResolver(r *doh.Resolver) MixOption {
+	return func(o *mixOptions) {
+		o.dohResolver = r
+	}
+}
+
+// cacheJar 将 jar 地址替换为缓存代理地址，并附带正确的 md5 校验信息
+// 未启用缓存、非 http(s) 地址或 jar 暂不可用时返回原地址
+func (o *mixOptions) cacheJar(cfg *config.Config, link string) string {
+	if o.jarCache == nil || !isHTTPURL(stripJarChecksum(link)) {
+		return link
+	}
+
+	hash, checksum, err := o.jarCache.Resolve(link)
+	if err != nil || checksum == "" {
+		return link
+	}
+
+	return getExternalURL(cfg) + "/v1/tvbox/jar/" + hash + ";md5;" + checksum
+}
+
+// nullHandler 在未配置目标地址时返回空响应
 var nullHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 
+// MixURLHandler 代理混合得到的地址，例如 spider
+// 每次源数据刷新后都会重新解析目标地址，从而跟随上游的变化
+type MixURLHandler struct {
+	mixOpt  config.MixOpt
+	sourcer Sourcer
+	opts    *mixOptions
+
+	mu         sync.Mutex
+	resolvedAt time.Time // 解析时源数据的更新时间
+	link       string
+	proxy      http.Handler
+	err        error
+}
+
 func NewMixURLHandler(
-	mixOpt config.MixOpt, sourcer Sourcer,
-) (http.Handler, error) {
-	if mixOpt.Disabled || mixOpt.SourceName == "" {
+	mixOpt config.MixOpt, sourcer Sourcer, opts ...MixOption,
+) *MixURLHandler {
+	return &MixURLHandler{
+		mixOpt:  mixOpt,
+		sourcer: sourcer,
+		opts:    newMixOptions(opts),
+	}
+}
+
+// Err 返回最近一次解析目标地址时的错误
+func (h *MixURLHandler) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// Link 返回当前解析到的目标地址
+func (h *MixURLHandler) Link() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.link
+}
+
+// resolve 根据当前的源数据解析目标地址，源数据未刷新时复用上一次的结果
+// 未配置目标地址时与之前一样返回空响应
+func (h *MixURLHandler) resolve() (http.Handler, error) {
+	if h.mixOpt.Disabled || h.mixOpt.SourceName == "" {
 		return nullHandler, nil
 	}
 
-	link, source, err := mixFieldAndGetSource(mixOpt, sourcer)
+	source, err := h.sourcer.GetSource(h.mixOpt.SourceName)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	if err != nil {
-		return nullHandler, fmt.Errorf("mixing url: %w", err)
+		// 源暂不可用时继续使用上一次成功解析的目标, 错误仍通过 Err 报告
+		h.err = fmt.Errorf("mixing url: getting source %s: %w", h.mixOpt.SourceName, err)
+		if h.proxy != nil {
+			return h.proxy, nil
+		}
+		return nil, h.err
+	}
+
+	if h.proxy != nil && !source.lastUpdate.IsZero() && source.lastUpdate.Equal(h.resolvedAt) {
+		h.err = nil
+		return h.proxy, nil
 	}
 
+	proxy, link, err := h.newProxy(source)
+	h.resolvedAt = source.lastUpdate
+	h.err = err
+	if err != nil {
+		h.proxy, h.link = nil, ""
+		return nil, err
+	}
+	h.proxy, h.link = proxy, link
+
+	return proxy, nil
+}
+
+func (h *MixURLHandler) newProxy(source *Source) (http.Handler, string, error) {
 	if source.Type() != config.SourceTypeTvBoxSingle {
-		return nullHandler, fmt.Errorf("source %s should be a single source", mixOpt.SourceName)
+		return nil, "", fmt.Errorf("source %s should be a single source", h.mixOpt.SourceName)
 	}
 
+	link := gjson.GetBytes(source.Data(), h.mixOpt.Field).String()
 	if link == "" {
-		return nullHandler, nil
+		return nil, "", fmt.Errorf("field %s not found in source %s", h.mixOpt.Field, h.mixOpt.SourceName)
 	}
-
-	// 移除 URL 中可能存在的校验信息
-	link = strings.Split(link, ";")[0]
-	// 如果是相对路径，则返回一个 proxy 处理器，该处理器将请求转发到相对路径
+	// 如果是相对路径，则转换为基于源地址的绝对路径
 	link = fullFillURL(link, source)
 
-	targetURL, err := url.Parse(link)
+	// 启用 jar 缓存时，直接由缓存提供内容
+	if jarCache := h.opts.jarCache; jarCache != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			jarCache.ServeLink(w, r, link)
+		}), link, nil
+	}
+
+	// 移除 URL 中可能存在的校验信息
+	targetURL, err := url.Parse(strings.Split(link, ";")[0])
 	if err != nil {
-		return nullHandler, fmt.Errorf("parsing target url: %w", err)
+		return nil, "", fmt.Errorf("parsing target url: %w", err)
 	}
 
 	proxy := &httputil.ReverseProxy{
@@ -71,6 +258,7 @@ func NewMixURLHandler(
 			req.URL.Scheme = targetURL.Scheme
 			req.URL.Host = targetURL.Host
 			req.URL.Path, req.URL.RawPath = targetURL.Path, targetURL.RawPath
+			req.URL.RawQuery = targetURL.RawQuery
 		},
 	}
 
@@ -79,5 +267,14 @@ func NewMixURLHandler(
 		http.Error(w, err.Error(), http.StatusBadGateway)
 	}
 
-	return proxy, nil
+	return proxy, link, nil
+}
+
+func (h *MixURLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	proxy, err := h.resolve()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	proxy.ServeHTTP(w, r)
 }
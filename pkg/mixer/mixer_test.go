@@ -0,0 +1,102 @@
+package mixer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+func TestMixURLHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	source := &Source{
+		config:     config.Source{Name: "source1", Type: config.SourceTypeTvBoxSingle},
+		data:       []byte(`{"spider":"` + upstream.URL + `/a.jar;md5;abc"}`),
+		lastUpdate: time.Now(),
+	}
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{"source1": source},
+	}
+
+	handler := NewMixURLHandler(config.MixOpt{SourceName: "source1", Field: "spider"}, mockSourcer)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tvbox/spider", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "/a.jar", rec.Body.String())
+	assert.NoError(t, handler.Err())
+
+	// 源刷新后应跟随上游切换到新的 jar
+	source.data = []byte(`{"spider":"` + upstream.URL + `/b.jar"}`)
+	source.lastUpdate = source.lastUpdate.Add(time.Minute)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tvbox/spider", nil))
+	assert.Equal(t, "/b.jar", rec.Body.String())
+	assert.Equal(t, upstream.URL+"/b.jar", handler.Link())
+
+	// 字段缺失时应返回错误而不是空响应
+	source.data = []byte(`{}`)
+	source.lastUpdate = source.lastUpdate.Add(time.Minute)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tvbox/spider", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Error(t, handler.Err())
+
+	// 上游恢复后错误状态应被清除
+	source.data = []byte(`{"spider":"` + upstream.URL + `/c.jar"}`)
+	source.lastUpdate = source.lastUpdate.Add(time.Minute)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tvbox/spider", nil))
+	assert.Equal(t, "/c.jar", rec.Body.String())
+	assert.NoError(t, handler.Err())
+
+	// 源暂不可用时继续代理上一次的目标, 但报告错误
+	delete(mockSourcer.sources, "source1")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tvbox/spider", nil))
+	assert.Equal(t, "/c.jar", rec.Body.String())
+	assert.ErrorContains(t, handler.Err(), "getting source source1")
+
+	mockSourcer.sources["source1"] = source
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tvbox/spider", nil))
+	assert.NoError(t, handler.Err())
+}
+
+func TestMixURLHandler_NotConfigured(t *testing.T) {
+	handler := NewMixURLHandler(config.MixOpt{Field: "spider"}, &MockSourcer{sources: map[string]*Source{}})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tvbox/spider", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.NoError(t, handler.Err())
+}
+
+func TestMixURLHandler_NotSingleSource(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"multi": {
+				config: config.Source{Name: "multi", Type: config.SourceTypeTvBoxMulti},
+				data:   []byte(`{"spider":"http://example.com/a.jar"}`),
+			},
+		},
+	}
+
+	handler := NewMixURLHandler(config.MixOpt{SourceName: "multi", Field: "spider"}, mockSourcer)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tvbox/spider", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.ErrorContains(t, handler.Err(), "should be a single source")
+}
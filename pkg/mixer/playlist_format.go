@@ -0,0 +1,161 @@
+package mixer
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/livetxt"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+// PlaylistFormat 为混合后频道列表的输出格式
+type PlaylistFormat string
+
+const (
+	PlaylistFormatM3U   PlaylistFormat = "m3u"   // M3U 播放列表
+	PlaylistFormatTXT   PlaylistFormat = "txt"   // TXT 直播源列表
+	PlaylistFormatJSON  PlaylistFormat = "json"  // 带标签的轨道 JSON
+	PlaylistFormatTvBox PlaylistFormat = "tvbox" // 内嵌 group/channels 的 TvBox lives JSON
+)
+
+// acceptFormats 为 Accept 请求头中的媒体类型对应的输出格式
+var acceptFormats = map[string]PlaylistFormat{
+	"application/vnd.apple.mpegurl": PlaylistFormatM3U,
+	"application/x-mpegurl":         PlaylistFormatM3U,
+	"audio/mpegurl":                 PlaylistFormatM3U,
+	"audio/x-mpegurl":               PlaylistFormatM3U,
+	"text/plain":                    PlaylistFormatTXT,
+	"application/json":              PlaylistFormatJSON,
+}
+
+// ParsePlaylistFormat 根据 format 查询参数或 Accept 请求头选择输出格式
+// 查询参数优先，Accept 中按 q 值选择权重最高的受支持类型，权重相同时取靠前的类型
+// 均未指定或无法识别 Accept 时使用 M3U
+func ParsePlaylistFormat(format, accept string) (PlaylistFormat, error) {
+	if format != "" {
+		switch f := PlaylistFormat(strings.ToLower(format)); f {
+		case PlaylistFormatM3U, PlaylistFormatTXT, PlaylistFormatJSON, PlaylistFormatTvBox:
+			return f, nil
+		case "m3u8":
+			return PlaylistFormatM3U, nil
+		default:
+			return "", fmt.Errorf("unsupported format: %s", format)
+		}
+	}
+
+	result, best := PlaylistFormatM3U, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		f, ok := acceptFormats[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		// q=0 表示客户端不接受该类型
+		if q > best {
+			result, best = f, q
+		}
+	}
+	return result, nil
+}
+
+// ContentType 返回输出格式对应的 Content-Type
+func (f PlaylistFormat) ContentType() string {
+	switch f {
+	case PlaylistFormatTXT:
+		return "text/plain; charset=utf-8"
+	case PlaylistFormatJSON, PlaylistFormatTvBox:
+		return "application/json; charset=utf-8"
+	default:
+		return "audio/x-mpegurl; charset=utf-8"
+	}
+}
+
+type playlistJSON struct {
+	Tags           map[string]string   `json:"tags"`
+	Tracks         []trackJSON         `json:"tracks"`
+	VariantStreams []variantStreamJSON `json:"variant_streams,omitempty"`
+}
+
+type trackJSON struct {
+	Name     string            `json:"name"`
+	Duration float64           `json:"duration"`
+	URI      string            `json:"uri"`
+	Tags     map[string]string `json:"tags"`
+	VLCOpts  map[string]string `json:"vlc_opts,omitempty"`
+}
+
+type variantStreamJSON struct {
+	Name       string            `json:"name,omitempty"`
+	URI        string            `json:"uri"`
+	Bandwidth  int               `json:"bandwidth,omitempty"`
+	Resolution string            `json:"resolution,omitempty"`
+	Codecs     string            `json:"codecs,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// tagsToMap 将标签转换为键为小写名称的 map
+func tagsToMap(tags []m3u.Tag) map[string]string {
+	result := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		result[strings.ToLower(tag.Name)] = tag.Value
+	}
+	return result
+}
+
+// EncodePlaylist 将混合后的播放列表编码为指定格式
+// 启用频道去重且 m3u_fallback 为 vlcopt 时, M3U 输出中同一频道的备用地址合并到一个轨道中
+func EncodePlaylist(cfg *config.Config, playlist *m3u.Playlist, format PlaylistFormat) ([]byte, error) {
+	switch format {
+	case PlaylistFormatTXT:
+		txt := playlistToLiveTXT(playlist)
+		return livetxt.Marshal(&txt)
+	case PlaylistFormatJSON:
+		result := playlistJSON{Tags: tagsToMap(playlist.Tags), Tracks: []trackJSON{}}
+		for _, track := range playlist.Tracks {
+			result.Tracks = append(result.Tracks, trackJSON{
+				Name:     track.Name,
+				Duration: track.Duration,
+				URI:      track.URI,
+				Tags:     tagsToMap(track.Tags),
+				VLCOpts:  tagsToMap(track.VLCOpts),
+			})
+		}
+		for _, stream := range playlist.VariantStreams {
+			result.VariantStreams = append(result.VariantStreams, variantStreamJSON{
+				Name:       stream.Name,
+				URI:        stream.URI,
+				Bandwidth:  stream.Bandwidth,
+				Resolution: stream.Resolution,
+				Codecs:     stream.Codecs,
+				Tags:       tagsToMap(stream.Tags),
+			})
+		}
+		return json.Marshal(result)
+	case PlaylistFormatTvBox:
+		lives := playlistToLives(playlist)
+		if lives == nil {
+			lives = []config.TvBoxLive{}
+		}
+		return json.Marshal(struct {
+			Lives []config.TvBoxLive `json:"lives"`
+		}{Lives: lives})
+	default:
+		if cfg.M3UOpt.Dedup.Enable && cfg.M3UOpt.Dedup.M3UFallback == config.M3UFallbackVLCOpt {
+			playlist = collapseAlternates(playlist)
+		}
+		return m3u.Marshal(playlist)
+	}
+}
@@ -0,0 +1,89 @@
+package mixer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+func TestParsePlaylistFormat(t *testing.T) {
+	tests := []struct {
+		format, accept string
+		want           PlaylistFormat
+	}{
+		{"", "", PlaylistFormatM3U},
+		{"txt", "application/json", PlaylistFormatTXT},
+		{"TvBox", "", PlaylistFormatTvBox},
+		{"m3u8", "", PlaylistFormatM3U},
+		{"", "application/json; charset=utf-8", PlaylistFormatJSON},
+		{"", "text/html, text/plain;q=0.9", PlaylistFormatTXT},
+		{"", "*/*", PlaylistFormatM3U},
+		{"", "text/plain;q=0.1, audio/x-mpegurl", PlaylistFormatM3U},
+		{"", "text/plain;q=0.5, application/json;q=0.8", PlaylistFormatJSON},
+		{"", "application/json;q=0, text/plain;q=0.2", PlaylistFormatTXT},
+		{"", "text/plain, application/json", PlaylistFormatTXT},
+	}
+	for _, tt := range tests {
+		got, err := ParsePlaylistFormat(tt.format, tt.accept)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got, "format=%q accept=%q", tt.format, tt.accept)
+	}
+
+	_, err := ParsePlaylistFormat("xml", "")
+	assert.Error(t, err)
+}
+
+func TestEncodePlaylist(t *testing.T) {
+	playlist, err := config.ParseM3U8Config(strings.NewReader("#EXTM3U x-tvg-url=\"http://epg/e.xml\"\n" +
+		"#EXTINF:-1 tvg-name=\"CCTV1\" group-title=\"央视\",CCTV1\nhttp://a/cctv1.m3u8\n" +
+		"#EXTINF:-1 tvg-name=\"CCTV1\" group-title=\"央视\",CCTV1\nhttp://b/cctv1.m3u8\n" +
+		"#EXTINF:-1 group-title=\"卫视\",湖南卫视\nhttp://a/hunan.m3u8\n"))
+	assert.NoError(t, err)
+
+	t.Run("M3U", func(t *testing.T) {
+		data, err := EncodePlaylist(&config.Config{}, &playlist, PlaylistFormatM3U)
+		assert.NoError(t, err)
+		var parsed m3u.Playlist
+		assert.NoError(t, m3u.Unmarshal(data, &parsed))
+		assert.Len(t, parsed.Tracks, 3)
+	})
+
+	t.Run("TXT", func(t *testing.T) {
+		data, err := EncodePlaylist(&config.Config{}, &playlist, PlaylistFormatTXT)
+		assert.NoError(t, err)
+		assert.Equal(t, "央视,#genre#\n"+
+			"CCTV1,http://a/cctv1.m3u8#http://b/cctv1.m3u8\n"+
+			"\n"+
+			"卫视,#genre#\n"+
+			"湖南卫视,http://a/hunan.m3u8\n", string(data))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := EncodePlaylist(&config.Config{}, &playlist, PlaylistFormatJSON)
+		assert.NoError(t, err)
+		result := gjson.ParseBytes(data)
+		assert.Equal(t, "http://epg/e.xml", result.Get("tags.x-tvg-url").String())
+		assert.Equal(t, int64(3), result.Get("tracks.#").Int())
+		assert.Equal(t, "CCTV1", result.Get("tracks.0.name").String())
+		assert.Equal(t, "央视", result.Get("tracks.0.tags.group-title").String())
+		assert.Equal(t, "http://b/cctv1.m3u8", result.Get("tracks.1.uri").String())
+	})
+
+	t.Run("TvBox", func(t *testing.T) {
+		data, err := EncodePlaylist(&config.Config{}, &playlist, PlaylistFormatTvBox)
+		assert.NoError(t, err)
+		result := gjson.ParseBytes(data)
+		assert.Equal(t, "央视", result.Get("lives.0.group").String())
+		assert.Equal(t, `["http://a/cctv1.m3u8","http://b/cctv1.m3u8"]`, result.Get("lives.0.channels.0.urls").Raw)
+		assert.Equal(t, "湖南卫视", result.Get("lives.1.channels.0.name").String())
+
+		data, err = EncodePlaylist(&config.Config{}, &m3u.Playlist{}, PlaylistFormatTvBox)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"lives":[]}`, string(data))
+	})
+}
@@ -0,0 +1,192 @@
+package mixer
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+// RepoHealth 记录多仓条目的健康检查结果
+type RepoHealth struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Reachable bool      `json:"reachable"`
+	LatencyMS int64     `json:"latency_ms"`
+	SiteCount int       `json:"site_count"`
+	LiveCount int       `json:"live_count"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// RepoProber 定期拉取多仓中的每个仓库，检查其是否可用
+type RepoProber struct {
+	cfg         *config.Config
+	sourcer     Sourcer
+	concurrency int
+	mu          sync.RWMutex
+	results     map[string]RepoHealth // key 为仓库地址
+	ticker      *time.Ticker
+	done        chan bool
+	logger      *slog.Logger
+}
+
+func NewRepoProber(cfg *config.Config, sourcer Sourcer, logger *slog.Logger) *RepoProber {
+	interval := time.Duration(cfg.TvBoxMultiRepoOpt.HealthCheck.Interval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	p := &RepoProber{
+		cfg:         cfg,
+		sourcer:     sourcer,
+		concurrency: 8,
+		results:     make(map[string]RepoHealth),
+		ticker:      time.NewTicker(interval),
+		done:        make(chan bool),
+	}
+
+	if logger != nil {
+		p.logger = logger.With("manager", "repo_prober")
+	}
+
+	go p.probeLoop()
+
+	return p
+}
+
+func (p *RepoProber) log(format string, args ...any) {
+	if p.logger != nil {
+		p.logger.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+func (p *RepoProber) probeLoop() {
+	p.ProbeAll()
+	for {
+		select {
+		case <-p.ticker.C:
+			p.ProbeAll()
+		case <-p.done:
+			p.ticker.Stop()
+			return
+		}
+	}
+}
+
+// ProbeAll 检查当前上游多仓中的所有仓库
+func (p *RepoProber) ProbeAll() {
+	repos, err := mixUpstreamRepos(p.cfg, p.sourcer)
+	if err != nil {
+		p.log("probe repos: %v", err)
+		return
+	}
+
+	results := make(map[string]RepoHealth, len(repos))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, p.concurrency)
+
+	seen := make(map[string]bool, len(repos))
+	for _, repo := range repos {
+		if seen[repo.URL] {
+			continue
+		}
+		seen[repo.URL] = true
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(repo config.TvBoxRepoURLConfig) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			health := probeRepo(repo)
+			mu.Lock()
+			results[repo.URL] = health
+			mu.Unlock()
+		}(repo)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	p.results = results
+	p.mu.Unlock()
+
+	p.log("probed %d repos", len(results))
+}
+
+// probeRepo 使用与单仓相同的方式拉取并解析仓库
+func probeRepo(repo config.TvBoxRepoURLConfig) RepoHealth {
+	health := RepoHealth{
+		Name:      repo.Name,
+		URL:       repo.URL,
+		CheckedAt: time.Now(),
+	}
+
+	start := time.Now()
+	repoConfig, err := config.LoadTvBoxConfig(repo.URL)
+	health.LatencyMS = time.Since(start).Milliseconds()
+
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+
+	health.Reachable = true
+	health.SiteCount = len(repoConfig.Sites)
+	health.LiveCount = len(repoConfig.Lives)
+	return health
+}
+
+// Health 返回指定仓库地址的检查结果
+func (p *RepoProber) Health(url string) (RepoHealth, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	health, ok := p.results[url]
+	return health, ok
+}
+
+// Results 返回所有仓库的检查结果，按名称排序
+func (p *RepoProber) Results() []RepoHealth {
+	p.mu.RLock()
+	results := make([]RepoHealth, 0, len(p.results))
+	for _, health := range p.results {
+		results = append(results, health)
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Name != results[j].Name {
+			return results[i].Name < results[j].Name
+		}
+		return results[i].URL < results[j].URL
+	})
+	return results
+}
+
+// apply 根据检查结果处理仓库条目，返回 false 表示应移除该条目
+// 尚未检查过的条目保持原样
+func (p *RepoProber) apply(
+	repo config.TvBoxRepoURLConfig, opt config.HealthCheckOpt,
+) (config.TvBoxRepoURLConfig, bool) {
+	health, ok := p.Health(repo.URL)
+	if !ok || health.CheckedAt.IsZero() || health.Reachable {
+		return repo, true
+	}
+
+	switch opt.Action {
+	case config.HealthCheckActionDrop:
+		return repo, false
+	case config.HealthCheckActionMark:
+		repo.Name += opt.Mark
+	}
+	return repo, true
+}
+
+func (p *RepoProber) Close() {
+	p.done <- true
+}
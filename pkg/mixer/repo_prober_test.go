@@ -0,0 +1,78 @@
+package mixer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+func TestRepoProber(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/good.json":
+			w.Write([]byte(`// comment
+{"sites":[{"key":"a","name":"A"},{"key":"b","name":"B"}],"lives":[{"name":"live"}]}`))
+		case "/html.json":
+			w.Write([]byte(`<html>not json</html>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"multi_source": {
+				data: []byte(`{"urls":[` +
+					`{"url":"` + upstream.URL + `/good.json","name":"Good"},` +
+					`{"url":"` + upstream.URL + `/html.json","name":"Html"},` +
+					`{"url":"` + upstream.URL + `/missing.json","name":"Missing"}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		TvBoxMultiRepoOpt: config.TvBoxMultiRepoOpt{
+			Repos: []config.ArrayMixOpt{{MixOpt: config.MixOpt{SourceName: "multi_source"}}},
+			HealthCheck: config.HealthCheckOpt{
+				Enable: true,
+				Action: config.HealthCheckActionMark,
+			},
+		},
+	}
+	cfg.Fixture()
+
+	prober := NewRepoProber(cfg, mockSourcer, nil)
+	defer prober.Close()
+	prober.ProbeAll()
+
+	results := prober.Results()
+	assert.Len(t, results, 3)
+	assert.Equal(t, "Good", results[0].Name)
+	assert.True(t, results[0].Reachable)
+	assert.Equal(t, 2, results[0].SiteCount)
+	assert.Equal(t, 1, results[0].LiveCount)
+	assert.False(t, results[1].Reachable)
+	assert.Contains(t, results[1].Error, "failed to parse JSON")
+	assert.False(t, results[2].Reachable)
+
+	t.Run("Mark", func(t *testing.T) {
+		result, err := MixMultiRepo(cfg, mockSourcer, WithRepoProber(prober))
+		assert.NoError(t, err)
+		assert.Len(t, result.Repos, 3)
+		assert.Equal(t, "Good", result.Repos[0].Name)
+		assert.Equal(t, "Html ❌", result.Repos[1].Name)
+		assert.Equal(t, "Missing ❌", result.Repos[2].Name)
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		cfg.TvBoxMultiRepoOpt.HealthCheck.Action = config.HealthCheckActionDrop
+		result, err := MixMultiRepo(cfg, mockSourcer, WithRepoProber(prober))
+		assert.NoError(t, err)
+		assert.Len(t, result.Repos, 1)
+		assert.Equal(t, "Good", result.Repos[0].Name)
+	})
+}
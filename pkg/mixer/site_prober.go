@@ -0,0 +1,323 @@
+package mixer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+type HealthTarget string
+
+const (
+	HealthTargetSite  HealthTarget = "site"
+	HealthTargetParse HealthTarget = "parse"
+)
+
+// healthScoreWeight 为最近一次检查结果在健康度中的权重
+const healthScoreWeight = 0.3
+
+// SiteHealth 记录站点或解析接口的健康状况
+type SiteHealth struct {
+	Target    HealthTarget `json:"target"`
+	Key       string       `json:"key,omitempty"`
+	Name      string       `json:"name"`
+	URL       string       `json:"url"`
+	Score     float64      `json:"score"` // 健康度, 0 到 1 之间, 按检查结果指数加权平均
+	Checks    int          `json:"checks"`
+	Failures  int          `json:"failures"`
+	LatencyMS int64        `json:"latency_ms"`
+	Error     string       `json:"error,omitempty"`
+	CheckedAt time.Time    `json:"checked_at"`
+}
+
+// SiteProber 定期检查混合后的 CMS 站点接口与解析接口是否可用
+type SiteProber struct {
+	cfg         *config.Config
+	sourcer     Sourcer
+	client      *http.Client
+	concurrency int
+	mu          sync.RWMutex
+	results     map[string]*SiteHealth // key 为检查的地址
+	probedAt    time.Time              // 最近一次完成检查的时间
+	ticker      *time.Ticker
+	done        chan bool
+	logger      *slog.Logger
+}
+
+func NewSiteProber(cfg *config.Config, sourcer Sourcer, logger *slog.Logger) *SiteProber {
+	interval := time.Duration(cfg.TvBoxSingleRepoOpt.HealthCheck.Interval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	p := &SiteProber{
+		cfg:         cfg,
+		sourcer:     sourcer,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		concurrency: 8,
+		results:     make(map[string]*SiteHealth),
+		ticker:      time.NewTicker(interval),
+		done:        make(chan bool),
+	}
+
+	if logger != nil {
+		p.logger = logger.With("manager", "site_prober")
+	}
+
+	go p.probeLoop()
+
+	return p
+}
+
+func (p *SiteProber) log(format string, args ...any) {
+	if p.logger != nil {
+		p.logger.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+func (p *SiteProber) probeLoop() {
+	p.ProbeAll()
+	for {
+		select {
+		case <-p.ticker.C:
+			p.ProbeAll()
+		case <-p.done:
+			p.ticker.Stop()
+			return
+		}
+	}
+}
+
+type probeTarget struct {
+	health   SiteHealth
+	probeURL string
+	validate func(body []byte) bool
+}
+
+// ProbeAll 检查当前混合结果中的所有 CMS 站点与解析接口
+func (p *SiteProber) ProbeAll() {
+	repo, err := MixTvBoxRepo(p.cfg, p.sourcer)
+	if err != nil {
+		p.log("probe sites: %v", err)
+		return
+	}
+
+	targets := collectProbeTargets(p.cfg, repo)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, p.concurrency)
+	for i := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(target probeTarget) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			p.probe(target)
+		}(targets[i])
+	}
+	wg.Wait()
+
+	// 移除已不在混合结果中的条目
+	current := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		current[target.health.URL] = true
+	}
+	p.mu.Lock()
+	for url := range p.results {
+		if !current[url] {
+			delete(p.results, url)
+		}
+	}
+	p.probedAt = time.Now()
+	p.mu.Unlock()
+
+	p.log("probed %d sites and parses", len(targets))
+}
+
+// collectProbeTargets 收集需要检查的站点与解析接口
+// 仅检查 XML/JSON CMS 站点 (type 0/1)，spider 站点无法直接检查
+// 本服务的聚合搜索站点会请求所有上游站点, 不参与检查
+func collectProbeTargets(cfg *config.Config, repo *config.TvBoxRepoConfig) []probeTarget {
+	var targets []probeTarget
+	seen := make(map[string]bool)
+
+	searchSite := cfg.TvBoxSingleRepoOpt.SearchSite
+	for _, site := range repo.Sites {
+		if site.Type != 0 && site.Type != 1 || !isHTTPURL(site.API) || seen[site.API] {
+			continue
+		}
+		if searchSite.Enable && site.Key == searchSite.Key {
+			continue
+		}
+		seen[site.API] = true
+
+		validate := func(body []byte) bool { return gjson.ValidBytes(body) }
+		if site.Type == 0 {
+			validate = func(body []byte) bool { return strings.HasPrefix(strings.TrimSpace(string(body)), "<") }
+		}
+		targets = append(targets, probeTarget{
+			health:   SiteHealth{Target: HealthTargetSite, Key: site.Key, Name: site.Name, URL: site.API},
+			probeURL: appendQuery(site.API, "ac=list"),
+			validate: validate,
+		})
+	}
+
+	for _, parse := range repo.Parses {
+		if !isHTTPURL(parse.URL) || seen[parse.URL] {
+			continue
+		}
+		seen[parse.URL] = true
+		targets = append(targets, probeTarget{
+			health:   SiteHealth{Target: HealthTargetParse, Name: parse.Name, URL: parse.URL},
+			probeURL: parse.URL,
+		})
+	}
+
+	return targets
+}
+
+func (p *SiteProber) probe(target probeTarget) {
+	start := time.Now()
+	err := p.request(target)
+	latency := time.Since(start).Milliseconds()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	health, ok := p.results[target.health.URL]
+	if !ok {
+		health = &target.health
+		p.results[target.health.URL] = health
+	}
+	health.Key, health.Name = target.health.Key, target.health.Name
+
+	result := 1.0
+	health.Error = ""
+	if err != nil {
+		result = 0
+		health.Failures++
+		health.Error = err.Error()
+	}
+
+	if health.Checks == 0 {
+		health.Score = result
+	} else {
+		health.Score = health.Score*(1-healthScoreWeight) + result*healthScoreWeight
+	}
+	health.Checks++
+	health.LatencyMS = latency
+	health.CheckedAt = time.Now()
+}
+
+func (p *SiteProber) request(target probeTarget) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, target.probeURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 解析接口未携带视频地址时可能返回 4xx，只要服务可响应即视为可用
+	if resp.StatusCode >= http.StatusInternalServerError ||
+		(target.validate != nil && resp.StatusCode >= http.StatusBadRequest) {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	if target.validate == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if !target.validate(body) {
+		return fmt.Errorf("unexpected response body")
+	}
+	return nil
+}
+
+// Score 返回指定地址的健康度，尚未检查过时返回 false
+func (p *SiteProber) Score(url string) (float64, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	health, ok := p.results[url]
+	if !ok {
+		return 0, false
+	}
+	return health.Score, true
+}
+
+// Results 返回所有检查结果，按类型与名称排序
+func (p *SiteProber) Results() []SiteHealth {
+	p.mu.RLock()
+	results := make([]SiteHealth, 0, len(p.results))
+	for _, health := range p.results {
+		results = append(results, *health)
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Target != results[j].Target {
+			return results[i].Target > results[j].Target
+		}
+		if results[i].Name != results[j].Name {
+			return results[i].Name < results[j].Name
+		}
+		return results[i].URL < results[j].URL
+	})
+	return results
+}
+
+// healthy 判断条目是否满足最低健康度要求，未检查过的条目视为满足
+// version 返回最近一次完成检查的时间, 依赖检查结果的缓存以此判断是否需要重新混合
+func (p *SiteProber) version() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.probedAt
+}
+
+func (p *SiteProber) healthy(url string, minHealth float64) bool {
+	if minHealth <= 0 {
+		return true
+	}
+	score, ok := p.Score(url)
+	return !ok || score >= minHealth
+}
+
+func (p *SiteProber) Close() {
+	p.done <- true
+}
+
+func isHTTPURL(link string) bool {
+	return strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://")
+}
+
+func appendQuery(link, query string) string {
+	if strings.Contains(link, "?") {
+		if strings.HasSuffix(link, "?") || strings.HasSuffix(link, "&") {
+			return link + query
+		}
+		return link + "&" + query
+	}
+	return link + "?" + query
+}
@@ -0,0 +1,111 @@
+package mixer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+func TestSiteProber(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			assert.Equal(t, "list", r.URL.Query().Get("ac"))
+			w.Write([]byte(`{"class":[],"list":[]}`))
+		case "/xml":
+			w.Write([]byte(`<?xml version="1.0"?><rss></rss>`))
+		case "/html":
+			w.Write([]byte(`<html>not json</html>`))
+		case "/parse":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer upstream.Close()
+
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				data: []byte(`{
+					"sites": [
+						{"key": "json", "name": "Json", "type": 1, "api": "` + upstream.URL + `/json"},
+						{"key": "xml", "name": "Xml", "type": 0, "api": "` + upstream.URL + `/xml"},
+						{"key": "html", "name": "Html", "type": 1, "api": "` + upstream.URL + `/html"},
+						{"key": "spider", "name": "Spider", "type": 3, "api": "csp_Spider"}
+					],
+					"parses": [
+						{"name": "Parse", "type": 0, "url": "` + upstream.URL + `/parse?url="},
+						{"name": "Dead", "type": 0, "url": "` + upstream.URL + `/dead?url="}
+					]
+				}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Sites: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "source1"}, MinHealth: 0.5},
+			},
+			Parses: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "source1"}, MinHealth: 0.5},
+			},
+			HealthCheck: config.SiteHealthCheckOpt{Enable: true},
+			SearchSite:  config.SearchSiteOpt{Enable: true},
+		},
+	}
+	cfg.Fixture()
+
+	prober := NewSiteProber(cfg, mockSourcer, nil)
+	defer prober.Close()
+	prober.ProbeAll()
+
+	// 聚合搜索站点不参与检查
+	results := prober.Results()
+	assert.Len(t, results, 5)
+	assert.Equal(t, HealthTargetSite, results[0].Target)
+	assert.Equal(t, "Html", results[0].Name)
+	assert.Equal(t, 0.0, results[0].Score)
+	assert.Equal(t, "unexpected response body", results[0].Error)
+	assert.Equal(t, "Json", results[1].Name)
+	assert.Equal(t, 1.0, results[1].Score)
+	assert.Equal(t, "Xml", results[2].Name)
+	assert.Equal(t, 1.0, results[2].Score)
+	assert.Equal(t, "Dead", results[3].Name)
+	assert.Equal(t, 0.0, results[3].Score)
+	assert.Equal(t, "Parse", results[4].Name)
+	assert.Equal(t, 1.0, results[4].Score)
+
+	t.Run("Score", func(t *testing.T) {
+		prober.ProbeAll()
+		score, ok := prober.Score(upstream.URL + "/json")
+		assert.True(t, ok)
+		assert.Equal(t, 1.0, score)
+		_, ok = prober.Score("csp_Spider")
+		assert.False(t, ok)
+	})
+
+	cfg.TvBoxSingleRepoOpt.SearchSite.Enable = false
+
+	t.Run("Exclude", func(t *testing.T) {
+		result, err := MixTvBoxRepo(cfg, mockSourcer, WithSiteProber(prober))
+		assert.NoError(t, err)
+		assert.Len(t, result.Sites, 3)
+		assert.Equal(t, "json", result.Sites[0].Key)
+		assert.Equal(t, "xml", result.Sites[1].Key)
+		assert.Equal(t, "spider", result.Sites[2].Key)
+		assert.Len(t, result.Parses, 1)
+		assert.Equal(t, "Parse", result.Parses[0].Name)
+	})
+
+	t.Run("NoThreshold", func(t *testing.T) {
+		cfg.TvBoxSingleRepoOpt.Sites[0].MinHealth = 0
+		result, err := MixTvBoxRepo(cfg, mockSourcer, WithSiteProber(prober))
+		assert.NoError(t, err)
+		assert.Len(t, result.Sites, 4)
+	})
+}
@@ -1,9 +1,12 @@
 package mixer
 
 import (
+	"bytes"
 	"fmt"
 	"log/slog"
 	"math"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -14,6 +17,13 @@ type Sourcer interface {
 	GetSource(name string) (*Source, error)
 }
 
+// SourceLister 是可以列出所有源名称的 Sourcer，用于按名称正则引用源
+type SourceLister interface {
+	SourceNames() []string
+	// SourceAvailable 判断按正则匹配到的源能否参与混合
+	SourceAvailable(name string) bool
+}
+
 // type SingleSourcer interface {
 // 	Sourcer
 // 	Type() config.SourceType
@@ -41,7 +51,8 @@ type Source struct {
 	data       []byte // Change this to []byte
 	lastError  time.Time
 	errorCount int
-	refreshing bool // 添加标志位
+	refreshing bool   // 添加标志位
+	parent     string // 由多仓展开的动态源所属的多仓源名称
 }
 
 func (s *Source) Data() []byte {
@@ -60,6 +71,11 @@ func (s *Source) Name() string {
 	return s.config.Name
 }
 
+// Parent 返回动态源所属的多仓源名称，非动态源返回空字符串
+func (s *Source) Parent() string {
+	return s.parent
+}
+
 func (s *Source) GetSource(_ string) ([]byte, error) {
 	return s.data, nil
 }
@@ -139,6 +155,107 @@ func (sm *SourceManager) GetSource(name string) (*Source, error) {
 	return source, nil
 }
 
+// SourceNames 返回所有源的名称，包括动态源，按名称排序
+func (sm *SourceManager) SourceNames() []string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	names := make([]string, 0, len(sm.sources))
+	for name := range sm.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SourceAvailable 判断源能否参与混合, 不可用时记录日志
+// 动态源在多仓刷新后由后台拉取, 尚未拉取成功的动态源直接跳过, 不在请求中逐个同步等待
+func (sm *SourceManager) SourceAvailable(name string) bool {
+	sm.mu.RLock()
+	source, ok := sm.sources[name]
+	pending := ok && source.parent != "" && source.data == nil
+	sm.mu.RUnlock()
+
+	if pending {
+		sm.log("skip source %s: not loaded yet", name)
+		return false
+	}
+	if _, err := sm.GetSource(name); err != nil {
+		sm.log("skip source %s: %v", name, err)
+		return false
+	}
+	return true
+}
+
+// syncDynamicSources 根据多仓源的内容同步其展开的动态源，调用方需持有写锁
+// 已存在的动态源保留其刷新与退避状态，多仓中已移除的仓库对应的动态源会被删除
+// 新增的动态源在后台预先拉取，混合时无需在请求中等待
+func (sm *SourceManager) syncDynamicSources(parent *Source) []string {
+	multiRepo, err := config.ParseTvBoxMultiRepoConfig(bytes.NewReader(parent.data))
+	if err != nil {
+		sm.log("expand source %s: %v", parent.Name(), err)
+		return nil
+	}
+
+	var added []string
+	current := make(map[string]bool, len(multiRepo.Repos))
+	for i, repo := range multiRepo.Repos {
+		if repo.URL == "" {
+			continue
+		}
+		repoName := repo.Name
+		if repoName == "" {
+			repoName = strconv.Itoa(i)
+		}
+		name := parent.Name() + "/" + repoName
+		if current[name] {
+			// 多仓中重名的仓库以序号区分, 避免每次刷新时相互覆盖
+			name += "#" + strconv.Itoa(i)
+		}
+		url := fullFillURL(repo.URL, parent)
+		current[name] = true
+
+		if existing, ok := sm.sources[name]; ok {
+			if existing.parent != parent.Name() {
+				continue // 与静态源重名时保留静态源
+			}
+			if existing.config.URL != url {
+				// 仓库地址变化时重置源的状态
+				sm.sources[name] = newDynamicSource(name, url, parent)
+				added = append(added, name)
+			}
+			continue
+		}
+
+		sm.sources[name] = newDynamicSource(name, url, parent)
+		added = append(added, name)
+	}
+
+	for name, source := range sm.sources {
+		if source.parent == parent.Name() && !current[name] {
+			delete(sm.sources, name)
+		}
+	}
+
+	for _, name := range added {
+		go sm.refreshSource(name) //nolint:errcheck // 异步拉取新的动态源
+	}
+
+	return added
+}
+
+func newDynamicSource(name, url string, parent *Source) *Source {
+	return &Source{
+		config: config.Source{
+			Name:     name,
+			URL:      url,
+			Type:     config.SourceTypeTvBoxSingle,
+			Interval: parent.config.Interval,
+		},
+		parent: parent.Name(),
+	}
+}
+
 func (sm *SourceManager) refreshSource(name string) error {
 	sm.mu.Lock()
 	source, ok := sm.sources[name]
@@ -186,7 +303,7 @@ func (sm *SourceManager) refreshSource(name string) error {
 		data, err = config.LoadTvBoxData(source.config.URL)
 	case config.SourceTypeEPG:
 		data, err = config.FetchData(source.config.URL)
-	case config.SourceTypeM3U:
+	case config.SourceTypeM3U, config.SourceTypeLiveTXT, config.SourceTypeAdblock:
 		data, err = config.FetchData(source.config.URL)
 	}
 
@@ -203,6 +320,10 @@ func (sm *SourceManager) refreshSource(name string) error {
 	source.lastUpdate = time.Now()
 	source.lastError = time.Time{}
 	source.errorCount = 0
+
+	if source.Type() == config.SourceTypeTvBoxMulti && source.config.Expand {
+		sm.syncDynamicSources(source)
+	}
 	return nil
 }
 
@@ -95,3 +95,136 @@ func TestRefreshSource(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 2, callCount)
 }
+
+func TestExpandMultiRepoSource(t *testing.T) {
+	repos := `{"urls":[{"url":"./a.json","name":"A"},{"url":"./b.json","name":"B"},{"url":"./dead.json","name":"Dead"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/multi.json":
+			w.Write([]byte(repos))
+		case "/a.json":
+			w.Write([]byte(`{"spider":"./a.jar","sites":[{"key":"a1","name":"A1"},{"key":"a2","name":"A2"}]}`))
+		case "/b.json":
+			w.Write([]byte(`{"spider":"./b.jar","sites":[{"key":"b1","name":"B1"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	sources := []config.Source{
+		{Name: "multi", URL: server.URL + "/multi.json", Type: config.SourceTypeTvBoxMulti, Interval: 60, Expand: true},
+	}
+
+	sm := NewSourceManager(sources, nil)
+	defer sm.Close()
+
+	_, err := sm.GetSource("multi")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"multi", "multi/A", "multi/B", "multi/Dead"}, sm.SourceNames())
+
+	// 动态源会在后台拉取
+	assert.Eventually(t, func() bool {
+		a, errA := sm.GetSource("multi/A")
+		b, errB := sm.GetSource("multi/B")
+		return errA == nil && errB == nil && a.Data() != nil && b.Data() != nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	a, _ := sm.GetSource("multi/A")
+	assert.Equal(t, server.URL+"/a.json", a.URL())
+	assert.Equal(t, "multi", a.Parent())
+
+	cfg := &config.Config{
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Spider: config.MixOpt{SourcePattern: "^multi/"},
+			Sites: []config.ArrayMixOpt{{
+				MixOpt:   config.MixOpt{SourcePattern: "^multi/"},
+				FilterBy: "key",
+				Exclude:  "a2",
+			}},
+		},
+	}
+	cfg.Fixture()
+
+	// 按正则匹配到的失效仓库被跳过
+	result, err := MixTvBoxRepo(cfg, sm)
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/a.jar", result.Spider)
+	assert.Len(t, result.Sites, 2)
+	assert.Equal(t, "a1", result.Sites[0].Key)
+	assert.Equal(t, "b1", result.Sites[1].Key)
+	assert.Equal(t, server.URL+"/b.jar", result.Sites[1].Jar)
+
+	// 多仓移除仓库后动态源也随之移除
+	repos = `{"urls":[{"url":"./a.json","name":"A"}]}`
+	assert.NoError(t, sm.refreshSource("multi"))
+	assert.Equal(t, []string{"multi", "multi/A"}, sm.SourceNames())
+	a2, _ := sm.GetSource("multi/A")
+	assert.Same(t, a, a2)
+
+	// 直接引用的源不可用时仍返回错误
+	cfg.TvBoxSingleRepoOpt.Sites = append(cfg.TvBoxSingleRepoOpt.Sites, config.ArrayMixOpt{
+		MixOpt: config.MixOpt{SourceName: "multi/Dead"},
+	})
+	_, err = MixTvBoxRepo(cfg, sm)
+	assert.Error(t, err)
+
+	// 重名的仓库以序号区分, 刷新时不会相互覆盖
+	repos = `{"urls":[{"url":"./a.json","name":"A"},{"url":"./b.json","name":"A"}]}`
+	assert.NoError(t, sm.refreshSource("multi"))
+	assert.Equal(t, []string{"multi", "multi/A", "multi/A#1"}, sm.SourceNames())
+	b, _ := sm.GetSource("multi/A#1")
+	assert.NoError(t, sm.refreshSource("multi"))
+	a3, _ := sm.GetSource("multi/A")
+	b2, _ := sm.GetSource("multi/A#1")
+	assert.Same(t, a, a3)
+	assert.Same(t, b, b2)
+	assert.Equal(t, server.URL+"/b.json", b2.URL())
+}
+
+func TestExpandMultiRepoSource_Pending(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/multi.json":
+			w.Write([]byte(`{"urls":[{"url":"./slow.json","name":"Slow"}]}`))
+		case "/slow.json":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+			w.Write([]byte(`{"sites":[{"key":"s1","name":"S1"}]}`))
+		}
+	}))
+	defer server.Close()
+
+	sm := NewSourceManager([]config.Source{
+		{Name: "multi", URL: server.URL + "/multi.json", Type: config.SourceTypeTvBoxMulti, Interval: 60, Expand: true},
+	}, nil)
+	defer sm.Close()
+
+	_, err := sm.GetSource("multi")
+	assert.NoError(t, err)
+
+	cfg := &config.Config{
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Sites: []config.ArrayMixOpt{{MixOpt: config.MixOpt{SourcePattern: "^multi/"}}},
+		},
+	}
+	cfg.Fixture()
+
+	// 尚未拉取完成的动态源被跳过, 不阻塞混合
+	start := time.Now()
+	result, err := MixTvBoxRepo(cfg, sm)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Sites)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// 后台拉取完成后参与混合
+	close(release)
+	assert.Eventually(t, func() bool {
+		result, err := MixTvBoxRepo(cfg, sm)
+		return err == nil && len(result.Sites) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,435 @@
+package mixer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+const (
+	// maxProbePlaylistBytes 为 HLS 播放列表最多读取的字节数
+	maxProbePlaylistBytes = 1 << 20
+	// maxProbePlaylistDepth 为主播放列表到媒体播放列表最多跟随的层数
+	maxProbePlaylistDepth = 3
+)
+
+// StreamHealth 记录轨道地址的可用性与首字节时间
+type StreamHealth struct {
+	URI       string    `json:"uri"`
+	Name      string    `json:"name"`
+	Alive     bool      `json:"alive"` // 最近一次检查是否可用
+	HLS       bool      `json:"hls"`
+	TTFBMS    int64     `json:"ttfb_ms"` // 首个请求的首字节时间
+	Checks    int       `json:"checks"`
+	Failures  int       `json:"failures"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// StreamProber 定期检查混合后的 M3U 轨道地址是否可用，并记录首字节时间
+type StreamProber struct {
+	cfg         *config.Config
+	sourcer     Sourcer
+	opts        []MixOption
+	client      *http.Client
+	timeout     time.Duration
+	concurrency int
+	maxBytes    int64
+	limiter     *rateLimiter
+	mu          sync.RWMutex
+	results     map[string]*StreamHealth // key 为轨道地址
+	ticker      *time.Ticker
+	done        chan bool
+	logger      *slog.Logger
+}
+
+// NewStreamProber 创建轨道检查器
+// opts 为混合播放列表时使用的依赖, 应与提供播放列表时的频道名称规范化一致, 使过滤条件作用于相同的频道名称
+func NewStreamProber(cfg *config.Config, sourcer Sourcer, logger *slog.Logger, opts ...MixOption) *StreamProber {
+	opt := cfg.M3UOpt.HealthCheck
+	interval := time.Duration(opt.Interval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Minute
+	}
+
+	p := &StreamProber{
+		cfg:         cfg,
+		sourcer:     sourcer,
+		opts:        opts,
+		client:      &http.Client{},
+		timeout:     time.Duration(opt.Timeout) * time.Second,
+		concurrency: max(opt.Concurrency, 1),
+		maxBytes:    opt.MaxBytes,
+		results:     make(map[string]*StreamHealth),
+		ticker:      time.NewTicker(interval),
+		done:        make(chan bool),
+	}
+	if opt.RateLimit > 0 {
+		p.limiter = &rateLimiter{rate: float64(opt.RateLimit) * 1024}
+	}
+
+	if logger != nil {
+		p.logger = logger.With("manager", "stream_prober")
+	}
+
+	go p.probeLoop()
+
+	return p
+}
+
+func (p *StreamProber) log(format string, args ...any) {
+	if p.logger != nil {
+		p.logger.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+func (p *StreamProber) probeLoop() {
+	p.ProbeAll()
+	for {
+		select {
+		case <-p.ticker.C:
+			p.ProbeAll()
+		case <-p.done:
+			p.ticker.Stop()
+			return
+		}
+	}
+}
+
+// ProbeAll 检查当前混合结果中的所有轨道地址
+// 检查时不进行去重, 以便备用地址同样得到检查
+func (p *StreamProber) ProbeAll() {
+	cfg := *p.cfg
+	cfg.M3UOpt.Dedup.Enable = false
+	playlist, err := MixM3UMediaPlayList(&cfg, p.sourcer, p.opts...)
+	if err != nil {
+		p.log("probe streams: %v", err)
+		return
+	}
+	if playlist == nil {
+		return
+	}
+
+	var tracks []m3u.Track
+	seen := make(map[string]bool)
+	for _, track := range playlist.Tracks {
+		if !isHTTPURL(track.URI) || seen[track.URI] {
+			continue
+		}
+		seen[track.URI] = true
+		tracks = append(tracks, track)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, p.concurrency)
+	for i := range tracks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(track m3u.Track) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			p.probe(track)
+		}(tracks[i])
+	}
+	wg.Wait()
+
+	// 移除已不在混合结果中的条目
+	p.mu.Lock()
+	for uri := range p.results {
+		if !seen[uri] {
+			delete(p.results, uri)
+		}
+	}
+	p.mu.Unlock()
+
+	p.log("probed %d streams", len(tracks))
+}
+
+func (p *StreamProber) probe(track m3u.Track) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	check := &streamCheck{prober: p, track: &track}
+	err := check.run(ctx, track.URI, 0)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	health, ok := p.results[track.URI]
+	if !ok {
+		health = &StreamHealth{URI: track.URI}
+		p.results[track.URI] = health
+	}
+	health.Name = track.Name
+	health.Checks++
+	health.Alive = err == nil
+	health.HLS = check.hls
+	health.TTFBMS = check.ttfb.Milliseconds()
+	health.Error = ""
+	if err != nil {
+		health.Failures++
+		health.Error = err.Error()
+	}
+	health.CheckedAt = time.Now()
+}
+
+// streamCheck 为一次轨道检查的状态
+type streamCheck struct {
+	prober *StreamProber
+	track  *m3u.Track
+	hls    bool
+	ttfb   time.Duration // 首个请求的首字节时间
+}
+
+// run 请求地址, HLS 播放列表会继续请求变体流或分片, 其余地址读取开头的数据
+func (c *streamCheck) run(ctx context.Context, link string, depth int) error {
+	start := time.Now()
+	resp, err := c.get(ctx, link)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 读到响应体的第一个字节时才计为首字节时间, 响应头可能早于数据返回
+	raw := bufio.NewReader(resp.Body)
+	if _, err := raw.Peek(1); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if depth == 0 {
+		c.ttfb = time.Since(start)
+	}
+
+	head, _ := raw.Peek(len("#EXTM3U"))
+	body := bufio.NewReader(c.prober.limiter.reader(raw))
+	if !isPlaylistResponse(resp, link) && !bytes.HasPrefix(head, []byte("#EXTM3U")) {
+		return c.readSample(body)
+	}
+
+	c.hls = true
+	if depth >= maxProbePlaylistDepth {
+		return errors.New("too many nested playlists")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxProbePlaylistBytes))
+	if err != nil {
+		return fmt.Errorf("read playlist: %w", err)
+	}
+	var playlist m3u.Playlist
+	if err := m3u.Unmarshal(data, &playlist); err != nil {
+		return fmt.Errorf("parse playlist: %w", err)
+	}
+
+	next, err := nextProbeURI(&playlist)
+	if err != nil {
+		return err
+	}
+	next, err = resolveURI(resp.Request.URL, next)
+	if err != nil {
+		return err
+	}
+
+	if playlist.IsMaster() {
+		return c.run(ctx, next, depth+1)
+	}
+	return c.fetchSegment(ctx, next)
+}
+
+// fetchSegment 请求分片并读取开头的数据
+func (c *streamCheck) fetchSegment(ctx context.Context, link string) error {
+	resp, err := c.get(ctx, link)
+	if err != nil {
+		return fmt.Errorf("segment: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := c.readSample(c.prober.limiter.reader(resp.Body)); err != nil {
+		return fmt.Errorf("segment: %w", err)
+	}
+	return nil
+}
+
+// readSample 读取最多 max_bytes 字节, 读到数据即视为可用
+func (c *streamCheck) readSample(r io.Reader) error {
+	n, err := io.CopyN(io.Discard, r, c.prober.maxBytes)
+	if n > 0 {
+		return nil
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		return errors.New("empty response")
+	}
+	return err
+}
+
+// get 发起请求, 使用轨道 #EXTVLCOPT 中的 http-user-agent 与 http-referrer
+func (c *streamCheck) get(ctx context.Context, link string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ua := c.track.GetVLCOpt("http-user-agent"); ua != "" {
+		req.Header.Set("User-Agent", ua)
+	}
+	if referrer := c.track.GetVLCOpt("http-referrer"); referrer != "" {
+		req.Header.Set("Referer", referrer)
+	}
+
+	resp, err := c.prober.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// isPlaylistResponse 根据 Content-Type 或地址后缀判断响应是否为 HLS 播放列表
+func isPlaylistResponse(resp *http.Response, link string) bool {
+	if strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "mpegurl") {
+		return true
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	return ext == ".m3u8" || ext == ".m3u"
+}
+
+// nextProbeURI 返回需要继续检查的地址
+// 主播放列表选择码率最低的变体流以节省流量, 媒体播放列表选择最后一个分片, 直播中较早的分片可能已过期
+func nextProbeURI(playlist *m3u.Playlist) (string, error) {
+	if playlist.IsMaster() {
+		var best m3u.VariantStream
+		for _, stream := range playlist.VariantStreams {
+			if stream.URI != "" && (best.URI == "" || stream.Bandwidth < best.Bandwidth) {
+				best = stream
+			}
+		}
+		if best.URI == "" {
+			return "", errors.New("no variant stream uri")
+		}
+		return best.URI, nil
+	}
+
+	for i := len(playlist.Tracks) - 1; i >= 0; i-- {
+		if playlist.Tracks[i].URI != "" {
+			return playlist.Tracks[i].URI, nil
+		}
+	}
+	return "", errors.New("empty playlist")
+}
+
+func resolveURI(base *url.URL, ref string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", fmt.Errorf("invalid uri %q: %w", ref, err)
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
+// Health 返回指定地址的检查结果，尚未检查过时返回 false
+func (p *StreamProber) Health(uri string) (StreamHealth, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	health, ok := p.results[uri]
+	if !ok {
+		return StreamHealth{}, false
+	}
+	return *health, true
+}
+
+// Results 返回所有检查结果，按名称与地址排序
+func (p *StreamProber) Results() []StreamHealth {
+	p.mu.RLock()
+	results := make([]StreamHealth, 0, len(p.results))
+	for _, health := range p.results {
+		results = append(results, *health)
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Name != results[j].Name {
+			return results[i].Name < results[j].Name
+		}
+		return results[i].URI < results[j].URI
+	})
+	return results
+}
+
+// alive 判断地址是否可用，未检查过的地址视为可用
+func (p *StreamProber) alive(uri string) bool {
+	health, ok := p.Health(uri)
+	return !ok || health.Alive
+}
+
+// ttfb 返回可用地址的首字节时间，未检查过或不可用时返回 false
+func (p *StreamProber) ttfb(uri string) (time.Duration, bool) {
+	health, ok := p.Health(uri)
+	if !ok || !health.Alive {
+		return 0, false
+	}
+	return time.Duration(health.TTFBMS) * time.Millisecond, true
+}
+
+func (p *StreamProber) Close() {
+	p.done <- true
+}
+
+// rateLimiter 限制所有检查合计的下载速率, 为 nil 时不限制
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64 // 字节每秒
+	next time.Time
+}
+
+// wait 为已读取的 n 字节等待相应的时间
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &rateLimitedReader{r: r, limiter: l}
+}
+
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,165 @@
+package mixer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/chname"
+)
+
+func TestStreamProber(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]string) // path -> User-Agent
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = r.UserAgent()
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/live/master.m3u8":
+			w.Write([]byte("#EXTM3U\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2000000\nhi/index.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=500000\nlo/index.m3u8\n"))
+		case "/live/lo/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:1\n" +
+				"#EXTINF:6.0,\nseg1.ts\n#EXTINF:6.0,\nseg2.ts\n"))
+		case "/live/lo/seg2.ts", "/plain":
+			w.Write(make([]byte, 1024))
+		case "/slow.ts":
+			// 先返回响应头, 数据延迟到达
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+			w.Write(make([]byte, 1024))
+		case "/expired.m3u8":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Write([]byte("#EXTM3U\n#EXTINF:6.0,\n/missing.ts\n"))
+		case "/empty.ts":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"m3u_a": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1,CCTV1\n" + upstream.URL + "/slow.ts\n" +
+					"#EXTINF:-1,CCTV1\n" + upstream.URL + "/gone.ts\n" +
+					"#EXTINF:-1,CCTV2\n" + upstream.URL + "/live/master.m3u8\n" +
+					"#EXTINF:-1,CCTV3\n" + upstream.URL + "/expired.m3u8\n" +
+					"#EXTINF:-1,CCTV4\n" + upstream.URL + "/empty.ts\n" +
+					"#EXTINF:-1,CCTV5\nrtmp://example.com/live\n"),
+			},
+			"m3u_b": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1,CCTV1\n#EXTVLCOPT:http-user-agent=okhttp\n" + upstream.URL + "/plain\n"),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		M3UOpt: config.M3UOpt{
+			MediaPlaylistFilters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "m3u_a"}},
+				{MixOpt: config.MixOpt{SourceName: "m3u_b"}},
+			},
+			Dedup: config.M3UDedupOpt{Enable: true},
+			HealthCheck: config.StreamHealthCheckOpt{
+				Enable:         true,
+				RateLimit:      1024,
+				DropDead:       true,
+				SortAlternates: true,
+			},
+		},
+	}
+	cfg.Fixture()
+
+	prober := NewStreamProber(cfg, mockSourcer, nil)
+	defer prober.Close()
+	prober.ProbeAll()
+
+	results := prober.Results()
+	assert.Len(t, results, 6)
+
+	health := make(map[string]StreamHealth)
+	for _, result := range results {
+		health[result.URI] = result
+	}
+
+	assert.True(t, health[upstream.URL+"/slow.ts"].Alive)
+	assert.GreaterOrEqual(t, health[upstream.URL+"/slow.ts"].TTFBMS, int64(100))
+	assert.True(t, health[upstream.URL+"/plain"].Alive)
+	assert.False(t, health[upstream.URL+"/plain"].HLS)
+	assert.False(t, health[upstream.URL+"/gone.ts"].Alive)
+	assert.Equal(t, "unexpected status: 404 Not Found", health[upstream.URL+"/gone.ts"].Error)
+
+	assert.True(t, health[upstream.URL+"/live/master.m3u8"].Alive)
+	assert.True(t, health[upstream.URL+"/live/master.m3u8"].HLS)
+	assert.False(t, health[upstream.URL+"/expired.m3u8"].Alive)
+	assert.Equal(t, "segment: unexpected status: 404 Not Found", health[upstream.URL+"/expired.m3u8"].Error)
+	assert.False(t, health[upstream.URL+"/empty.ts"].Alive)
+	assert.Equal(t, "empty response", health[upstream.URL+"/empty.ts"].Error)
+
+	mu.Lock()
+	// 选择码率最低的变体流与最后一个分片
+	assert.Contains(t, requested, "/live/lo/seg2.ts")
+	assert.NotContains(t, requested, "/live/hi/index.m3u8")
+	assert.NotContains(t, requested, "/live/lo/seg1.ts")
+	assert.Equal(t, "okhttp", requested["/plain"])
+	mu.Unlock()
+
+	t.Run("Mix", func(t *testing.T) {
+		result, err := MixM3UMediaPlayList(cfg, mockSourcer, WithStreamProber(prober))
+		assert.NoError(t, err)
+
+		var uris []string
+		for _, track := range result.Tracks {
+			uris = append(uris, track.URI)
+		}
+		// 不可用的轨道被移除, 同一频道的地址按首字节时间排列, 未检查的地址保留
+		assert.Equal(t, []string{
+			upstream.URL + "/plain",
+			upstream.URL + "/slow.ts",
+			upstream.URL + "/live/master.m3u8",
+			"rtmp://example.com/live",
+		}, uris)
+	})
+
+	t.Run("Normalizer", func(t *testing.T) {
+		cfg := &config.Config{
+			M3UOpt: config.M3UOpt{
+				MediaPlaylistFilters: []config.ArrayMixOpt{
+					// 原始名称为 CCTV-1 综合, 规范化后才能匹配
+					{MixOpt: config.MixOpt{SourceName: "m3u_c"}, FilterBy: "name", Include: "^CCTV1$"},
+				},
+				HealthCheck: config.StreamHealthCheckOpt{Enable: true},
+			},
+		}
+		cfg.Fixture()
+		mockSourcer.sources["m3u_c"] = &Source{
+			config: config.Source{Type: config.SourceTypeM3U},
+			data:   []byte("#EXTM3U\n#EXTINF:-1,CCTV-1 综合\n" + upstream.URL + "/plain\n"),
+		}
+
+		prober := NewStreamProber(cfg, mockSourcer, nil, WithChannelNormalizer(chname.NewNormalizer(nil)))
+		defer prober.Close()
+		prober.ProbeAll()
+
+		results := prober.Results()
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, upstream.URL+"/plain", results[0].URI)
+			assert.True(t, results[0].Alive)
+		}
+	})
+}
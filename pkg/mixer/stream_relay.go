@@ -0,0 +1,354 @@
+package mixer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/m3u"
+)
+
+// maxRelayPlaylistBytes 为中转时最多读取的 HLS 播放列表字节数
+const maxRelayPlaylistBytes = 4 << 20
+
+// ErrInvalidRelayToken 表示中转地址无法解析或签名不匹配
+var ErrInvalidRelayToken = errors.New("invalid relay token")
+
+// reURIAttribute 匹配标签中的 URI 属性
+var reURIAttribute = regexp.MustCompile(`([:,]\s*)URI="([^"]*)"`)
+
+// relayHeaders 为转发给客户端的上游响应头
+var relayHeaders = []string{"Content-Type", "Content-Range", "Accept-Ranges", "Cache-Control", "Last-Modified"}
+
+// relayTarget 为中转地址中携带的上游地址及请求头
+type relayTarget struct {
+	URL       string `json:"u"`
+	UserAgent string `json:"ua,omitempty"`
+	Referrer  string `json:"r,omitempty"`
+}
+
+// StreamRelay 中转选中的 M3U 轨道
+// 轨道地址改写为 /v1/m3u/relay/{token}/{file}, token 中携带签名后的上游地址与轨道的请求头,
+// HLS 播放列表中的变体流、分片与密钥地址同样改写为中转地址, 其余内容通过固定大小的缓冲区流式转发
+type StreamRelay struct {
+	cfg        *config.Config
+	filter     *trackFilter
+	secret     []byte
+	client     *http.Client
+	timeout    time.Duration
+	bufferSize int
+	logger     *slog.Logger
+}
+
+func NewStreamRelay(cfg *config.Config, logger *slog.Logger) (*StreamRelay, error) {
+	opt := cfg.M3UOpt.Relay
+
+	// 随机生成的密钥在重启或多实例部署时不一致, 已下发的中转地址会失效
+	if opt.Secret == "" {
+		return nil, errors.New("stream relay requires secret")
+	}
+	secret := []byte(opt.Secret)
+
+	timeout := time.Duration(opt.Timeout) * time.Second
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// 非 HLS 的直播流可能持续转发, 不限制整个请求的时间, 只限制等待响应头及每次读取的时间
+	transport.ResponseHeaderTimeout = timeout
+
+	r := &StreamRelay{
+		cfg:        cfg,
+		filter:     newTrackFilter(config.ArrayMixOpt{Conditions: opt.Conditions, Match: opt.Match}),
+		secret:     secret,
+		client:     &http.Client{Transport: transport},
+		timeout:    timeout,
+		bufferSize: max(opt.BufferSize, 1) << 10,
+	}
+
+	if logger != nil {
+		r.logger = logger.With("manager", "stream_relay")
+	}
+
+	return r, nil
+}
+
+func (r *StreamRelay) log(format string, args ...any) {
+	if r.logger != nil {
+		r.logger.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+// relayTracks 将选中轨道的地址改写为中转地址
+func (r *StreamRelay) relayTracks(tracks []m3u.Track) {
+	for i := range tracks {
+		track := &tracks[i]
+		if !isHTTPURL(track.URI) || !r.filter.matchTrack(track) {
+			continue
+		}
+		track.URI = r.relayURL(relayTarget{
+			URL:       track.URI,
+			UserAgent: track.GetVLCOpt("http-user-agent"),
+			Referrer:  track.GetVLCOpt("http-referrer"),
+		})
+	}
+}
+
+// relayURL 返回上游地址对应的中转地址, 末尾保留上游的文件名以便播放器识别格式
+func (r *StreamRelay) relayURL(target relayTarget) string {
+	data, _ := json.Marshal(target) //nolint:errchkjson // relayTarget 只包含字符串字段
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return getExternalURL(r.cfg) + "/v1/m3u/relay/" + payload + "." + r.sign(payload) + "/" + relayFileName(target.URL)
+}
+
+func (r *StreamRelay) sign(payload string) string {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// decode 校验签名并解析中转地址中的上游信息
+func (r *StreamRelay) decode(token string) (relayTarget, error) {
+	var target relayTarget
+
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(r.sign(payload))) {
+		return target, ErrInvalidRelayToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return target, ErrInvalidRelayToken
+	}
+	if err := json.Unmarshal(data, &target); err != nil || !isHTTPURL(target.URL) {
+		return target, ErrInvalidRelayToken
+	}
+
+	return target, nil
+}
+
+func relayFileName(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "stream"
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return "stream"
+	}
+	return url.PathEscape(name)
+}
+
+// RelayResponse 为中转的响应
+// 上游为 HLS 播放列表时, 改写后的内容放在 Playlist 中, 否则通过 Body 流式转发, 调用方负责关闭 Body
+type RelayResponse struct {
+	StatusCode    int
+	Header        http.Header
+	ContentLength int64 // 未知时为 -1
+	Playlist      []byte
+	Body          io.ReadCloser
+}
+
+// relayBody 通过固定大小的缓冲区读取上游响应, 关闭时关闭上游连接
+type relayBody struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// deadlineReader 为每次读取上游响应设置期限, 超时后关闭上游连接
+// 上游发送响应头后停滞时, 阻塞的读取随之返回, 不会一直占用转发
+type deadlineReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func newDeadlineReader(body io.ReadCloser, timeout time.Duration) *deadlineReader {
+	timer := time.AfterFunc(timeout, func() { body.Close() })
+	timer.Stop()
+	return &deadlineReader{body: body, timeout: timeout, timer: timer}
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	r.timer.Reset(r.timeout)
+	defer r.timer.Stop()
+	return r.body.Read(p)
+}
+
+func (r *deadlineReader) Close() error {
+	r.timer.Stop()
+	return r.body.Close()
+}
+
+// Open 请求中转地址对应的上游
+// 请求头依次使用 headers 配置与轨道 #EXTVLCOPT 中的 http-user-agent、http-referrer, 并转发客户端的 Range 请求头
+func (r *StreamRelay) Open(ctx context.Context, token, rangeHeader string) (*RelayResponse, error) {
+	target, err := r.decode(token)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("relay %s: %w", target.URL, err)
+	}
+	for key, value := range r.cfg.M3UOpt.Relay.Headers {
+		req.Header.Set(key, value)
+	}
+	if target.UserAgent != "" {
+		req.Header.Set("User-Agent", target.UserAgent)
+	}
+	if target.Referrer != "" {
+		req.Header.Set("Referer", target.Referrer)
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("relay %s: %w", target.URL, err)
+	}
+
+	result := &RelayResponse{
+		StatusCode:    resp.StatusCode,
+		Header:        make(http.Header),
+		ContentLength: resp.ContentLength,
+	}
+	for _, key := range relayHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			result.Header.Set(key, value)
+		}
+	}
+
+	upstream := newDeadlineReader(resp.Body, r.timeout)
+	body := bufio.NewReaderSize(upstream, r.bufferSize)
+	if resp.StatusCode == http.StatusOK {
+		head, _ := body.Peek(len("#EXTM3U"))
+		if isPlaylistResponse(resp, target.URL) || bytes.HasPrefix(head, []byte("#EXTM3U")) {
+			defer upstream.Close()
+			// 播放列表需要完整读取后改写, 超时后关闭连接以免长时间占用
+			timer := time.AfterFunc(r.timeout, func() { resp.Body.Close() })
+			defer timer.Stop()
+
+			data, err := io.ReadAll(io.LimitReader(body, maxRelayPlaylistBytes))
+			if err != nil {
+				return nil, fmt.Errorf("relay %s: read playlist: %w", target.URL, err)
+			}
+
+			result.Playlist = r.rewritePlaylist(data, resp.Request.URL, target)
+			result.ContentLength = int64(len(result.Playlist))
+			result.Header.Set("Content-Type", "application/vnd.apple.mpegurl")
+			result.Header.Set("Cache-Control", "no-cache")
+			return result, nil
+		}
+	}
+
+	result.Body = relayBody{Reader: body, Closer: upstream}
+	return result, nil
+}
+
+// rewritePlaylist 将 HLS 播放列表中的变体流、备选媒体、I 帧流、分片、密钥与初始化分片地址改写为中转地址
+// 无法解析的播放列表逐行改写
+func (r *StreamRelay) rewritePlaylist(data []byte, base *url.URL, target relayTarget) []byte {
+	var playlist m3u.Playlist
+	if err := m3u.Unmarshal(data, &playlist); err != nil {
+		r.log("parse playlist %s: %v", target.URL, err)
+		return r.rewriteLines(data, base, target)
+	}
+
+	relay := func(uri *string) {
+		if *uri != "" {
+			*uri = r.relayRef(base, *uri, target)
+		}
+	}
+	for i := range playlist.VariantStreams {
+		relay(&playlist.VariantStreams[i].URI)
+	}
+	for i := range playlist.Renditions {
+		relay(&playlist.Renditions[i].URI)
+	}
+	for i := range playlist.IFrameStreams {
+		relay(&playlist.IFrameStreams[i].URI)
+	}
+	for i := range playlist.Tracks {
+		track := &playlist.Tracks[i]
+		relay(&track.URI)
+		for j := range track.Keys {
+			relay(&track.Keys[j].URI)
+		}
+		if track.Map != nil {
+			relay(&track.Map.URI)
+		}
+		for j := range track.Extensions {
+			track.Extensions[j] = r.rewriteURIAttributes(track.Extensions[j], base, target)
+		}
+	}
+	for i := range playlist.Extensions {
+		playlist.Extensions[i] = r.rewriteURIAttributes(playlist.Extensions[i], base, target)
+	}
+	for i := range playlist.TrailingExtensions {
+		playlist.TrailingExtensions[i] = r.rewriteURIAttributes(playlist.TrailingExtensions[i], base, target)
+	}
+
+	out, err := m3u.Marshal(&playlist)
+	if err != nil {
+		r.log("encode playlist %s: %v", target.URL, err)
+		return r.rewriteLines(data, base, target)
+	}
+	return out
+}
+
+// rewriteLines 逐行改写播放列表, 地址行与标签中的 URI 属性改写为中转地址
+func (r *StreamRelay) rewriteLines(data []byte, base *url.URL, target relayTarget) []byte {
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), maxRelayPlaylistBytes)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			line = r.rewriteURIAttributes(line, base, target)
+		default:
+			line = r.relayRef(base, line, target)
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		r.log("rewrite playlist %s: %v", target.URL, err)
+	}
+
+	return buf.Bytes()
+}
+
+// rewriteURIAttributes 改写标签中的 URI 属性, 例如 EXT-X-SESSION-KEY、EXT-X-PART
+func (r *StreamRelay) rewriteURIAttributes(line string, base *url.URL, target relayTarget) string {
+	return reURIAttribute.ReplaceAllStringFunc(line, func(attr string) string {
+		match := reURIAttribute.FindStringSubmatch(attr)
+		return match[1] + `URI="` + r.relayRef(base, match[2], target) + `"`
+	})
+}
+
+// relayRef 将播放列表中的相对或绝对地址改写为中转地址, 沿用原轨道的请求头
+func (r *StreamRelay) relayRef(base *url.URL, ref string, target relayTarget) string {
+	link, err := resolveURI(base, ref)
+	if err != nil || !isHTTPURL(link) {
+		return ref
+	}
+	target.URL = link
+	return r.relayURL(target)
+}
@@ -0,0 +1,206 @@
+package mixer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wayjam/tv-mixproxy/config"
+)
+
+// relayToken 从中转地址中取出 token
+func relayToken(t *testing.T, link string) string {
+	t.Helper()
+	rest, ok := strings.CutPrefix(link, "http://proxy/v1/m3u/relay/")
+	assert.True(t, ok, link)
+	token, _, _ := strings.Cut(rest, "/")
+	return token
+}
+
+func TestStreamRelay(t *testing.T) {
+	var headers []http.Header
+	stall := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		switch r.URL.Path {
+		case "/live/master.m3u8":
+			w.Write([]byte("#EXTM3U\n" +
+				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"中文\",URI=\"audio/index.m3u8\"\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=800000,AUDIO=\"aac\"\n" +
+				"low/index.m3u8\n"))
+		case "/live/low/index.m3u8":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Write([]byte("#EXTM3U\n" +
+				"#EXT-X-TARGETDURATION:6\n" +
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/1.key\",IV=0x1\n" +
+				"#EXTINF:6.0,\n" +
+				"seg1.ts\n"))
+		case "/live/low/seg1.ts":
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Write([]byte(strings.Repeat("G", 4096)))
+		case "/live/ll/index.m3u8":
+			w.Write([]byte("#EXTM3U\n" +
+				"#EXT-X-TARGETDURATION:4\n" +
+				"#EXTINF:4.0,\n" +
+				"seg1.m4s\n" +
+				"#EXT-X-PART:DURATION=0.5,URI=\"seg2.0.m4s\"\n" +
+				"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"seg2.1.m4s\"\n" +
+				"#EXT-X-RENDITION-REPORT:URI=\"../low/index.m3u8\",LAST-MSN=2\n"))
+		case "/live/stall.ts":
+			// 发送响应头后停滞
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+	defer close(stall)
+
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"m3u": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1 group-title=\"卫视\",湖南卫视\n" +
+					"#EXTVLCOPT:http-user-agent=Player/1.0\n" +
+					"#EXTVLCOPT:http-referrer=http://example.com/\n" +
+					upstream.URL + "/live/master.m3u8\n" +
+					"#EXTINF:-1 group-title=\"央视\",CCTV1\nhttp://a/cctv1.m3u8\n"),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://proxy",
+		M3UOpt: config.M3UOpt{
+			MediaPlaylistFilters: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "m3u"}},
+			},
+			Relay: config.StreamRelayOpt{
+				Enable:     true,
+				Conditions: []config.FilterCondition{{FilterBy: "group-title", Include: "卫视"}},
+				Headers:    map[string]string{"X-Token": "secret", "User-Agent": "Default/1.0"},
+				Secret:     "test-secret",
+			},
+		},
+		EPGOpt: config.EPGOpt{Disable: true},
+	}
+	cfg.Fixture()
+
+	relay, err := NewStreamRelay(cfg, nil)
+	assert.NoError(t, err)
+
+	result, err := MixM3UMediaPlayList(cfg, mockSourcer, WithStreamRelay(relay))
+	assert.NoError(t, err)
+	assert.Len(t, result.Tracks, 2)
+	assert.True(t, strings.HasSuffix(result.Tracks[0].URI, "/master.m3u8"), result.Tracks[0].URI)
+	assert.Equal(t, "http://a/cctv1.m3u8", result.Tracks[1].URI)
+
+	ctx := context.Background()
+
+	// 主播放列表中的备选媒体与变体流地址改写为中转地址
+	resp, err := relay.Open(ctx, relayToken(t, result.Tracks[0].URI), "")
+	assert.NoError(t, err)
+	assert.Nil(t, resp.Body)
+	assert.Equal(t, "application/vnd.apple.mpegurl", resp.Header.Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(string(resp.Playlist)), "\n")
+	assert.Len(t, lines, 4)
+	assert.Contains(t, lines[1], `NAME="中文",URI="http://proxy/v1/m3u/relay/`)
+	assert.True(t, strings.HasSuffix(lines[1], `/index.m3u8"`))
+	assert.Equal(t, "#EXT-X-STREAM-INF:BANDWIDTH=800000,AUDIO=\"aac\"", lines[2])
+	assert.True(t, strings.HasPrefix(lines[3], "http://proxy/v1/m3u/relay/"))
+
+	// 轨道的 #EXTVLCOPT 优先于 headers 配置
+	assert.Equal(t, "Player/1.0", headers[0].Get("User-Agent"))
+	assert.Equal(t, "http://example.com/", headers[0].Get("Referer"))
+	assert.Equal(t, "secret", headers[0].Get("X-Token"))
+
+	resp, err = relay.Open(ctx, relayToken(t, lines[3]), "")
+	assert.NoError(t, err)
+	lines = strings.Split(strings.TrimSpace(string(resp.Playlist)), "\n")
+	assert.Len(t, lines, 5)
+	assert.True(t, strings.HasPrefix(lines[2], `#EXT-X-KEY:METHOD=AES-128,URI="http://proxy/v1/m3u/relay/`), lines[2])
+	assert.True(t, strings.HasSuffix(lines[2], `/1.key",IV=0x1`), lines[2])
+	assert.Equal(t, "#EXTINF:6.000000,", lines[3])
+
+	// 分片流式转发, 并沿用原轨道的请求头
+	resp, err = relay.Open(ctx, relayToken(t, lines[4]), "bytes=0-1023")
+	assert.NoError(t, err)
+	assert.NotNil(t, resp.Body)
+	assert.Equal(t, "video/mp2t", resp.Header.Get("Content-Type"))
+	data, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Len(t, data, 4096)
+	last := headers[len(headers)-1]
+	assert.Equal(t, "Player/1.0", last.Get("User-Agent"))
+	assert.Equal(t, "bytes=0-1023", last.Get("Range"))
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		token := relayToken(t, result.Tracks[0].URI)
+		_, err := relay.Open(ctx, token+"x", "")
+		assert.ErrorIs(t, err, ErrInvalidRelayToken)
+		_, err = relay.Open(ctx, "bm90LWpzb24", "")
+		assert.ErrorIs(t, err, ErrInvalidRelayToken)
+
+		// 不同密钥签发的地址无效
+		otherCfg := *cfg
+		otherCfg.M3UOpt.Relay.Secret = "other-secret"
+		other, err := NewStreamRelay(&otherCfg, nil)
+		assert.NoError(t, err)
+		_, err = other.Open(ctx, token, "")
+		assert.ErrorIs(t, err, ErrInvalidRelayToken)
+	})
+
+	t.Run("TrailingTags", func(t *testing.T) {
+		// 最后一个分片之后的 LL-HLS 标签中的地址同样改写为中转地址
+		link := relay.relayURL(relayTarget{URL: upstream.URL + "/live/ll/index.m3u8"})
+		resp, err := relay.Open(ctx, relayToken(t, link), "")
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(resp.Playlist)), "\n")
+		assert.Len(t, lines, 7)
+		assert.True(t, strings.HasPrefix(lines[4], `#EXT-X-PART:DURATION=0.5,URI="http://proxy/v1/m3u/relay/`), lines[4])
+		assert.True(t, strings.HasSuffix(lines[4], `/seg2.0.m4s"`), lines[4])
+		assert.True(t, strings.HasPrefix(lines[5], `#EXT-X-PRELOAD-HINT:TYPE=PART,URI="http://proxy/v1/m3u/relay/`), lines[5])
+		assert.True(t, strings.HasPrefix(lines[6], `#EXT-X-RENDITION-REPORT:URI="http://proxy/v1/m3u/relay/`), lines[6])
+		assert.True(t, strings.HasSuffix(lines[6], `/index.m3u8",LAST-MSN=2`), lines[6])
+	})
+
+	t.Run("EmptySecret", func(t *testing.T) {
+		emptyCfg := *cfg
+		emptyCfg.M3UOpt.Relay.Secret = ""
+		_, err := NewStreamRelay(&emptyCfg, nil)
+		assert.ErrorContains(t, err, "requires secret")
+	})
+
+	t.Run("Stalled", func(t *testing.T) {
+		stallCfg := *cfg
+		stallCfg.M3UOpt.Relay.Timeout = 1
+		stalled, err := NewStreamRelay(&stallCfg, nil)
+		assert.NoError(t, err)
+
+		link := stalled.relayURL(relayTarget{URL: upstream.URL + "/live/stall.ts"})
+		resp, err := stalled.Open(ctx, relayToken(t, link), "")
+		assert.NoError(t, err)
+		assert.NotNil(t, resp.Body)
+
+		// 上游停滞超过超时时间后读取返回错误, 不会一直阻塞
+		start := time.Now()
+		_, err = io.ReadAll(resp.Body)
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 5*time.Second)
+		resp.Body.Close()
+	})
+}
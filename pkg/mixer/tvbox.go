@@ -3,24 +3,32 @@ package mixer
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
 	"regexp"
 	"strings"
 
 	"github.com/tidwall/gjson"
 
 	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/adblock"
 )
 
 // MixTvBoxRepo 函数根据配置混合多个单仓源
 func MixTvBoxRepo(
-	cfg *config.Config, sourcer Sourcer,
+	cfg *config.Config, sourcer Sourcer, opts ...MixOption,
 ) (*config.TvBoxRepoConfig, error) {
+	o := newMixOptions(opts)
 	result := &config.TvBoxRepoConfig{
 		Wallpaper: getExternalURL(cfg) + "/wallpaper?bg_color=333333&border_width=5&border_color=666666",
 		Logo:      getExternalURL(cfg) + "/logo",
 		Spider:    getExternalURL(cfg) + "/v1/tvbox/spider",
 	}
-	singleRepoOpt := cfg.TvBoxSingleRepoOpt
+	singleRepoOpt, err := resolveSingleRepoOpt(cfg.TvBoxSingleRepoOpt, sourcer)
+	if err != nil {
+		return result, fmt.Errorf("resolving source pattern: %w", err)
+	}
+	mixedSpider := false
 
 	// 混合 spider 字段
 	if !singleRepoOpt.Spider.Disabled && singleRepoOpt.Spider.SourceName != "" {
@@ -31,6 +39,7 @@ func MixTvBoxRepo(
 		if spider != "" {
 			spider = fullFillURL(spider, source)
 			result.Spider = spider
+			mixedSpider = true
 		}
 	}
 
@@ -62,13 +71,29 @@ func MixTvBoxRepo(
 		if err != nil {
 			return result, fmt.Errorf("mixing sites: %w", err)
 		}
+		// 站点所在源的 spider 与输出的 spider 不一致时，需要为站点单独指定 jar
+		sourceSpider := getSourceSpider(source)
 		for i := range sites {
 			site := processSiteFields(sites[i], source)
+			if o.siteProber != nil && !o.siteProber.healthy(site.API, siteOpt.MinHealth) {
+				continue
+			}
+			if site.Jar == "" && sourceSpider != "" && !isSameSpider(sourceSpider, result.Spider) {
+				site.Jar = sourceSpider
+				result.JarInjectedSites = append(result.JarInjectedSites, site.Key)
+			}
 			result.Sites = append(result.Sites, site)
 		}
 	}
 
+	if singleRepoOpt.SearchSite.Enable {
+		result.Sites = append([]config.TvBoxSite{newSearchSite(cfg)}, result.Sites...)
+	}
+
 	// Mix DOH array
+	if cfg.DOHOpt.Enable && cfg.DOHOpt.Inject && o.dohResolver != nil {
+		result.DOH = append(result.DOH, newSelfDOH(cfg))
+	}
 	for _, dohOpt := range singleRepoOpt.DOH {
 		doh, source, err := mixArrayFieldAndGetSource[config.TvBoxDOH](dohOpt, sourcer)
 		if err != nil {
@@ -81,17 +106,32 @@ func MixTvBoxRepo(
 	}
 
 	// Mix lives array
+	if singleRepoOpt.M3ULive.Enable && !cfg.M3UOpt.Disable {
+		result.Lives = append(result.Lives, newM3ULive(cfg))
+	}
 	for _, liveOpt := range singleRepoOpt.Lives {
-		lives, source, err := mixArrayFieldAndGetSource[config.TvBoxLive](liveOpt, sourcer)
+		lives, source, err := mixLivesAndGetSource(liveOpt, sourcer, o)
 		if err != nil {
 			return result, fmt.Errorf("mixing lives: %w", err)
 		}
 		for i := range lives {
 			live := processLiveFields(lives[i], source)
+			o.normalizeLive(&live)
 			result.Lives = append(result.Lives, live)
 		}
 	}
 
+	// 为未声明 epg 或 logo 的 lives 设置本服务的 EPG 与台标接口, 上游已声明的地址保持不变
+	// 配置了台标补全时台标接口不依赖 EPG
+	for i := range result.Lives {
+		if cfg.EPGOpt.LiveEPG && !cfg.EPGOpt.Disable && result.Lives[i].EPG == "" {
+			result.Lives[i].EPG = liveEPGURL(cfg)
+		}
+		if cfg.EPGOpt.LiveLogo && (!cfg.EPGOpt.Disable || cfg.LogoOpt.Enabled()) && result.Lives[i].Logo == "" {
+			result.Lives[i].Logo = liveLogoURL(cfg)
+		}
+	}
+
 	// Mix parses array
 	for _, parseOpt := range singleRepoOpt.Parses {
 		parses, source, err := mixArrayFieldAndGetSource[config.TvBoxParse](parseOpt, sourcer)
@@ -100,6 +140,9 @@ func MixTvBoxRepo(
 		}
 		for i := range parses {
 			parse := processParseFields(parses[i], source)
+			if o.siteProber != nil && !o.siteProber.healthy(parse.URL, parseOpt.MinHealth) {
+				continue
+			}
 			result.Parses = append(result.Parses, parse)
 		}
 	}
@@ -124,16 +167,146 @@ func MixTvBoxRepo(
 
 	// Mix ads array
 	for _, adOpt := range singleRepoOpt.Ads {
-		ads, err := mixArrayField[string](adOpt, sourcer)
+		ads, err := mixAds(adOpt, sourcer)
 		if err != nil {
 			return result, fmt.Errorf("mixing ads: %w", err)
 		}
 		result.Ads = append(result.Ads, ads...)
 	}
+	result.Ads = dedupeStrings(result.Ads)
+
+	// Mix extra fields
+	if err := mixExtraFields(singleRepoOpt.ExtraFields, sourcer, result); err != nil {
+		return result, fmt.Errorf("mixing extra fields: %w", err)
+	}
+
+	// 将 spider 与站点 jar 替换为缓存代理地址
+	if mixedSpider {
+		result.Spider = o.cacheJar(cfg, result.Spider)
+	}
+	for i := range result.Sites {
+		result.Sites[i].Jar = o.cacheJar(cfg, result.Sites[i].Jar)
+	}
+
+	return result, nil
+}
+
+// resolveSingleRepoOpt 将配置中的 source_pattern 解析为具体的源
+// 按正则匹配到的源不可用时跳过并记录日志, 多仓中失效的仓库很常见, 不应导致整个单仓混合失败;
+// 直接通过 source_name 引用的源仍在混合时返回错误
+func resolveSingleRepoOpt(opt config.TvBoxSingleRepoOpt, sourcer Sourcer) (config.TvBoxSingleRepoOpt, error) {
+	lister, ok := sourcer.(SourceLister)
+	if !ok {
+		return opt, nil
+	}
+	m := &sourceMatcher{lister: lister, names: lister.SourceNames(), available: make(map[string]bool)}
+
+	var err error
+	for _, mixOpt := range []*config.MixOpt{&opt.Spider, &opt.Wallpaper, &opt.Logo} {
+		if mixOpt.SourcePattern == "" {
+			continue
+		}
+		var matched []string
+		if matched, err = m.match(mixOpt.SourcePattern); err != nil {
+			return opt, err
+		}
+		if len(matched) > 0 {
+			mixOpt.SourceName = matched[0]
+		}
+	}
+
+	for _, arrayOpts := range []*[]config.ArrayMixOpt{
+		&opt.Sites, &opt.DOH, &opt.Lives, &opt.Parses, &opt.Flags, &opt.Rules, &opt.Ads,
+	} {
+		if *arrayOpts, err = expandArrayMixOpts(*arrayOpts, m); err != nil {
+			return opt, err
+		}
+	}
+
+	var extraFields []config.ExtraFieldMixOpt
+	for _, extraField := range opt.ExtraFields {
+		if extraField.SourcePattern == "" {
+			extraFields = append(extraFields, extraField)
+			continue
+		}
+		matched, err := m.match(extraField.SourcePattern)
+		if err != nil {
+			return opt, err
+		}
+		for _, name := range matched {
+			expanded := extraField
+			expanded.SourceName = name
+			extraFields = append(extraFields, expanded)
+		}
+	}
+	opt.ExtraFields = extraFields
+
+	return opt, nil
+}
 
+// expandArrayMixOpts 将带有 source_pattern 的配置展开为每个可用的匹配源各一份配置
+func expandArrayMixOpts(opts []config.ArrayMixOpt, m *sourceMatcher) ([]config.ArrayMixOpt, error) {
+	result := make([]config.ArrayMixOpt, 0, len(opts))
+	for _, opt := range opts {
+		if opt.SourcePattern == "" {
+			result = append(result, opt)
+			continue
+		}
+		matched, err := m.match(opt.SourcePattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range matched {
+			expanded := opt
+			expanded.SourceName = name
+			result = append(result, expanded)
+		}
+	}
 	return result, nil
 }
 
+// sourceMatcher 按正则匹配源名称, 并跳过不可用的源, 同一次解析中每个源只检查一次
+type sourceMatcher struct {
+	lister    SourceLister
+	names     []string
+	available map[string]bool
+}
+
+func (m *sourceMatcher) match(pattern string) ([]string, error) {
+	matched, err := matchSourceNames(pattern, m.names)
+	if err != nil {
+		return nil, err
+	}
+
+	result := matched[:0]
+	for _, name := range matched {
+		available, ok := m.available[name]
+		if !ok {
+			available = m.lister.SourceAvailable(name)
+			m.available[name] = available
+		}
+		if available {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+func matchSourceNames(pattern string, names []string) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source pattern %s: %w", pattern, err)
+	}
+
+	var matched []string
+	for _, name := range names {
+		if re.MatchString(name) {
+			matched = append(matched, name)
+		}
+	}
+	return matched, nil
+}
+
 // mixField 混合单个字段
 func mixField(opt config.MixOpt, sourcer Sourcer) (string, error) {
 	value, _, err := mixFieldAndGetSource(opt, sourcer)
@@ -200,6 +373,179 @@ func mixArrayFieldAndGetSource[T any](opt config.ArrayMixOpt, sourcer Sourcer) (
 	return result, source, nil
 }
 
+// mixLivesAndGetSource 混合 lives 字段
+// m3u 与 live_txt 源中的频道按分组转换为内嵌 group/channels 的 lives 条目, 并按频道名称 include/exclude 过滤
+// 启用频道名称规范化时, 频道名称替换为规范名称, 同一分组中的同名频道合并地址
+func mixLivesAndGetSource(opt config.ArrayMixOpt, sourcer Sourcer, o *mixOptions) ([]config.TvBoxLive, *Source, error) {
+	source, err := sourcer.GetSource(opt.SourceName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting source %s: %w", opt.SourceName, err)
+	}
+	if source.Type() != config.SourceTypeM3U && source.Type() != config.SourceTypeLiveTXT {
+		return mixArrayFieldAndGetSource[config.TvBoxLive](opt, sourcer)
+	}
+
+	playlist, _, err := parseChannelPlaylist(source)
+	if err != nil {
+		return nil, source, err
+	}
+
+	includeRegex := compileRegex(opt.Include)
+	excludeRegex := compileRegex(opt.Exclude)
+	tracks := playlist.Tracks[:0]
+	for _, track := range playlist.Tracks {
+		o.normalizeTrack(&track)
+		if matchFilter(track.Name, includeRegex, excludeRegex) {
+			tracks = append(tracks, track)
+		}
+	}
+	playlist.Tracks = tracks
+
+	return playlistToLives(&playlist), source, nil
+}
+
+// mixAds 混合 ads 字段, 广告拦截列表源会被解析为域名并按 include/exclude 过滤
+func mixAds(opt config.ArrayMixOpt, sourcer Sourcer) ([]string, error) {
+	source, err := sourcer.GetSource(opt.SourceName)
+	if err != nil {
+		return nil, fmt.Errorf("getting source %s: %w", opt.SourceName, err)
+	}
+	if source.Type() != config.SourceTypeAdblock {
+		return mixArrayField[string](opt, sourcer)
+	}
+
+	var includeRegex, excludeRegex *regexp.Regexp
+	if opt.Include != "" {
+		if includeRegex, err = regexp.Compile(opt.Include); err != nil {
+			return nil, fmt.Errorf("invalid include regex: %w", err)
+		}
+	}
+	if opt.Exclude != "" {
+		if excludeRegex, err = regexp.Compile(opt.Exclude); err != nil {
+			return nil, fmt.Errorf("invalid exclude regex: %w", err)
+		}
+	}
+
+	var result []string
+	for _, domain := range adblock.Parse(source.Data()) {
+		if matchFilter(domain, includeRegex, excludeRegex) {
+			result = append(result, domain)
+		}
+	}
+	return result, nil
+}
+
+// dedupeStrings 移除重复的字符串, 保持首次出现的顺序
+func dedupeStrings(values []string) []string {
+	if len(values) == 0 {
+		return values
+	}
+	seen := make(map[string]bool, len(values))
+	result := values[:0]
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// mixExtraFields 按配置混合任意顶层字段
+// 与已建模字段同名时，在该字段已混合的结果上追加或合并，结果写回对应的结构体字段
+func mixExtraFields(opts []config.ExtraFieldMixOpt, sourcer Sourcer, result *config.TvBoxRepoConfig) error {
+	for _, opt := range opts {
+		if opt.Disabled || opt.SourceName == "" || opt.Key == "" {
+			continue
+		}
+
+		source, err := sourcer.GetSource(opt.SourceName)
+		if err != nil {
+			return fmt.Errorf("getting source %s: %w", opt.SourceName, err)
+		}
+
+		value := gjson.GetBytes(source.Data(), opt.Field)
+		if !value.Exists() {
+			// 如果字段不存在，跳过而不是报错
+			continue
+		}
+
+		current, hasCurrent := result.RawField(opt.Key)
+		var mixed string
+
+		switch opt.Mode {
+		case config.ExtraFieldModeAppend, config.ExtraFieldModeFilter:
+			if !value.IsArray() {
+				continue
+			}
+			items := value.Array()
+			if opt.Mode == config.ExtraFieldModeFilter {
+				items, err = filterArray(items, opt.ArrayMixOpt)
+				if err != nil {
+					return fmt.Errorf("filtering %s: %w", opt.Key, err)
+				}
+			}
+			if hasCurrent && gjson.ParseBytes(current).IsArray() {
+				items = append(gjson.ParseBytes(current).Array(), items...)
+			}
+			mixed = joinRawArray(items)
+		case config.ExtraFieldModeMerge:
+			if !value.IsObject() {
+				continue
+			}
+			mixed = value.Raw
+			if hasCurrent && gjson.ParseBytes(current).IsObject() {
+				mixed = deepMergeObject(gjson.ParseBytes(current), value)
+			}
+		case config.ExtraFieldModeReplace:
+			mixed = value.Raw
+		default:
+			return fmt.Errorf("unknown mode %q for extra field %s", opt.Mode, opt.Key)
+		}
+
+		result.SetRawField(opt.Key, json.RawMessage(mixed))
+	}
+
+	return nil
+}
+
+func joinRawArray(items []gjson.Result) string {
+	raws := make([]string, 0, len(items))
+	for _, item := range items {
+		raws = append(raws, item.Raw)
+	}
+	return "[" + strings.Join(raws, ",") + "]"
+}
+
+// deepMergeObject 深度合并两个 JSON 对象，src 中的值优先，保留 dst 的字段顺序
+func deepMergeObject(dst, src gjson.Result) string {
+	srcMap := src.Map()
+	merged := make([]string, 0, len(srcMap))
+	seen := make(map[string]bool, len(srcMap))
+
+	dst.ForEach(func(key, value gjson.Result) bool {
+		raw := value.Raw
+		if srcValue, ok := srcMap[key.Str]; ok {
+			seen[key.Str] = true
+			raw = srcValue.Raw
+			if value.IsObject() && srcValue.IsObject() {
+				raw = deepMergeObject(value, srcValue)
+			}
+		}
+		merged = append(merged, key.Raw+":"+raw)
+		return true
+	})
+
+	src.ForEach(func(key, value gjson.Result) bool {
+		if !seen[key.Str] {
+			merged = append(merged, key.Raw+":"+value.Raw)
+		}
+		return true
+	})
+
+	return "{" + strings.Join(merged, ",") + "}"
+}
+
 // filterArray 根据配置过滤数组
 func filterArray(array []gjson.Result, opt config.ArrayMixOpt) ([]gjson.Result, error) {
 	var includeRegex, excludeRegex *regexp.Regexp
@@ -240,8 +586,9 @@ func filterArray(array []gjson.Result, opt config.ArrayMixOpt) ([]gjson.Result,
 
 // MixMultiRepo 函数根据配置混合多个多仓源
 func MixMultiRepo(
-	cfg *config.Config, sourcer Sourcer,
+	cfg *config.Config, sourcer Sourcer, opts ...MixOption,
 ) (*config.TvBoxMultiRepoConfig, error) {
+	o := newMixOptions(opts)
 	multiRepoOpt := cfg.TvBoxMultiRepoOpt
 
 	result := &config.TvBoxMultiRepoConfig{
@@ -256,7 +603,29 @@ func MixMultiRepo(
 		})
 	}
 
-	for _, repoMixOpt := range multiRepoOpt.Repos {
+	repos, err := mixUpstreamRepos(cfg, sourcer)
+	if err != nil {
+		return result, err
+	}
+
+	for _, repo := range repos {
+		if o.repoProber != nil {
+			var ok bool
+			if repo, ok = o.repoProber.apply(repo, multiRepoOpt.HealthCheck); !ok {
+				continue
+			}
+		}
+		result.Repos = append(result.Repos, repo)
+	}
+
+	return result, nil
+}
+
+// mixUpstreamRepos 混合上游多仓源中的仓库条目
+func mixUpstreamRepos(cfg *config.Config, sourcer Sourcer) ([]config.TvBoxRepoURLConfig, error) {
+	var result []config.TvBoxRepoURLConfig
+
+	for _, repoMixOpt := range cfg.TvBoxMultiRepoOpt.Repos {
 		if !repoMixOpt.Disabled {
 			repos, source, err := mixArrayFieldAndGetSource[config.TvBoxRepoURLConfig](repoMixOpt, sourcer)
 			if err != nil {
@@ -264,7 +633,7 @@ func MixMultiRepo(
 			}
 			for i := range repos {
 				repo := processMultiRepoFields(repos[i], source)
-				result.Repos = append(result.Repos, repo)
+				result = append(result, repo)
 			}
 		}
 	}
@@ -295,6 +664,34 @@ func fullFillURL(url string, source *Source) string {
 	return url
 }
 
+// getSourceSpider 获取源顶层的 spider 地址
+func getSourceSpider(source *Source) string {
+	spider := gjson.GetBytes(source.Data(), "spider").String()
+	if spider == "" {
+		return ""
+	}
+	return fullFillURL(spider, source)
+}
+
+// isSameSpider 判断两个 spider 是否指向同一个 jar，忽略校验信息
+func isSameSpider(a, b string) bool {
+	return strings.Split(a, ";")[0] == strings.Split(b, ";")[0]
+}
+
+// newSelfDOH 生成指向本服务 /dns-query 的 doh 条目
+// 外部地址的主机为 IP 时一并填入 ips, 免去客户端对本服务域名的解析
+func newSelfDOH(cfg *config.Config) config.TvBoxDOH {
+	doh := config.TvBoxDOH{
+		Name: cfg.DOHOpt.Name,
+		URL:  getExternalURL(cfg) + "/dns-query",
+		IPs:  []string{},
+	}
+	if u, err := url.Parse(doh.URL); err == nil && net.ParseIP(u.Hostname()) != nil {
+		doh.IPs = append(doh.IPs, u.Hostname())
+	}
+	return doh
+}
+
 func processSiteFields(item config.TvBoxSite, source *Source) config.TvBoxSite {
 	if strings.HasPrefix(item.API, "./") {
 		item.API = fullFillURL(item.API, source)
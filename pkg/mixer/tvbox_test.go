@@ -1,12 +1,15 @@
 package mixer
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tidwall/gjson"
 	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/doh"
 )
 
 // MockSourcer 是一个模拟的 Sourcer 实现
@@ -262,3 +265,353 @@ func TestMixMultiRepo(t *testing.T) {
 	assert.NotNil(t, result)
 	assert.Len(t, result.Repos, 2) // 1 from single repo + 1 from existing multi_source
 }
+
+func TestMixRepo_InjectJar(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				config: config.Source{URL: "http://example1.com/repo/config.json"},
+				data:   []byte(`{"spider":"./spider1.jar;md5;abc","sites":[{"key":"site1","name":"Site 1","type":3}]}`),
+			},
+			"source2": {
+				config: config.Source{URL: "http://example2.com/config.json"},
+				data: []byte(`{"spider":"http://example2.com/spider2.jar",` +
+					`"sites":[{"key":"site2","name":"Site 2","type":3},` +
+					`{"key":"site3","name":"Site 3","type":3,"jar":"http://example2.com/custom.jar"}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "source1", Field: "spider"},
+			Sites: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "source1", Field: "sites"}},
+				{MixOpt: config.MixOpt{SourceName: "source2", Field: "sites"}},
+			},
+		},
+	}
+
+	result, err := MixTvBoxRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example1.com/repo/spider1.jar;md5;abc", result.Spider)
+	assert.Len(t, result.Sites, 3)
+	assert.Empty(t, result.Sites[0].Jar)
+	assert.Equal(t, "http://example2.com/spider2.jar", result.Sites[1].Jar)
+	assert.Equal(t, "http://example2.com/custom.jar", result.Sites[2].Jar)
+	assert.Equal(t, []string{"site2"}, result.JarInjectedSites)
+}
+
+func TestMixRepo_PreserveUnknownFields(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				data: []byte(`{"spider":"spider1","sites":[{"key":"site1","name":"Site 1","type":3,"hide":1,` +
+					`"style":{"type":"list"}}],"parses":[{"name":"p1","type":1,"url":"http://p1","ua":"x"}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Spider: config.MixOpt{SourceName: "source1", Field: "spider"},
+			Sites:  []config.ArrayMixOpt{{MixOpt: config.MixOpt{SourceName: "source1", Field: "sites"}}},
+			Parses: []config.ArrayMixOpt{{MixOpt: config.MixOpt{SourceName: "source1", Field: "parses"}}},
+		},
+	}
+
+	result, err := MixTvBoxRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(result)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), gjson.GetBytes(data, "sites.0.hide").Int())
+	assert.Equal(t, "list", gjson.GetBytes(data, "sites.0.style.type").String())
+	assert.Equal(t, "x", gjson.GetBytes(data, "parses.0.ua").String())
+}
+
+func TestMixRepo_ExtraFields(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				data: []byte(`{"ijk":[{"group":"软解码","options":[]}],"hosts":["a.com=1.1.1.1"],` +
+					`"custom":{"a":1,"nested":{"x":1,"y":1}},"warningText":"from source1"}`),
+			},
+			"source2": {
+				data: []byte(`{"ijk":[{"group":"硬解码","options":[]},{"group":"测试","options":[]}],` +
+					`"hostList":["b.com=2.2.2.2"],"custom":{"b":2,"nested":{"y":2}}}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			ExtraFields: []config.ExtraFieldMixOpt{
+				{ArrayMixOpt: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source1"}}, Key: "ijk", Mode: "append"},
+				{
+					ArrayMixOpt: config.ArrayMixOpt{
+						MixOpt:   config.MixOpt{SourceName: "source2"},
+						FilterBy: "group",
+						Exclude:  "测试",
+					},
+					Key:  "ijk",
+					Mode: "filter",
+				},
+				{ArrayMixOpt: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source1"}}, Key: "hosts", Mode: "append"},
+				{
+					ArrayMixOpt: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source2"}},
+					Key:         "hosts",
+					Path:        "hostList",
+					Mode:        "append",
+				},
+				{ArrayMixOpt: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source1"}}, Key: "custom", Mode: "merge"},
+				{ArrayMixOpt: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source2"}}, Key: "custom", Mode: "merge"},
+				{ArrayMixOpt: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source1"}}, Key: "warningText"},
+				{ArrayMixOpt: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source2"}}, Key: "not_exist"},
+			},
+		},
+	}
+	cfg.Fixture()
+
+	result, err := MixTvBoxRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(result)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `["软解码","硬解码"]`, gjson.GetBytes(data, "ijk.#.group").Raw)
+	assert.Equal(t, `["a.com=1.1.1.1","b.com=2.2.2.2"]`, gjson.GetBytes(data, "hosts").Raw)
+	assert.JSONEq(t, `{"a":1,"nested":{"x":1,"y":2},"b":2}`, gjson.GetBytes(data, "custom").Raw)
+	assert.Equal(t, "from source1", gjson.GetBytes(data, "warningText").String())
+	assert.False(t, gjson.GetBytes(data, "not_exist").Exists())
+	assert.Equal(t, "from source1", result.WarningText)
+	assert.Len(t, result.IJK, 2)
+
+	t.Run("ModelledField", func(t *testing.T) {
+		// 已建模字段在混合结果上追加, 而不是被替换
+		mockSourcer.sources["source1"].data = []byte(`{"sites":[{"key":"site1","name":"Site 1","type":3}]}`)
+		mockSourcer.sources["source2"].data = []byte(`{"sites":[{"key":"site2","name":"Site 2","type":3}]}`)
+		cfg := &config.Config{
+			TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+				Sites: []config.ArrayMixOpt{{MixOpt: config.MixOpt{SourceName: "source1"}}},
+				ExtraFields: []config.ExtraFieldMixOpt{
+					{ArrayMixOpt: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source2"}}, Key: "sites", Mode: "append"},
+				},
+			},
+		}
+		cfg.Fixture()
+
+		result, err := MixTvBoxRepo(cfg, mockSourcer)
+		assert.NoError(t, err)
+		assert.Len(t, result.Sites, 2)
+		assert.Equal(t, "site2", result.Sites[1].Key)
+		assert.NotContains(t, result.Extra, "sites")
+	})
+
+	t.Run("UnknownMode", func(t *testing.T) {
+		_, err := MixTvBoxRepo(&config.Config{
+			TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+				ExtraFields: []config.ExtraFieldMixOpt{
+					{ArrayMixOpt: config.ArrayMixOpt{MixOpt: config.MixOpt{SourceName: "source1", Field: "sites"}}, Key: "sites", Mode: "appned"},
+				},
+			},
+		}, mockSourcer)
+		assert.ErrorContains(t, err, `unknown mode "appned"`)
+	})
+}
+
+func TestMixRepo_M3ULive(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				data: []byte(`{"lives":[{"name":"live1","url":"http://example.com/live1.m3u"}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://proxy.local",
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			M3ULive: config.M3ULiveOpt{Enable: true},
+			Lives: []config.ArrayMixOpt{{
+				MixOpt: config.MixOpt{SourceName: "source1", Field: "lives"},
+			}},
+		},
+	}
+
+	result, err := MixTvBoxRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Len(t, result.Lives, 2)
+	assert.Equal(t, "Tv MixProxy", result.Lives[0].Name)
+	assert.Equal(t, "http://proxy.local/v1/m3u/media_playlist", result.Lives[0].URL)
+	assert.Equal(t, "live1", result.Lives[1].Name)
+
+	// M3U 禁用时不生成 lives 条目
+	cfg.M3UOpt.Disable = true
+	result, err = MixTvBoxRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Len(t, result.Lives, 1)
+}
+
+func TestMixRepo_LiveEPG(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				data: []byte(`{"lives":[{"name":"live1","url":"http://example.com/live1.m3u","epg":"http://upstream/epg"},` +
+					`{"name":"live2","url":"http://example.com/live2.m3u"}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://proxy.local",
+		EPGOpt:      config.EPGOpt{LiveEPG: true, LiveLogo: true},
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Lives: []config.ArrayMixOpt{{
+				MixOpt: config.MixOpt{SourceName: "source1", Field: "lives"},
+			}},
+		},
+	}
+
+	result, err := MixTvBoxRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	// 上游已声明的 epg 保持不变, 只补全缺失的字段
+	assert.Equal(t, "http://upstream/epg", result.Lives[0].EPG)
+	assert.Equal(t, "http://proxy.local/v1/epg/logo?ch={name}", result.Lives[0].Logo)
+	assert.Equal(t, "http://proxy.local/v1/epg/diyp?ch={name}&date={date}", result.Lives[1].EPG)
+	assert.Equal(t, "http://proxy.local/v1/epg/logo?ch={name}", result.Lives[1].Logo)
+
+	cfg.EPGOpt.LiveEPG = false
+	cfg.EPGOpt.LiveLogo = false
+	result, err = MixTvBoxRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://upstream/epg", result.Lives[0].EPG)
+	assert.Empty(t, result.Lives[0].Logo)
+	assert.Empty(t, result.Lives[1].EPG)
+}
+
+func TestMixRepo_SelfDOH(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				data: []byte(`{"doh":[{"name":"Google","url":"https://dns.google/dns-query","ips":["8.8.8.8"]}]}`),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		ExternalURL: "http://192.168.1.2:8080",
+		DOHOpt:      config.DOHOpt{Enable: true, Inject: true},
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			DOH: []config.ArrayMixOpt{{MixOpt: config.MixOpt{SourceName: "source1"}}},
+		},
+	}
+	cfg.Fixture()
+
+	// 解析器初始化失败时不注入, 避免客户端使用不可用的 /dns-query
+	result, err := MixTvBoxRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Len(t, result.DOH, 1)
+
+	resolver, err := doh.NewResolver([]string{"udp://127.0.0.1:53"}, time.Second, 0, nil)
+	assert.NoError(t, err)
+	result, err = MixTvBoxRepo(cfg, mockSourcer, WithDOHResolver(resolver))
+	assert.NoError(t, err)
+	assert.Len(t, result.DOH, 2)
+	assert.Equal(t, "Tv MixProxy", result.DOH[0].Name)
+	assert.Equal(t, "http://192.168.1.2:8080/dns-query", result.DOH[0].URL)
+	assert.Equal(t, []string{"192.168.1.2"}, result.DOH[0].IPs)
+	assert.Equal(t, "Google", result.DOH[1].Name)
+
+	cfg.ExternalURL = "http://proxy.local"
+	result, err = MixTvBoxRepo(cfg, mockSourcer, WithDOHResolver(resolver))
+	assert.NoError(t, err)
+	assert.Empty(t, result.DOH[0].IPs)
+
+	cfg.DOHOpt.Inject = false
+	result, err = MixTvBoxRepo(cfg, mockSourcer, WithDOHResolver(resolver))
+	assert.NoError(t, err)
+	assert.Len(t, result.DOH, 1)
+}
+
+func TestMixRepo_AdblockAds(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"source1": {
+				data: []byte(`{"ads":["mimg.0c1q0l.cn","ads.example.com"]}`),
+			},
+			"hosts": {
+				config: config.Source{Type: config.SourceTypeAdblock},
+				data: []byte("# hosts\n127.0.0.1 localhost\n0.0.0.0 ads.example.com\n0.0.0.0 tracker.example.org\n" +
+					"0.0.0.0 cdn.example.net\n"),
+			},
+			"adblock": {
+				config: config.Source{Type: config.SourceTypeAdblock},
+				data:   []byte("! list\n||doubleclick.net^\n||tracker.example.org^\n@@||good.example.com^\n"),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Ads: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "source1"}},
+				{MixOpt: config.MixOpt{SourceName: "hosts"}, Exclude: `^cdn\.`},
+				{MixOpt: config.MixOpt{SourceName: "adblock"}},
+			},
+		},
+	}
+	cfg.Fixture()
+
+	result, err := MixTvBoxRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"mimg.0c1q0l.cn",
+		"ads.example.com",
+		"tracker.example.org",
+		"doubleclick.net",
+	}, result.Ads)
+
+	cfg.TvBoxSingleRepoOpt.Ads[2].Include = "("
+	_, err = MixTvBoxRepo(cfg, mockSourcer)
+	assert.Error(t, err)
+}
+
+func TestMixRepo_ChannelLives(t *testing.T) {
+	mockSourcer := &MockSourcer{
+		sources: map[string]*Source{
+			"txt_source": {
+				config: config.Source{Type: config.SourceTypeLiveTXT},
+				data: []byte("央视频道,#genre#\n" +
+					"CCTV1,http://example.com/cctv1.m3u8#http://backup.example.com/cctv1.m3u8\n" +
+					"CCTV5,http://example.com/cctv5.m3u8\n"),
+			},
+			"m3u_source": {
+				config: config.Source{Type: config.SourceTypeM3U},
+				data: []byte("#EXTM3U\n" +
+					"#EXTINF:-1 group-title=\"卫视\",湖南卫视\nhttp://example.com/hunan.m3u8\n"),
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		TvBoxSingleRepoOpt: config.TvBoxSingleRepoOpt{
+			Lives: []config.ArrayMixOpt{
+				{MixOpt: config.MixOpt{SourceName: "txt_source"}, Exclude: "CCTV5"},
+				{MixOpt: config.MixOpt{SourceName: "m3u_source"}},
+			},
+		},
+	}
+	cfg.Fixture()
+
+	result, err := MixTvBoxRepo(cfg, mockSourcer)
+	assert.NoError(t, err)
+	assert.Equal(t, []config.TvBoxLive{
+		{Group: "央视频道", Channels: []config.TvBoxLiveChannel{
+			{Name: "CCTV1", URLs: []string{"http://example.com/cctv1.m3u8", "http://backup.example.com/cctv1.m3u8"}},
+		}},
+		{Group: "卫视", Channels: []config.TvBoxLiveChannel{
+			{Name: "湖南卫视", URLs: []string{"http://example.com/hunan.m3u8"}},
+		}},
+	}, result.Lives)
+}
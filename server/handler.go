@@ -2,17 +2,25 @@ package server
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/xml"
+	"errors"
 	"image/png"
+	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/adaptor"
 
 	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/doh"
+	"github.com/wayjam/tv-mixproxy/pkg/epg"
 	"github.com/wayjam/tv-mixproxy/pkg/imageutil"
-	"github.com/wayjam/tv-mixproxy/pkg/m3u"
 	"github.com/wayjam/tv-mixproxy/pkg/mixer"
 )
 
@@ -89,28 +97,32 @@ func Wallpaper(c fiber.Ctx) error {
 	return png.Encode(c, img)
 }
 
-func NewRepoHandler(cfg *config.Config, sourceManager *mixer.SourceManager) fiber.Handler {
+func NewRepoHandler(cfg *config.Config, sourceManager *mixer.SourceManager, opts ...mixer.MixOption) fiber.Handler {
 	return func(c fiber.Ctx) error {
 		if cfg.TvBoxSingleRepoOpt.Disable {
 			return c.Status(fiber.StatusNotImplemented).SendString("SingleRepo is disabled")
 		}
 
-		result, err := mixer.MixTvBoxRepo(cfg, sourceManager)
+		result, err := mixer.MixTvBoxRepo(cfg, sourceManager, opts...)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
 
+		if len(result.JarInjectedSites) > 0 {
+			c.Set("X-Tv-Mixproxy-Jar-Injected", strings.Join(result.JarInjectedSites, ","))
+		}
+
 		return c.JSON(result)
 	}
 }
 
-func NewMultiRepoHandler(cfg *config.Config, sourceManager *mixer.SourceManager) fiber.Handler {
+func NewMultiRepoHandler(cfg *config.Config, sourceManager *mixer.SourceManager, opts ...mixer.MixOption) fiber.Handler {
 	return func(c fiber.Ctx) error {
 		if cfg.TvBoxMultiRepoOpt.Disable {
 			return c.Status(fiber.StatusNotImplemented).SendString("MultiRepo is disabled")
 		}
 
-		result, err := mixer.MixMultiRepo(cfg, sourceManager)
+		result, err := mixer.MixMultiRepo(cfg, sourceManager, opts...)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
@@ -119,29 +131,89 @@ func NewMultiRepoHandler(cfg *config.Config, sourceManager *mixer.SourceManager)
 	}
 }
 
-func NewSpiderHandler(cfg *config.Config, sourceManager *mixer.SourceManager) fiber.Handler {
-	handler, err := mixer.NewMixURLHandler(cfg.TvBoxSingleRepoOpt.Spider, sourceManager)
-
+func NewCMSSearchHandler(cfg *config.Config, search *mixer.CMSSearch) fiber.Handler {
 	return func(c fiber.Ctx) error {
+		if cfg.TvBoxSingleRepoOpt.Disable || !cfg.TvBoxSingleRepoOpt.SearchSite.Enable {
+			return c.Status(fiber.StatusNotImplemented).SendString("Search site is disabled")
+		}
+
+		query := url.Values{}
+		for key, value := range c.Queries() {
+			query.Set(key, value)
+		}
+
+		result, err := search.Query(query)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
 
+		return c.JSON(result)
+	}
+}
+
+func NewSiteHealthHandler(siteProber *mixer.SiteProber) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if siteProber == nil {
+			return c.Status(fiber.StatusNotImplemented).SendString("Site health check is disabled")
+		}
+
+		return c.JSON(siteProber.Results())
+	}
+}
+
+func NewMultiRepoHealthHandler(repoProber *mixer.RepoProber) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if repoProber == nil {
+			return c.Status(fiber.StatusNotImplemented).SendString("MultiRepo health check is disabled")
+		}
+
+		return c.JSON(repoProber.Results())
+	}
+}
+
+func NewSpiderHandler(cfg *config.Config, sourceManager *mixer.SourceManager, opts ...mixer.MixOption) fiber.Handler {
+	// 目标地址在每次请求时根据当前的源数据解析，源刷新后自动跟随上游变化
+	handler := mixer.NewMixURLHandler(cfg.TvBoxSingleRepoOpt.Spider, sourceManager, opts...)
+
+	return func(c fiber.Ctx) error {
 		// Convert fiber.Ctx to http.ResponseWriter and *http.Request
 		return adaptor.HTTPHandler(handler)(c)
 	}
 }
 
-func NewEPGHandler(cfg *config.Config, sourceManager *mixer.SourceManager) fiber.Handler {
+func NewJarHandler(jarCache *mixer.JarCache) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if jarCache == nil {
+			return c.Status(fiber.StatusNotImplemented).SendString("JarCache is disabled")
+		}
+
+		return adaptor.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			jarCache.ServeJar(w, r, c.Params("hash"))
+		})(c)
+	}
+}
+
+func NewDOHHandler(resolver *doh.Resolver) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if resolver == nil {
+			return c.Status(fiber.StatusNotImplemented).SendString("DoH is disabled")
+		}
+
+		return adaptor.HTTPHandler(resolver)(c)
+	}
+}
+
+func NewEPGHandler(cfg *config.Config, epgCache *mixer.EPGCache) fiber.Handler {
 	return func(c fiber.Ctx) error {
 		if cfg.EPGOpt.Disable {
 			return c.Status(fiber.StatusNotImplemented).SendString("EPG is disabled")
 		}
 
-		result, err := mixer.MixEPG(cfg, sourceManager)
+		guide, err := epgCache.Get()
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
+		result := guide.EPG
 
 		format := c.Query("format", "xml")
 
@@ -164,23 +236,167 @@ func NewEPGHandler(cfg *config.Config, sourceManager *mixer.SourceManager) fiber
 	}
 }
 
-func NewM3UMediaHandler(cfg *config.Config, sourceManager *mixer.SourceManager) fiber.Handler {
+func NewEPGDIYPHandler(cfg *config.Config, epgCache *mixer.EPGCache) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if cfg.EPGOpt.Disable {
+			return c.Status(fiber.StatusNotImplemented).SendString("EPG is disabled")
+		}
+
+		guide, err := epgCache.Get()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+
+		channel, ok := guide.FindChannel(c.Query("ch"))
+		if !ok {
+			return c.Status(fiber.StatusNotFound).SendString("Channel not found")
+		}
+
+		date := time.Now()
+		if c.Query("date") != "" {
+			date, err = time.ParseInLocation(epg.DIYPDateLayout, c.Query("date"), time.Local)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid date")
+			}
+		}
+
+		return c.JSON(guide.DIYP(channel, date, time.Local))
+	}
+}
+
+func NewEPGLogoHandler(
+	cfg *config.Config, epgCache *mixer.EPGCache, logos *mixer.LogoManager, opts ...mixer.MixOption,
+) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if cfg.EPGOpt.Disable && logos == nil {
+			return c.Status(fiber.StatusNotImplemented).SendString("EPG is disabled")
+		}
+
+		var guide *mixer.MixedEPG
+		if !cfg.EPGOpt.Disable {
+			var err error
+			guide, err = epgCache.Get()
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+			}
+		}
+
+		// 配置了台标补全时使用本地台标目录与 EPG icon, 并按需通过本服务代理
+		if logos != nil {
+			logo, ok := logos.Lookup(c.Query("ch"), guide, opts...)
+			if !ok {
+				return c.Status(fiber.StatusNotFound).SendString("Logo not found")
+			}
+			return c.Redirect().Status(fiber.StatusFound).To(logo)
+		}
+
+		channel, ok := guide.FindChannel(c.Query("ch"))
+		if !ok || channel.Icon == nil || channel.Icon.Src == "" {
+			return c.Status(fiber.StatusNotFound).SendString("Logo not found")
+		}
+
+		return c.Redirect().Status(fiber.StatusFound).To(channel.Icon.Src)
+	}
+}
+
+// NewLogoBadgeHandler 生成频道的占位台标, 以频道名称的缩写绘制在由名称决定的底色上
+func NewLogoBadgeHandler(cfg *config.Config) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if !cfg.LogoOpt.Placeholder {
+			return c.Status(fiber.StatusNotImplemented).SendString("Logo placeholder is disabled")
+		}
+
+		name := c.Query("ch")
+		label := c.Query("label", imageutil.BadgeLabel(name))
+		if utf8.RuneCountInString(label) > imageutil.MaxBadgeLabel {
+			return c.Status(fiber.StatusBadRequest).
+				SendString("label must not exceed " + strconv.Itoa(imageutil.MaxBadgeLabel) + " characters")
+		}
+
+		size := cfg.LogoOpt.Size
+		if size <= 0 {
+			size = 256
+		}
+
+		c.Set("Content-Type", "image/png")
+		c.Set("Cache-Control", "public, max-age="+strconv.Itoa(cfg.LogoOpt.Interval))
+		return png.Encode(c, imageutil.Badge(label, name, size))
+	}
+}
+
+func NewLogoHandler(logos *mixer.LogoManager) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if logos == nil {
+			return c.Status(fiber.StatusNotImplemented).SendString("Logo enrichment is disabled")
+		}
+
+		return adaptor.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logos.ServeLogo(w, r, c.Params("id"))
+		})(c)
+	}
+}
+
+func NewM3UMediaHandler(cfg *config.Config, sourceManager *mixer.SourceManager, opts ...mixer.MixOption) fiber.Handler {
 	return func(c fiber.Ctx) error {
 		if cfg.M3UOpt.Disable {
 			return c.Status(fiber.StatusNotImplemented).SendString("M3U is disabled")
 		}
 
-		result, err := mixer.MixM3UMediaPlayList(cfg, sourceManager)
+		format, err := mixer.ParsePlaylistFormat(c.Query("format"), c.Get(fiber.HeaderAccept))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		result, err := mixer.MixM3UMediaPlayList(cfg, sourceManager, opts...)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
 
-		encoder := m3u.NewEncoder(c)
-		if err := encoder.Encode(result); err != nil {
+		data, err := mixer.EncodePlaylist(cfg, result, format)
+		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
 
-		return nil
+		c.Set(fiber.HeaderContentType, format.ContentType())
+		c.Set(fiber.HeaderVary, fiber.HeaderAccept)
+		return c.Send(data)
+	}
+}
+
+func NewStreamHealthHandler(streamProber *mixer.StreamProber) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if streamProber == nil {
+			return c.Status(fiber.StatusNotImplemented).SendString("Stream health check is disabled")
+		}
+
+		return c.JSON(streamProber.Results())
+	}
+}
+
+func NewStreamRelayHandler(relay *mixer.StreamRelay) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if relay == nil {
+			return c.Status(fiber.StatusNotImplemented).SendString("Stream relay is disabled")
+		}
+
+		// 分片在处理函数返回后才转发, 不使用请求的 context, 上游停滞时由读取超时断开
+		resp, err := relay.Open(context.Background(), c.Params("token"), c.Get(fiber.HeaderRange))
+		if errors.Is(err, mixer.ErrInvalidRelayToken) {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).SendString(err.Error())
+		}
+
+		for key := range resp.Header {
+			c.Set(key, resp.Header.Get(key))
+		}
+		c.Status(resp.StatusCode)
+		if resp.Body == nil {
+			return c.Send(resp.Playlist)
+		}
+		// fasthttp 发送完成或客户端断开后关闭 Body
+		return c.SendStream(resp.Body, int(resp.ContentLength))
 	}
 }
 
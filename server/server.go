@@ -5,6 +5,7 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
 	fiberlog "github.com/gofiber/fiber/v3/log"
@@ -14,6 +15,8 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/wayjam/tv-mixproxy/config"
+	"github.com/wayjam/tv-mixproxy/pkg/chname"
+	"github.com/wayjam/tv-mixproxy/pkg/doh"
 	"github.com/wayjam/tv-mixproxy/pkg/mixer"
 )
 
@@ -21,6 +24,17 @@ type server struct {
 	app           *fiber.App
 	cfg           *config.Config
 	sourceManager *mixer.SourceManager
+	jarCache      *mixer.JarCache
+	repoProber    *mixer.RepoProber
+	siteProber    *mixer.SiteProber
+	streamProber  *mixer.StreamProber
+	numberer      *mixer.ChannelNumberer
+	logos         *mixer.LogoManager
+	relay         *mixer.StreamRelay
+	epgCache      *mixer.EPGCache
+	cmsSearch     *mixer.CMSSearch
+	dohResolver   *doh.Resolver
+	normalizer    *chname.Normalizer
 }
 
 func NewServer(cfg *config.Config) *server {
@@ -60,11 +74,139 @@ func NewServer(cfg *config.Config) *server {
 
 	sourceManager := mixer.NewSourceManager(cfg.Sources, slog.Default())
 
-	return &server{
+	var jarCache *mixer.JarCache
+	if jarCacheOpt := cfg.TvBoxSingleRepoOpt.JarCache; jarCacheOpt.Enable {
+		var err error
+		jarCache, err = mixer.NewJarCache(
+			jarCacheOpt.Dir, time.Duration(jarCacheOpt.Interval)*time.Second, slog.Default(),
+		)
+		if err != nil {
+			slog.Error("failed to initialize jar cache, jar cache is disabled", "error", err)
+		}
+	}
+
+	var repoProber *mixer.RepoProber
+	if !cfg.TvBoxMultiRepoOpt.Disable && cfg.TvBoxMultiRepoOpt.HealthCheck.Enable {
+		repoProber = mixer.NewRepoProber(cfg, sourceManager, slog.Default())
+	}
+
+	var siteProber *mixer.SiteProber
+	if !cfg.TvBoxSingleRepoOpt.Disable && cfg.TvBoxSingleRepoOpt.HealthCheck.Enable {
+		siteProber = mixer.NewSiteProber(cfg, sourceManager, slog.Default())
+	}
+
+	var normalizer *chname.Normalizer
+	if channelNameOpt := cfg.ChannelNameOpt; channelNameOpt.Enable {
+		var err error
+		normalizer, err = chname.LoadNormalizer(channelNameOpt.AliasFile)
+		if err != nil {
+			slog.Error("failed to load channel alias file, using built-in rules only", "error", err)
+			normalizer = chname.NewNormalizer(nil)
+		}
+	}
+
+	var streamProber *mixer.StreamProber
+	if !cfg.M3UOpt.Disable && cfg.M3UOpt.HealthCheck.Enable {
+		// 检查时的过滤条件需与提供播放列表时一样作用于规范化后的频道名称
+		var probeOpts []mixer.MixOption
+		if normalizer != nil {
+			probeOpts = append(probeOpts, mixer.WithChannelNormalizer(normalizer))
+		}
+		streamProber = mixer.NewStreamProber(cfg, sourceManager, slog.Default(), probeOpts...)
+	}
+
+	var numberer *mixer.ChannelNumberer
+	if !cfg.M3UOpt.Disable && cfg.M3UOpt.Numbering.Enable {
+		var err error
+		numberer, err = mixer.NewChannelNumberer(cfg.M3UOpt.Numbering, slog.Default())
+		if err != nil {
+			slog.Error("failed to initialize channel numbering, channel numbering is disabled", "error", err)
+		}
+	}
+
+	var logos *mixer.LogoManager
+	if cfg.LogoOpt.Enabled() {
+		var err error
+		logos, err = mixer.NewLogoManager(cfg, slog.Default())
+		if err != nil {
+			slog.Error("failed to initialize logo manager, logo enrichment is disabled", "error", err)
+		}
+	}
+
+	var relay *mixer.StreamRelay
+	if !cfg.M3UOpt.Disable && cfg.M3UOpt.Relay.Enable {
+		var err error
+		relay, err = mixer.NewStreamRelay(cfg, slog.Default())
+		if err != nil {
+			slog.Error("failed to initialize stream relay, stream relay is disabled", "error", err)
+		}
+	}
+
+	var dohResolver *doh.Resolver
+	if dohOpt := cfg.DOHOpt; dohOpt.Enable {
+		var err error
+		dohResolver, err = doh.NewResolver(
+			dohOpt.Upstreams, time.Duration(dohOpt.Timeout)*time.Second, dohOpt.CacheSize, slog.Default(),
+		)
+		if err != nil {
+			slog.Error("failed to initialize doh resolver, doh is disabled", "error", err)
+		}
+	}
+
+	s := &server{
 		app:           app,
 		cfg:           cfg,
 		sourceManager: sourceManager,
+		jarCache:      jarCache,
+		repoProber:    repoProber,
+		siteProber:    siteProber,
+		streamProber:  streamProber,
+		numberer:      numberer,
+		logos:         logos,
+		relay:         relay,
+		dohResolver:   dohResolver,
+		normalizer:    normalizer,
+	}
+	s.epgCache = mixer.NewEPGCache(cfg, sourceManager, s.mixOptions()...)
+	s.cmsSearch = mixer.NewCMSSearch(cfg, sourceManager, s.mixOptions()...)
+
+	return s
+}
+
+// mixOptions 返回混合时使用的可选依赖
+func (s *server) mixOptions() []mixer.MixOption {
+	var opts []mixer.MixOption
+	if s.jarCache != nil {
+		opts = append(opts, mixer.WithJarCache(s.jarCache))
+	}
+	if s.repoProber != nil {
+		opts = append(opts, mixer.WithRepoProber(s.repoProber))
+	}
+	if s.siteProber != nil {
+		opts = append(opts, mixer.WithSiteProber(s.siteProber))
+	}
+	if s.streamProber != nil {
+		opts = append(opts, mixer.WithStreamProber(s.streamProber))
+	}
+	if s.numberer != nil {
+		opts = append(opts, mixer.WithChannelNumberer(s.numberer))
+	}
+	if s.logos != nil {
+		opts = append(opts, mixer.WithLogoManager(s.logos))
+	}
+	if s.relay != nil {
+		opts = append(opts, mixer.WithStreamRelay(s.relay))
+	}
+	if s.dohResolver != nil {
+		opts = append(opts, mixer.WithDOHResolver(s.dohResolver))
+	}
+	if s.normalizer != nil {
+		opts = append(opts, mixer.WithChannelNormalizer(s.normalizer))
+	}
+	if s.epgCache != nil {
+		opts = append(opts, mixer.WithEPGCache(s.epgCache))
 	}
+	return opts
 }
 
 func (s *server) SetupRoutes() {
@@ -73,13 +215,24 @@ func (s *server) SetupRoutes() {
 	app.Get("/logo", Logo)
 	app.Get("/wallpaper", Wallpaper)
 	app.Get("/refresh_source", RefershSrouceHandler(s.cfg, s.sourceManager))
+	app.Add([]string{fiber.MethodGet, fiber.MethodPost}, "/dns-query", NewDOHHandler(s.dohResolver))
 
 	v1 := app.Group("/v1")
-	v1.Get("/tvbox/repo", NewRepoHandler(s.cfg, s.sourceManager))
-	v1.Get("/tvbox/multi_repo", NewMultiRepoHandler(s.cfg, s.sourceManager))
-	v1.Get("/tvbox/spider", NewSpiderHandler(s.cfg, s.sourceManager))
-	v1.Get("/epg.xml", NewEPGHandler(s.cfg, s.sourceManager))
-	v1.Get("/m3u/media_playlist", NewM3UMediaHandler(s.cfg, s.sourceManager))
+	v1.Get("/tvbox/repo", NewRepoHandler(s.cfg, s.sourceManager, s.mixOptions()...))
+	v1.Get("/tvbox/search", NewCMSSearchHandler(s.cfg, s.cmsSearch))
+	v1.Get("/tvbox/health", NewSiteHealthHandler(s.siteProber))
+	v1.Get("/tvbox/multi_repo", NewMultiRepoHandler(s.cfg, s.sourceManager, s.mixOptions()...))
+	v1.Get("/tvbox/multi_repo/health", NewMultiRepoHealthHandler(s.repoProber))
+	v1.Get("/tvbox/spider", NewSpiderHandler(s.cfg, s.sourceManager, s.mixOptions()...))
+	v1.Add([]string{fiber.MethodGet, fiber.MethodHead}, "/tvbox/jar/:hash", NewJarHandler(s.jarCache))
+	v1.Get("/epg.xml", NewEPGHandler(s.cfg, s.epgCache))
+	v1.Get("/epg/diyp", NewEPGDIYPHandler(s.cfg, s.epgCache))
+	v1.Get("/epg/logo", NewEPGLogoHandler(s.cfg, s.epgCache, s.logos, s.mixOptions()...))
+	v1.Get("/logos/badge", NewLogoBadgeHandler(s.cfg))
+	v1.Add([]string{fiber.MethodGet, fiber.MethodHead}, "/logos/:id", NewLogoHandler(s.logos))
+	v1.Get("/m3u/media_playlist", NewM3UMediaHandler(s.cfg, s.sourceManager, s.mixOptions()...))
+	v1.Get("/m3u/health", NewStreamHealthHandler(s.streamProber))
+	v1.Get("/m3u/relay/:token/:file", NewStreamRelayHandler(s.relay))
 }
 
 func (s *server) App() *fiber.App {